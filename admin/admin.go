@@ -10,11 +10,17 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/podcreep/server/store"
 	"github.com/podcreep/server/util"
 )
 
 type sessionInfo struct{}
 
+// server holds what the admin pages need to do their thing.
+type server struct {
+	db store.Backend
+}
+
 var (
 	sessions = make(map[string]sessionInfo)
 )
@@ -129,7 +135,7 @@ func wrap(fn wrappedRequest) func(http.ResponseWriter, *http.Request) {
 }
 
 // Setup is called from server.go and sets up our routes, etc.
-func Setup(r *mux.Router) error {
+func Setup(r *mux.Router, db store.Backend) error {
 	if err := initTemplates(); err != nil {
 		return err
 	}
@@ -140,19 +146,22 @@ func Setup(r *mux.Router) error {
 	subr := r.PathPrefix("/admin").Subrouter()
 	subr.Use(authMiddleware)
 
+	s := &server{db: db}
 	subr.HandleFunc("/", wrap(handleHome)).Methods("GET")
 	subr.HandleFunc("/login", wrap(handleLogin)).Methods("GET", "POST")
-	subr.HandleFunc("/podcasts", wrap(handlePodcastsList)).Methods("GET")
-	subr.HandleFunc("/podcasts/add", wrap(handlePodcastsAdd)).Methods("GET", "POST")
-	subr.HandleFunc("/podcasts/{id:[0-9]+}", wrap(handlePodcastsEditGet)).Methods("GET")
-	subr.HandleFunc("/podcasts/{id:[0-9]+}", wrap(handlePodcastsEditPost)).Methods("POST")
-	subr.HandleFunc("/podcasts/{id:[0-9]+}", wrap(handlePodcastsDelete)).Methods("DELETE")
-	subr.HandleFunc("/podcasts/{id:[0-9]+}/refresh", wrap(handlePodcastsRefreshPost)).Methods("POST")
-	subr.HandleFunc("/cron", wrap(handleCron)).Methods("GET")
+	subr.HandleFunc("/podcasts", wrap(s.handlePodcastsList)).Methods("GET")
+	subr.HandleFunc("/podcasts/add", wrap(s.handlePodcastsAdd)).Methods("GET", "POST")
+	subr.HandleFunc("/podcasts/{id:[0-9]+}", wrap(s.handlePodcastsEditGet)).Methods("GET")
+	subr.HandleFunc("/podcasts/{id:[0-9]+}", wrap(s.handlePodcastsEditPost)).Methods("POST")
+	subr.HandleFunc("/podcasts/{id:[0-9]+}", wrap(s.handlePodcastsDelete)).Methods("DELETE")
+	subr.HandleFunc("/podcasts/{id:[0-9]+}/refresh", wrap(s.handlePodcastsRefreshPost)).Methods("POST")
+	subr.HandleFunc("/podcasts/{id:[0-9]+}/purge-removed", wrap(s.handlePodcastsPurgeRemovedPost)).Methods("POST")
+	subr.HandleFunc("/podcasts/{id:[0-9]+}/media-cache", wrap(s.handlePodcastsMediaCachePost)).Methods("POST")
+	subr.HandleFunc("/cron", wrap(s.handleCron)).Methods("GET")
 	subr.HandleFunc("/cron/add", wrap(handleCronAdd)).Methods("GET")
-	subr.HandleFunc("/cron/edit", wrap(handleCronEdit)).Methods("GET", "POST")
-	subr.HandleFunc("/cron/{id:[0-9]+}/delete", wrap(handleCronDelete)).Methods("GET", "POST")
-	subr.HandleFunc("/cron/{id:[0-9]+}/run-now", wrap(handleCronRunNow)).Methods("POST")
+	subr.HandleFunc("/cron/edit", wrap(s.handleCronEdit)).Methods("GET", "POST")
+	subr.HandleFunc("/cron/{id:[0-9]+}/delete", wrap(s.handleCronDelete)).Methods("GET", "POST")
+	subr.HandleFunc("/cron/{id:[0-9]+}/run-now", wrap(s.handleCronRunNow)).Methods("POST")
 	subr.HandleFunc("/cron/validate-schedule", wrap(handleCronValidateSchedule)).Methods("GET")
 
 	return nil
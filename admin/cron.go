@@ -14,10 +14,10 @@ import (
 	"github.com/podcreep/server/util"
 )
 
-func handleCron(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleCron(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	cronJobs, err := store.LoadCrobJobs(ctx)
+	cronJobs, err := s.db.LoadCrobJobs(ctx)
 	if err != nil {
 		return err
 	}
@@ -38,7 +38,7 @@ func handleCronAdd(w http.ResponseWriter, r *http.Request) error {
 	return renderEditPage(w, &store.CronJob{})
 }
 
-func handleCronEdit(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleCronEdit(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
 		return renderEditPage(w, &store.CronJob{})
 	}
@@ -70,7 +70,7 @@ func handleCronEdit(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	log.Printf("Saving: %v\n", cronJob)
-	err = store.SaveCronJob(r.Context(), cronJob)
+	err = s.db.SaveCronJob(r.Context(), cronJob)
 	if err != nil {
 		return err
 	}
@@ -79,7 +79,7 @@ func handleCronEdit(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func handleCronDelete(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleCronDelete(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 
@@ -88,13 +88,13 @@ func handleCronDelete(w http.ResponseWriter, r *http.Request) error {
 		return httpError(err.Error(), http.StatusBadRequest)
 	}
 
-	cronJob, err := store.LoadCrobJob(ctx, cronID)
+	cronJob, err := s.db.LoadCrobJob(ctx, cronID)
 	if err != nil {
 		return err
 	}
 
 	if r.Method == "POST" {
-		if err := store.DeleteCronJob(ctx, cronID); err != nil {
+		if err := s.db.DeleteCronJob(ctx, cronID); err != nil {
 			return err
 		}
 
@@ -107,7 +107,7 @@ func handleCronDelete(w http.ResponseWriter, r *http.Request) error {
 	})
 }
 
-func handleCronRunNow(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleCronRunNow(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 
@@ -116,7 +116,7 @@ func handleCronRunNow(w http.ResponseWriter, r *http.Request) error {
 		return httpError(err.Error(), http.StatusBadRequest)
 	}
 
-	cronJob, err := store.LoadCrobJob(ctx, cronID)
+	cronJob, err := s.db.LoadCrobJob(ctx, cronID)
 	if err != nil {
 		return err
 	}
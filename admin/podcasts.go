@@ -2,42 +2,58 @@ package admin
 
 import (
 	"context"
-	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/schema"
 	"github.com/podcreep/server/cron"
+	"github.com/podcreep/server/fetch"
 	"github.com/podcreep/server/rss"
 	"github.com/podcreep/server/store"
 	"github.com/podcreep/server/util"
 )
 
-var (
-	// http.Client we'll use to make HTTP requests.
-	httpClient = &http.Client{}
+const (
+	// podcastsPageLimit is the number of podcasts we show on each page of the podcast list, unless
+	// the "limit" query parameter says otherwise.
+	podcastsPageLimit = 50
 )
 
-func handlePodcastsList(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handlePodcastsList(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
+	page := store.Page{Cursor: r.URL.Query().Get("cursor"), Limit: podcastsPageLimit}
+	if str := r.URL.Query().Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit <= 0 {
+			return httpError("invalid limit", http.StatusBadRequest)
+		}
+		page.Limit = limit
+	}
+
 	log.Printf("loading podcasts...\n")
-	podcasts, err := store.LoadPodcasts(ctx)
-	if err != nil {
+	podcasts, nextCursor, err := s.db.LoadPodcasts(ctx, page)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		return httpError(err.Error(), http.StatusBadRequest)
+	} else if err != nil {
 		return err
 	}
 
 	return render(w, "podcast/list.html", map[string]interface{}{
-		"Podcasts": podcasts,
+		"Podcasts":   podcasts,
+		"Limit":      page.Limit,
+		"NextCursor": nextCursor,
 	})
 }
 
-func CreatePodcastFromUrl(ctx context.Context, url string) (int64, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func CreatePodcastFromUrl(ctx context.Context, db store.PodcastStore, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return 0, fmt.Errorf("error creating request: %sL %v", url, err)
 	}
@@ -45,7 +61,7 @@ func CreatePodcastFromUrl(ctx context.Context, url string) (int64, error) {
 	req.Header["User-Agent"] = []string{util.GetUserAgent()}
 
 	// Fetch the RSS feed via a HTTP request.
-	resp, err := httpClient.Do(req)
+	resp, err := fetch.Default.Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("error fetching URL: %s: %v", url, err)
 	}
@@ -56,22 +72,37 @@ func CreatePodcastFromUrl(ctx context.Context, url string) (int64, error) {
 		return 0, fmt.Errorf("error fetching URL: %s status=%d\n%s\n\n%s", url, resp.StatusCode, string(reqDump), string(respDump))
 	}
 
-	// Unmarshal the RSS feed into an object we can query.
-	var feed rss.Feed
-	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+	// Unmarshal the RSS (or Atom) feed into an object we can query.
+	channel, err := rss.DecodeFeed(resp.Body, resp.Header.Get("Content-Type"))
+	if err != nil {
 		return 0, fmt.Errorf("error unmarshalling response: %w", err)
 	}
 
+	// If the feed has moved, we want to save the new URL rather than the one we were given.
+	if newURL := rss.PermanentRedirectURL(resp); newURL != "" {
+		log.Printf("Feed has moved: %s -> %s", url, newURL)
+		url = newURL
+	}
+	if newURL := strings.TrimSpace(channel.NewFeedURL); newURL != "" {
+		if rss.IsValidFeedURL(newURL) {
+			log.Printf("Feed has a new-feed-url: %s -> %s", url, newURL)
+			url = newURL
+		} else {
+			log.Printf("Ignoring invalid new-feed-url: %s", newURL)
+		}
+	}
+
 	podcast := store.Podcast{
-		Title:       feed.Channel.Title,
-		Description: feed.Channel.Description,
-		ImageURL:    feed.Channel.Image.URL,
+		Title:       channel.Title,
+		Description: channel.Description,
+		ImageURL:    channel.Image.URL,
 		FeedURL:     url,
+		GUID:        channel.GUID,
 	}
-	return store.SavePodcast(ctx, &podcast)
+	return db.SavePodcast(ctx, &podcast)
 }
 
-func handlePodcastsAdd(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handlePodcastsAdd(w http.ResponseWriter, r *http.Request) error {
 	if r.Method == "GET" {
 		return render(w, "podcast/add.html", nil)
 	}
@@ -80,7 +111,7 @@ func handlePodcastsAdd(w http.ResponseWriter, r *http.Request) error {
 	// It's a POST, so first, grab the URL of the RSS feed.
 	r.ParseForm()
 	url := r.Form.Get("url")
-	id, err := CreatePodcastFromUrl(ctx, url)
+	id, err := CreatePodcastFromUrl(ctx, s.db, url)
 	if err != nil {
 		return fmt.Errorf("error saving podcast: %w", err)
 	}
@@ -89,7 +120,7 @@ func handlePodcastsAdd(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func handlePodcastsEditGet(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handlePodcastsEditGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 	podcastID, err := strconv.ParseInt(vars["id"], 10, 0)
@@ -97,23 +128,42 @@ func handlePodcastsEditGet(w http.ResponseWriter, r *http.Request) error {
 		return httpError(err.Error(), http.StatusBadRequest)
 	}
 
-	podcast, err := store.LoadPodcast(ctx, podcastID)
+	podcast, err := s.db.LoadPodcast(ctx, podcastID)
 	if err != nil {
 		return httpError(err.Error(), http.StatusNotFound)
 	}
 
-	episodes, err := store.LoadEpisodes(ctx, podcastID, 25)
+	episodes, err := s.db.LoadEpisodes(ctx, podcastID, 25)
+	if err != nil {
+		return err
+	}
+
+	feedURLHistory, err := s.db.LoadFeedURLHistory(ctx, podcastID)
+	if err != nil {
+		return err
+	}
+
+	feedFetches, err := s.db.LoadFeedFetches(ctx, podcastID, 25)
+	if err != nil {
+		return err
+	}
+
+	mediaCache, err := s.db.LoadMediaCacheSettings(ctx, podcastID)
 	if err != nil {
 		return err
 	}
 
 	return render(w, "podcast/edit.html", map[string]interface{}{
-		"Podcast":  podcast,
-		"Episodes": episodes,
+		"Podcast":             podcast,
+		"Episodes":            episodes,
+		"FeedURLHistory":      feedURLHistory,
+		"FeedFetches":         feedFetches,
+		"MediaCache":          mediaCache,
+		"MediaCacheMegabytes": mediaCache.MaxBytes / (1024 * 1024),
 	})
 }
 
-func handlePodcastsEditPost(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handlePodcastsEditPost(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	if err := r.ParseForm(); err != nil {
 		return httpError(fmt.Sprintf("error parsing form: %v", err), http.StatusBadRequest)
@@ -132,7 +182,7 @@ func handlePodcastsEditPost(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	log.Printf("Saving: %v\n", podcast)
-	id, err := store.SavePodcast(ctx, podcast)
+	id, err := s.db.SavePodcast(ctx, podcast)
 	if err != nil {
 		return err
 	}
@@ -145,7 +195,7 @@ func handlePodcastsEditPost(w http.ResponseWriter, r *http.Request) error {
 	})
 }
 
-func handlePodcastsDelete(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handlePodcastsDelete(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 	podcastID, err := strconv.ParseInt(vars["id"], 10, 0)
@@ -153,16 +203,16 @@ func handlePodcastsDelete(w http.ResponseWriter, r *http.Request) error {
 		return httpError(err.Error(), http.StatusBadRequest)
 	}
 
-	podcast, err := store.LoadPodcast(ctx, podcastID)
+	podcast, err := s.db.LoadPodcast(ctx, podcastID)
 	if err != nil {
 		return httpError(err.Error(), http.StatusNotFound)
 	}
 
 	log.Printf("Deleting: %v\n", podcast)
-	return store.DeletePodcast(ctx, podcast)
+	return s.db.DeletePodcast(ctx, podcast)
 }
 
-func handlePodcastsRefreshPost(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handlePodcastsRefreshPost(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 	podcastID, err := strconv.ParseInt(vars["id"], 10, 0)
@@ -170,7 +220,7 @@ func handlePodcastsRefreshPost(w http.ResponseWriter, r *http.Request) error {
 		return httpError(err.Error(), http.StatusBadRequest)
 	}
 
-	podcast, err := store.LoadPodcast(ctx, podcastID)
+	podcast, err := s.db.LoadPodcast(ctx, podcastID)
 	if err != nil {
 		return httpError(err.Error(), http.StatusNotFound)
 	}
@@ -179,11 +229,66 @@ func handlePodcastsRefreshPost(w http.ResponseWriter, r *http.Request) error {
 	if r.URL.Query().Get("iconOnly") == "1" {
 		flags |= rss.IconOnly
 	}
+	if r.URL.Query().Get("backfill") == "1" {
+		flags |= rss.Backfill
+	}
 
-	_, err = cron.UpdatePodcast(ctx, podcast, flags)
+	_, err = cron.UpdatePodcast(ctx, s.db, podcast, flags)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+func (s *server) handlePodcastsPurgeRemovedPost(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	podcastID, err := strconv.ParseInt(vars["id"], 10, 0)
+	if err != nil {
+		return httpError(err.Error(), http.StatusBadRequest)
+	}
+
+	n, err := s.db.PurgeRemovedEpisodes(ctx, podcastID)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Purged %d removed episodes from podcast %d\n", n, podcastID)
+	return nil
+}
+
+func (s *server) handlePodcastsMediaCachePost(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	podcastID, err := strconv.ParseInt(vars["id"], 10, 0)
+	if err != nil {
+		return httpError(err.Error(), http.StatusBadRequest)
+	}
+	if err := r.ParseForm(); err != nil {
+		return httpError(fmt.Sprintf("error parsing form: %v", err), http.StatusBadRequest)
+	}
+
+	maxEpisodes, err := strconv.Atoi(r.PostForm.Get("MaxEpisodes"))
+	if err != nil || maxEpisodes < 1 {
+		return httpError("episodes to keep must be at least 1", http.StatusBadRequest)
+	}
+	maxMegabytes, err := strconv.ParseInt(r.PostForm.Get("MaxMegabytes"), 10, 64)
+	if err != nil || maxMegabytes < 0 {
+		return httpError("maximum size must be a number of megabytes", http.StatusBadRequest)
+	}
+
+	settings := &store.MediaCacheSettings{
+		PodcastID:   podcastID,
+		Enabled:     r.PostForm.Get("Enabled") != "",
+		MaxEpisodes: maxEpisodes,
+		MaxBytes:    maxMegabytes * 1024 * 1024,
+	}
+	log.Printf("Saving media cache settings: %v\n", settings)
+	if err := s.db.SaveMediaCacheSettings(ctx, settings); err != nil {
+		return err
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/podcasts/%d", podcastID), http.StatusFound)
+	return nil
+}
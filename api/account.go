@@ -7,14 +7,12 @@ import (
 	"io"
 	"log"
 	"net/http"
-
-	"github.com/podcreep/server/store"
 )
 
-func handleAccountsGet(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleAccountsGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	username := r.URL.Query().Get("username")
-	exists, err := store.VerifyUsernameExists(ctx, username)
+	exists, err := s.db.VerifyUsernameExists(ctx, username)
 	if err != nil {
 		return fmt.Errorf("error querying for username: %v", err)
 	}
@@ -34,7 +32,7 @@ type accountsPostResponse struct {
 	Cookie string `json:"cookie"`
 }
 
-func handleAccountsPost(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleAccountsPost(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
 	var req accountsPostRequest
@@ -44,7 +42,7 @@ func handleAccountsPost(w http.ResponseWriter, r *http.Request) error {
 	}
 	defer r.Body.Close()
 
-	acct, err := store.SaveAccount(ctx, req.Username, req.Password)
+	acct, err := s.db.SaveAccount(ctx, req.Username, req.Password)
 	if err != nil {
 		return err
 	}
@@ -57,7 +55,7 @@ func handleAccountsPost(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-func handleAccountsLoginPost(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleAccountsLoginPost(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
 	b, err := io.ReadAll(r.Body)
@@ -73,7 +71,7 @@ func handleAccountsLoginPost(w http.ResponseWriter, r *http.Request) error {
 	}
 	defer r.Body.Close()
 
-	acct, err := store.LoadAccountByUsername(ctx, req.Username, req.Password)
+	acct, err := s.db.LoadAccountByUsername(ctx, req.Username, req.Password)
 	if err != nil {
 		log.Printf("Error loading account for %s: %v", req.Username, err)
 		return apiError("Invalid username/password", http.StatusUnauthorized)
@@ -13,7 +13,7 @@ import (
 
 // authenticate checks that the given request includes an Authorization header and returns the
 // account assosicated with the cookie if it does, or an error if it does not.
-func authenticate(ctx context.Context, r *http.Request) (*store.Account, error) {
+func (s *server) authenticate(ctx context.Context, r *http.Request) (*store.Account, error) {
 	auth := r.Header.Get("Authorization")
 	if auth == "" {
 		return nil, fmt.Errorf("no Authorization header")
@@ -24,7 +24,7 @@ func authenticate(ctx context.Context, r *http.Request) (*store.Account, error)
 	}
 	auth = auth[7:]
 
-	return store.LoadAccountByCookie(ctx, auth)
+	return s.db.LoadAccountByCookie(ctx, auth)
 }
 
 type apierr struct {
@@ -59,24 +59,34 @@ func wrap(fn wrappedRequest) func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+// server holds what the API handlers need.
+type server struct {
+	db store.Backend
+}
+
 // Setup is called from server.go and sets up our routes, etc.
-func Setup(r *mux.Router) error {
-	r.HandleFunc("/api/accounts", wrap(handleAccountsGet)).Methods("GET")
-	r.HandleFunc("/api/accounts", wrap(handleAccountsPost)).Methods("POST")
-	r.HandleFunc("/api/accounts/login", wrap(handleAccountsLoginPost)).Methods("POST")
-	r.HandleFunc("/api/discover/trending", wrap(handleDiscoverTrendingGet)).Methods("GET")
-	r.HandleFunc("/api/discover/search", wrap(handleDiscoverSearchGet)).Methods("GET")
-	r.HandleFunc("/api/discover/podcast/{id:[0-9]+}", wrap(handleDiscoverPodcastGet)).Methods("GET")
-	r.HandleFunc("/api/podcasts", wrap(handlePodcastsGet)).Methods("GET")
-	r.HandleFunc("/api/podcasts/{id:[0-9]+}", wrap(handlePodcastGet)).Methods("GET")
-	r.HandleFunc("/api/podcasts/{id:[0-9]+}", wrap(handleSubscriptionsDelete)).Methods("DELETE")
-	r.HandleFunc("/blobs/podcasts/{id:[0-9]+}/icon/{sha1:.+}.png", wrap(handlePodcastIconGet)).Methods("GET")
-	r.HandleFunc("/api/podcasts/{id:[0-9]+}/subscriptions", wrap(handleSubscriptionsPost)).Methods("POST")
-	r.HandleFunc("/api/podcasts/subscribeDiscovered", wrap(handleSubscribeDiscoveredPost)).Methods("POST")
-	r.HandleFunc("/api/podcasts/{id:[0-9]+}/episodes/{ep:[0-9]+}/playback-state", wrap(handlePlaybackStatePut)).Methods("PUT")
-	r.HandleFunc("/api/subscriptions", wrap(handleSubscriptionsGet)).Methods("GET")
-	r.HandleFunc("/api/subscriptions/sync", wrap(handleSubscriptionsSync)).Methods("POST")
-	r.HandleFunc("/api/last-played", wrap(handleLastPlayedGet)).Methods("GET")
+func Setup(r *mux.Router, db store.Backend) error {
+	s := &server{db: db}
+	r.HandleFunc("/api/accounts", wrap(s.handleAccountsGet)).Methods("GET")
+	r.HandleFunc("/api/accounts", wrap(s.handleAccountsPost)).Methods("POST")
+	r.HandleFunc("/api/accounts/login", wrap(s.handleAccountsLoginPost)).Methods("POST")
+	r.HandleFunc("/api/discover/trending", wrap(s.handleDiscoverTrendingGet)).Methods("GET")
+	r.HandleFunc("/api/discover/search", wrap(s.handleDiscoverSearchGet)).Methods("GET")
+	r.HandleFunc("/api/discover/podcast/{id:[0-9]+}", wrap(s.handleDiscoverPodcastGet)).Methods("GET")
+	r.HandleFunc("/api/podcasts", wrap(s.handlePodcastsGet)).Methods("GET")
+	r.HandleFunc("/api/podcasts/{id:[0-9]+}", wrap(s.handlePodcastGet)).Methods("GET")
+	r.HandleFunc("/api/podcasts/{id:[0-9]+}", wrap(s.handleSubscriptionsDelete)).Methods("DELETE")
+	r.HandleFunc("/blobs/podcasts/{id:[0-9]+}/icon/{sha:[0-9a-f]+}.{ext:png|jpg}", wrap(s.handlePodcastIconGet)).Methods("GET")
+	r.HandleFunc("/api/podcasts/{id:[0-9]+}/subscriptions", wrap(s.handleSubscriptionsPost)).Methods("POST")
+	r.HandleFunc("/api/podcasts/subscribeDiscovered", wrap(s.handleSubscribeDiscoveredPost)).Methods("POST")
+	r.HandleFunc("/api/podcasts/{id:[0-9]+}/episodes/{ep:[0-9]+}/playback-state", wrap(s.handlePlaybackStatePut)).Methods("PUT")
+	r.HandleFunc("/api/podcasts/{id:[0-9]+}/episodes/{ep:[0-9]+}/chapters", wrap(s.handleChaptersGet)).Methods("GET")
+	r.HandleFunc("/blobs/chapters/{sha:[0-9a-f]{64}}", wrap(s.handleChapterImageGet)).Methods("GET")
+	r.HandleFunc("/blobs/episodes/{id:[0-9]+}", wrap(s.handleEpisodeMediaGet)).Methods("GET", "HEAD")
+	r.HandleFunc("/api/subscriptions", wrap(s.handleSubscriptionsGet)).Methods("GET")
+	r.HandleFunc("/api/subscriptions/sync", wrap(s.handleSubscriptionsSync)).Methods("POST")
+	r.HandleFunc("/api/last-played", wrap(s.handleLastPlayedGet)).Methods("GET")
+	r.HandleFunc("/api/search/transcripts", wrap(s.handleSearchTranscriptsGet)).Methods("GET")
 
 	return nil
 }
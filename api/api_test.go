@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/podcreep/server/store"
+)
+
+// TestPodcastsGet runs the podcast list handler against the in-memory backend, paging through the
+// podcasts as a subscribed account.
+func TestPodcastsGet(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemoryBackend()
+
+	acct, err := db.SaveAccount(ctx, "alice", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, title := range []string{"First", "Second", "Third"} {
+		id, err := db.SavePodcast(ctx, &store.Podcast{Title: title, FeedURL: "https://example.com/" + title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if err := db.SaveSubscription(ctx, acct, ids[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	if err := Setup(r, db); err != nil {
+		t.Fatal(err)
+	}
+	get := func(url, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		if cookie != "" {
+			req.Header.Set("Authorization", "Bearer "+cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := get("/api/podcasts", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("without a cookie: got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := get("/api/podcasts?cursor=nonsense", acct.Cookie); w.Code != http.StatusBadRequest {
+		t.Errorf("with an invalid cursor: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	var titles []string
+	var subscribed []string
+	url := "/api/podcasts?limit=2"
+	for numPages := 0; url != ""; numPages++ {
+		if numPages > len(ids) {
+			t.Fatalf("still paging after %d pages", numPages)
+		}
+
+		w := get(url, acct.Cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: got status %d: %s", url, w.Code, w.Body.String())
+		}
+		var list podcastList
+		if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Podcasts) > 2 {
+			t.Errorf("GET %s: got %d podcasts, want at most 2", url, len(list.Podcasts))
+		}
+		for _, p := range list.Podcasts {
+			titles = append(titles, p.Title)
+			if p.IsSubscribed {
+				subscribed = append(subscribed, p.Title)
+			}
+		}
+
+		url = ""
+		if list.NextCursor != "" {
+			url = "/api/podcasts?limit=2&cursor=" + list.NextCursor
+		}
+	}
+
+	if len(titles) != 3 || titles[0] != "First" || titles[1] != "Second" || titles[2] != "Third" {
+		t.Errorf("got podcasts %v, want [First Second Third]", titles)
+	}
+	if len(subscribed) != 1 || subscribed[0] != "Second" {
+		t.Errorf("got subscribed podcasts %v, want [Second]", subscribed)
+	}
+}
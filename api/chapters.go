@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/podcreep/server/store"
+)
+
+type chapterList struct {
+	Chapters []*store.Chapter `json:"chapters"`
+}
+
+// handleChaptersGet handles requests for the chapters of a single episode.
+func (s *server) handleChaptersGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	_, err := s.authenticate(ctx, r)
+	if err != nil {
+		return apiError("Unauthorized.", http.StatusUnauthorized)
+	}
+
+	podcastID, err := strconv.ParseInt(vars["id"], 10, 0)
+	if err != nil {
+		return err
+	}
+	episodeID, err := strconv.ParseInt(vars["ep"], 10, 0)
+	if err != nil {
+		return err
+	}
+
+	p, err := s.db.LoadPodcast(ctx, podcastID)
+	if err != nil {
+		return apiError("No such podcast", http.StatusNotFound)
+	}
+	ep, err := s.db.LoadEpisode(ctx, p, episodeID)
+	if err != nil || ep.PodcastID != p.ID {
+		return apiError("No such episode", http.StatusNotFound)
+	}
+
+	chapters, err := s.db.LoadChapters(ctx, ep.ID)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&chapterList{Chapters: chapters})
+}
+
+// chapterImageTypes are the content types we'll serve chapter images as. New images are always
+// PNGs, but ones we saved before we started re-encoding them are whatever the publisher gave us.
+var chapterImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// handleChapterImageGet handles requests for a chapter image. These are stored in the blob store
+// under the SHA256 of their contents.
+func (s *server) handleChapterImageGet(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+
+	basePath, err := store.GetBlobStorePath("chapters")
+	if err != nil {
+		return err
+	}
+
+	// The route only allows hex digits in the SHA, so it's safe to use it as a filename.
+	imagePath := path.Join(basePath, vars["sha"])
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return apiError("Image doesn't exist", http.StatusNotFound)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// We serve these from our own origin, so we have to be sure they really are images, and that
+	// the browser treats them as nothing else.
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	contentType := http.DetectContentType(head[:n])
+	if !chapterImageTypes[contentType] {
+		return apiError("Image doesn't exist", http.StatusNotFound)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	// The file name is the hash of the contents, so it will never change.
+	w.Header().Add("Cache-Control", "public, max-age=31536000")
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", info.ModTime(), file)
+	return nil
+}
@@ -35,15 +35,15 @@ func convertToJson(inp []discover.Podcast, w http.ResponseWriter) error {
 }
 
 // handleDiscoverTrendingGet handles GET requests for /api/discover/trending. It returns podcasts in "trending" order.
-func handleDiscoverTrendingGet(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleDiscoverTrendingGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	_, err := authenticate(ctx, r)
+	_, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Unauthorized.", http.StatusUnauthorized)
 	}
 
-	podcasts, err := discover.FetchTrending()
+	podcasts, err := discover.FetchTrending(ctx)
 	if err != nil {
 		// I'm not sure what the best HTTP status to return here is?
 		return err
@@ -54,20 +54,20 @@ func handleDiscoverTrendingGet(w http.ResponseWriter, r *http.Request) error {
 }
 
 // handleDiscoverSearchGet handles GET requests for /api/discover/search. It allows clients to search for postcasts.
-func handleDiscoverSearchGet(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleDiscoverSearchGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		return handleDiscoverTrendingGet(w, r)
+		return s.handleDiscoverTrendingGet(w, r)
 	}
 
-	_, err := authenticate(ctx, r)
+	_, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Unauthorized.", http.StatusUnauthorized)
 	}
 
-	podcasts, err := discover.Search(query)
+	podcasts, err := discover.Search(ctx, query)
 	if err != nil {
 		// I'm not sure what the best HTTP status to return here is?
 		return err
@@ -77,11 +77,11 @@ func handleDiscoverSearchGet(w http.ResponseWriter, r *http.Request) error {
 	return convertToJson(podcasts, w)
 }
 
-func handleDiscoverPodcastGet(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleDiscoverPodcastGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 
-	_, err := authenticate(ctx, r)
+	_, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Unauthorized.", http.StatusUnauthorized)
 	}
@@ -89,12 +89,12 @@ func handleDiscoverPodcastGet(w http.ResponseWriter, r *http.Request) error {
 	details := &podcastDetails{}
 
 	// First, see if we have this podcast already stored in our data store.
-	podcast, err := store.LoadPodcastByDiscoverId(ctx, vars["id"])
+	podcast, err := s.db.LoadPodcastByDiscoverId(ctx, vars["id"])
 	if podcast != nil && err == nil {
 		log.Println("got an existing podcast")
 		details.Podcast = *podcast
 
-		episodes, err := store.LoadEpisodes(ctx, podcast.ID, 10)
+		episodes, err := s.db.LoadEpisodes(ctx, podcast.ID, 10)
 		if err != nil {
 			return err
 		}
@@ -106,7 +106,7 @@ func handleDiscoverPodcastGet(w http.ResponseWriter, r *http.Request) error {
 			return err
 		}
 
-		podcast, episodes, err := discover.FetchPodcast(podcastID /*includeEpisodes*/, true)
+		podcast, episodes, err := discover.FetchPodcast(ctx, podcastID /*includeEpisodes*/, true)
 		if err != nil {
 			// I'm not sure what the best HTTP status to return here is?
 			return err
@@ -125,14 +125,25 @@ func handleDiscoverPodcastGet(w http.ResponseWriter, r *http.Request) error {
 		}
 
 		for _, episode := range episodes {
-			details.Episodes = append(details.Episodes, &store.Episode{
+			ep := &store.Episode{
 				ID:          episode.ID,
 				PodcastID:   podcast.ID,
 				Title:       episode.Title,
 				Description: episode.Description,
 				PubDate:     time.Unix(episode.DatePublished, 0),
-				// TODO: Duration?
-			})
+				MediaURL:    episode.Url,
+				MediaType:   episode.EnclosureType,
+				EpisodeType: "full",
+			}
+			if episode.Duration > 0 {
+				duration := int32(episode.Duration)
+				ep.DurationSecs = &duration
+			}
+			if episode.EnclosureSize > 0 {
+				size := episode.EnclosureSize
+				ep.MediaLength = &size
+			}
+			details.Episodes = append(details.Episodes, ep)
 		}
 	}
 
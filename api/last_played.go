@@ -14,21 +14,27 @@ type LastPlayedResponse struct {
 }
 
 // handleLastPlayedGet handles requests to get the last played episode for a user.
-func handleLastPlayedGet(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleLastPlayedGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Not authorized", http.StatusUnauthorized)
 	}
 
-	ep, err := store.GetMostRecentPlaybackState(ctx, acct)
+	ep, err := s.db.GetMostRecentPlaybackState(ctx, acct)
 	if err != nil {
 		// You're not subscribed to this episode. We don't save the state if you're not subbed.
 		return apiError("No recently-played", http.StatusNotFound)
 	}
+	if err := s.db.LoadEpisodeMetadata(ctx, []*store.Episode{ep}); err != nil {
+		return err
+	}
+	if err := applyMediaPreference(r, []*store.Episode{ep}); err != nil {
+		return err
+	}
 
-	podcast, err := store.LoadPodcast(ctx, ep.PodcastID)
+	podcast, err := s.db.LoadPodcast(ctx, ep.PodcastID)
 	if err != nil {
 		// Shouldn't happen, but you never know.
 		return apiError("Error fetching podcast", http.StatusInternalServerError)
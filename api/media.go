@@ -0,0 +1,146 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/podcreep/server/mediacache"
+	"github.com/podcreep/server/store"
+)
+
+// touchInterval is how often we update the last access time of cached media. Clients make lots of
+// Range requests for the same episode, there's no need to update it for every one.
+const touchInterval = time.Hour
+
+// mediaVariant is one of the versions of an episode's media we could give to the client: either
+// the main <enclosure>, or one of the <podcast:alternateEnclosure>s.
+type mediaVariant struct {
+	url       string
+	mediaType string
+	length    *int64
+
+	// bitrate is in bits per second, or zero if we don't know it.
+	bitrate float64
+}
+
+func (v *mediaVariant) isAudio() bool {
+	// Lots of feeds don't bother with a type, and those are almost always audio.
+	return v.mediaType == "" || strings.HasPrefix(v.mediaType, "audio/")
+}
+
+// mediaVariants returns all the versions of the given episode's media, with the main enclosure
+// first.
+func mediaVariants(ep *store.Episode) []*mediaVariant {
+	variants := []*mediaVariant{{url: ep.MediaURL, mediaType: ep.MediaType, length: ep.MediaLength}}
+	for _, e := range ep.Enclosures {
+		v := &mediaVariant{url: e.URL, mediaType: e.Type, length: e.Length}
+		if e.Bitrate != nil {
+			v.bitrate = *e.Bitrate
+		}
+		variants = append(variants, v)
+	}
+
+	// If the feed doesn't give us a bitrate, we can work it out from the size and the duration.
+	for _, v := range variants {
+		if v.bitrate == 0 && v.length != nil && ep.DurationSecs != nil && *ep.DurationSecs > 0 {
+			v.bitrate = float64(*v.length) * 8 / float64(*ep.DurationSecs)
+		}
+	}
+	return variants
+}
+
+// chooseMediaVariant picks the version of the given episode's media that best matches the given
+// preference, or nil if none of them do.
+func chooseMediaVariant(ep *store.Episode, pref string) *mediaVariant {
+	var best *mediaVariant
+	for _, v := range mediaVariants(ep) {
+		if !v.isAudio() {
+			continue
+		}
+
+		switch pref {
+		case "audio":
+			// The first audio variant is the one the publisher would prefer.
+			return v
+		case "low":
+			if best == nil || (v.bitrate > 0 && (best.bitrate == 0 || v.bitrate < best.bitrate)) {
+				best = v
+			}
+		}
+	}
+	return best
+}
+
+// applyMediaPreference lets clients ask for a particular version of each episode's media, with the
+// "media" query parameter. It can be "audio" to get audio only (when the main enclosure is a
+// video), or "low" to get the lowest-bitrate audio. The chosen version replaces the episode's
+// MediaURL, MediaType and MediaLength. All versions are always available in Enclosures, so clients
+// can also choose for themselves.
+func applyMediaPreference(r *http.Request, episodes []*store.Episode) error {
+	pref := r.URL.Query().Get("media")
+	if pref == "" {
+		return nil
+	}
+	if pref != "audio" && pref != "low" {
+		return apiError("media must be 'audio' or 'low'", http.StatusBadRequest)
+	}
+
+	for _, ep := range episodes {
+		if v := chooseMediaVariant(ep, pref); v != nil {
+			ep.MediaURL = v.url
+			ep.MediaType = v.mediaType
+			ep.MediaLength = v.length
+		}
+	}
+	return nil
+}
+
+// handleEpisodeMediaGet serves our cached copy of an episode's media from the blob store. Range
+// requests are supported, so clients can seek and resume downloads.
+func (s *server) handleEpisodeMediaGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	episodeID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		return err
+	}
+
+	m, err := s.db.LoadEpisodeMedia(ctx, episodeID)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return apiError("Media isn't cached", http.StatusNotFound)
+	}
+
+	path, err := mediacache.Path(episodeID)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return apiError("Media isn't cached", http.StatusNotFound)
+	}
+	defer file.Close()
+
+	now := time.Now()
+	if m.LastAccessedAt.Before(now.Add(-touchInterval)) {
+		if err := s.db.TouchEpisodeMedia(ctx, episodeID, now); err != nil {
+			// Not a big deal, it just might get evicted sooner than it should.
+			log.Printf("Error updating last access time of episode %d: %v", episodeID, err)
+		}
+	}
+
+	// ServeContent takes care of Range, If-Range, If-None-Match and so on for us. The content type
+	// came from the publisher, so we check it again (media cached before we checked it when saving
+	// could have anything) and make sure browsers don't sniff it as something else.
+	w.Header().Set("Content-Type", mediacache.ContentType(m.ContentType))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("ETag", `"`+m.SHA256+`"`)
+	http.ServeContent(w, r, "", m.CachedAt, file)
+	return nil
+}
@@ -27,10 +27,10 @@ type PlaybackState struct {
 
 // handlePlaybackStatePut handles requests to update the playback state of a single episode of a
 // single podcast.
-func handlePlaybackStatePut(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handlePlaybackStatePut(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Not authorized", http.StatusUnauthorized)
 	}
@@ -40,7 +40,7 @@ func handlePlaybackStatePut(w http.ResponseWriter, r *http.Request) error {
 		return apiError("Request is not valid", http.StatusBadRequest)
 	}
 
-	if !store.IsSubscribed(ctx, acct, playbackState.PodcastID) {
+	if !s.db.IsSubscribed(ctx, acct, playbackState.PodcastID) {
 		// You're not subscribed to this episode. We don't save the state if you're not subbed.
 		return apiError("No subscription found, can't update state.", http.StatusBadRequest)
 	}
@@ -52,7 +52,7 @@ func handlePlaybackStatePut(w http.ResponseWriter, r *http.Request) error {
 		EpisodeComplete: false, // TODO
 		LastUpdated:     playbackState.LastUpdated,
 	}
-	if err := store.SaveEpisodeProgress(ctx, &progress); err != nil {
+	if err := s.db.SaveEpisodeProgress(ctx, &progress); err != nil {
 		return err
 	}
 
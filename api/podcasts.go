@@ -2,19 +2,23 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"image"
-	_ "image/jpeg"
-	"image/png"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"strconv"
 
 	"github.com/gorilla/mux"
+	"github.com/podcreep/server/artwork"
 	"github.com/podcreep/server/rss"
 	"github.com/podcreep/server/store"
-	"golang.org/x/image/draw"
+)
+
+const (
+	// maxPageLimit is the most podcasts (or episodes) a client can ask for in one page.
+	maxPageLimit = 1000
 )
 
 type podcastDetails struct {
@@ -22,36 +26,63 @@ type podcastDetails struct {
 
 	// IsSubscribed will be true if the current user is subscribed to this podcast.
 	IsSubscribed bool `json:"isSubscribed"`
+
+	// NextCursor is the cursor of the next page of episodes, if there are more.
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 type podcastList struct {
 	Podcasts []*podcastDetails `json:"podcasts"`
+
+	// NextCursor is the cursor of the next page of podcasts, if there are more.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// parsePage parses the "cursor" and "limit" query parameters, which clients use to page through
+// long lists. Without a limit, we return the whole list.
+func parsePage(r *http.Request) (store.Page, error) {
+	page := store.Page{Cursor: r.URL.Query().Get("cursor")}
+	if str := r.URL.Query().Get("limit"); str != "" {
+		limit, err := strconv.Atoi(str)
+		if err != nil || limit <= 0 || limit > maxPageLimit {
+			return page, apiError("Invalid limit", http.StatusBadRequest)
+		}
+		page.Limit = limit
+	}
+	return page, nil
 }
 
-// handlePodcastsGet handles requests to view all the podcasts we have in our DB.
-// TODO: support filtering, sorting, paging, etc etc.
-func handlePodcastsGet(w http.ResponseWriter, r *http.Request) error {
+// handlePodcastsGet handles requests to view all the podcasts we have in our DB, a page at a time.
+// TODO: support filtering, sorting, etc etc.
+func (s *server) handlePodcastsGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Not authorized", http.StatusUnauthorized)
 	}
 
-	podcasts, err := store.LoadPodcasts(ctx)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
 
-	subs, err := store.LoadSubscriptionIDs(ctx, acct)
+	podcasts, nextCursor, err := s.db.LoadPodcasts(ctx, page)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		return apiError("Invalid cursor", http.StatusBadRequest)
+	} else if err != nil {
+		return err
+	}
+
+	subs, err := s.db.LoadSubscriptionIDs(ctx, acct)
 	if err != nil {
 		return err
 	}
 
-	list := podcastList{}
+	list := podcastList{NextCursor: nextCursor}
 	for _, podcast := range podcasts {
 		_, is_subbed := subs[podcast.ID]
-		list.Podcasts = append(list.Podcasts, &podcastDetails{*podcast, is_subbed})
+		list.Podcasts = append(list.Podcasts, &podcastDetails{Podcast: *podcast, IsSubscribed: is_subbed})
 	}
 	err = json.NewEncoder(w).Encode(&list)
 	if err != nil {
@@ -61,12 +92,13 @@ func handlePodcastsGet(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// handlePodcastGet handles requests to view a single podcast.
-func handlePodcastGet(w http.ResponseWriter, r *http.Request) error {
+// handlePodcastGet handles requests to view a single podcast. If the user is subscribed, we return a
+// page of its episodes (by default, all of them), otherwise just the latest few.
+func (s *server) handlePodcastGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Unauthorized.", http.StatusUnauthorized)
 	}
@@ -76,32 +108,48 @@ func handlePodcastGet(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	p, err := store.LoadPodcast(ctx, podcastID)
+	page, err := parsePage(r)
 	if err != nil {
 		return err
 	}
-	details := podcastDetails{*p, false}
 
-	if store.IsSubscribed(ctx, acct, p.ID) {
+	p, err := s.db.LoadPodcast(ctx, podcastID)
+	if err != nil {
+		return err
+	}
+	if err := s.db.LoadPodcastMetadata(ctx, p); err != nil {
+		return err
+	}
+	details := podcastDetails{Podcast: *p}
+
+	if s.db.IsSubscribed(ctx, acct, p.ID) {
 		details.IsSubscribed = true
 
 		// If they're subscribed, get the episode list for this subscription.
-		details.Episodes, err = store.LoadEpisodesForSubscription(ctx, acct, p)
-		if err != nil {
+		details.Episodes, details.NextCursor, err = s.db.LoadEpisodesForSubscription(ctx, acct, p, page)
+		if errors.Is(err, store.ErrInvalidCursor) {
+			return apiError("Invalid cursor", http.StatusBadRequest)
+		} else if err != nil {
 			return err
 		}
 	} else {
 		// Otherwise, just get the latest 20 episodes
-		details.Episodes, err = store.LoadEpisodes(ctx, p.ID, 20)
+		details.Episodes, err = s.db.LoadEpisodes(ctx, p.ID, 20)
 		if err != nil {
 			return err
 		}
 	}
+	if err := s.db.LoadEpisodeMetadata(ctx, details.Episodes); err != nil {
+		return err
+	}
+	if err := applyMediaPreference(r, details.Episodes); err != nil {
+		return err
+	}
 
 	if r.URL.Query().Get("refresh") == "1" {
 		// They've asked us explicitly to refresh the podcast (and all it's episodes), so do that
 		// first before fetching the podcast.
-		if _, err := rss.UpdatePodcast(ctx, p, 0 /*flags*/); err != nil {
+		if _, err := rss.UpdatePodcast(ctx, s.db, p, 0 /*flags*/); err != nil {
 			log.Printf("Erroring updating podcast: %v\n", err)
 			// Note: we just keep going, assuming the podcast didn't change.
 		}
@@ -115,8 +163,10 @@ func handlePodcastGet(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// handlePodcastIconGet handles requests to view a podcast's icon.
-func handlePodcastIconGet(w http.ResponseWriter, r *http.Request) error {
+// handlePodcastIconGet handles requests to view a podcast's icon. If the width and height (or
+// size) query parameters are given, we serve the nearest of the thumbnails we made when we
+// downloaded the icon, otherwise the canonical image. The extension picks the format.
+func (s *server) handlePodcastIconGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 
@@ -125,7 +175,7 @@ func handlePodcastIconGet(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	p, err := store.LoadPodcast(ctx, podcastID)
+	p, err := s.db.LoadPodcast(ctx, podcastID)
 	if err != nil {
 		return err
 	}
@@ -135,35 +185,59 @@ func handlePodcastIconGet(w http.ResponseWriter, r *http.Request) error {
 		return apiError("Image doesn't exist", http.StatusNotFound)
 	}
 
-	// TODO: compare vars["sha1"] (the requests SHA1) with the latest SHA1
-
-	// We put the SHA1 in the filename, so if it ever changes, the URL would be different. Given that,
-	// browsers are free to cache this response forever.
-	// TODO: handle If-Modified-Since?
+	// We put the hash in the filename, so if it ever changes, the URL would be different. Given
+	// that, browsers are free to cache this response forever.
+	hash := vars["sha"]
+	ext := vars["ext"]
 	w.Header().Add("Cache-Control", "public, max-age=31536000")
 
-	if r.URL.Query().Get("width") != "" && r.URL.Query().Get("height") != "" {
-		// They want a specific size, let's give them a a specific size.
-		width, _ := strconv.Atoi(r.URL.Query().Get("width"))
-		height, _ := strconv.Atoi(r.URL.Query().Get("height"))
-		if width > 0 && height > 0 {
-			file, err := os.Open(*p.ImagePath)
-			if err != nil {
-				return err
-			}
-
-			img, _, err := image.Decode(file)
-			if err != nil {
-				return fmt.Errorf("Error decoding image %s: %w", *p.ImagePath, err)
-			}
-			resized := image.NewRGBA(image.Rect(0, 0, width, height))
-			draw.CatmullRom.Scale(resized, resized.Rect, img, img.Bounds(), draw.Over, nil)
-			w.Header().Add("Content-Type", "image/png")
-			png.Encode(w, resized)
-			return nil
+	if !artwork.IsCanonicalPath(*p.ImagePath) {
+		// We saved this icon before we started processing them, so all we have is whatever we
+		// downloaded. It'll be replaced next time the podcast is updated.
+		if ext != "png" || path.Base(*p.ImagePath) != hash+".png" {
+			return apiError("Image doesn't exist", http.StatusNotFound)
+		}
+		http.ServeFile(w, r, *p.ImagePath)
+		return nil
+	}
+
+	q := r.URL.Query()
+	size, _ := strconv.Atoi(q.Get("size"))
+	if size <= 0 {
+		width, _ := strconv.Atoi(q.Get("width"))
+		height, _ := strconv.Atoi(q.Get("height"))
+		size = width
+		if height > size {
+			size = height
 		}
 	}
+	if size > 0 {
+		size = artwork.NearestSize(size)
+	}
+	if size == 0 && ext != "png" {
+		// The canonical image is always a PNG, the biggest thumbnail will have to do.
+		size = artwork.Sizes[len(artwork.Sizes)-1]
+	}
+	variant := artwork.VariantName(size, ext)
+
+	// The route only allows hex digits in the hash, so it's safe to use it as a filename.
+	dir, err := artwork.Dir(hash)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path.Join(dir, variant))
+	if err != nil {
+		return apiError("Image doesn't exist", http.StatusNotFound)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
 
-	http.ServeFile(w, r, *p.ImagePath)
+	// ServeContent takes care of If-None-Match for us.
+	w.Header().Set("Content-Type", artwork.Formats[ext])
+	w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, hash, variant))
+	http.ServeContent(w, r, "", info.ModTime(), file)
 	return nil
 }
@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/podcreep/server/store"
+)
+
+const (
+	// defaultSearchLimit is the maximum number of transcript hits we return if the client doesn't
+	// ask for a specific number.
+	defaultSearchLimit = 100
+
+	// maxSearchLimit is the most transcript hits a client can ask for.
+	maxSearchLimit = 1000
+)
+
+type transcriptSearchResponse struct {
+	Results []*store.TranscriptSearchResult `json:"results"`
+}
+
+// handleSearchTranscriptsGet handles requests for /api/search/transcripts. It does a full-text
+// search of all the transcripts we have, and returns the matching episodes along with the times
+// in the episode that matched.
+func (s *server) handleSearchTranscriptsGet(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	_, err := s.authenticate(ctx, r)
+	if err != nil {
+		return apiError("Unauthorized.", http.StatusUnauthorized)
+	}
+
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		return apiError("Query is required", http.StatusBadRequest)
+	}
+
+	limit := defaultSearchLimit
+	if str := r.URL.Query().Get("limit"); str != "" {
+		limit, err = strconv.Atoi(str)
+		if err != nil || limit <= 0 || limit > maxSearchLimit {
+			return apiError("Invalid limit", http.StatusBadRequest)
+		}
+	}
+
+	results, err := s.db.SearchTranscripts(ctx, query, limit)
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(w).Encode(&transcriptSearchResponse{Results: results})
+}
@@ -50,14 +50,14 @@ type subscribeDiscoveredRequest struct {
 	DiscoveryID string `json:"discoveryId"`
 }
 
-func getSubscriptions(ctx context.Context, acct *store.Account) ([]subscription, error) {
-	subs, err := store.GetSubscriptions(ctx, acct)
+func (s *server) getSubscriptions(ctx context.Context, acct *store.Account) ([]subscription, error) {
+	subs, err := s.db.GetSubscriptions(ctx, acct)
 	if err != nil {
 		return nil, err
 	}
 	log.Printf("Got %d subscription(s) for %s\n", len(subs), acct.Username)
 
-	podcasts, err := store.GetSubscriptions(ctx, acct)
+	podcasts, err := s.db.GetSubscriptions(ctx, acct)
 	if err != nil {
 		return nil, err
 	}
@@ -73,16 +73,16 @@ func getSubscriptions(ctx context.Context, acct *store.Account) ([]subscription,
 
 // handleSubscriptionsGet handles a GET request for /api/subscriptions, and returns all of the
 // user's subscriptions.
-func handleSubscriptionsGet(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleSubscriptionsGet(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Unauthorized.", http.StatusUnauthorized)
 	}
 
 	// Get the subscriptions for this user.
-	subscriptionDetails, err := getSubscriptions(ctx, acct)
+	subscriptionDetails, err := s.getSubscriptions(ctx, acct)
 	if err != nil {
 		return err
 	}
@@ -92,10 +92,16 @@ func handleSubscriptionsGet(w http.ResponseWriter, r *http.Request) error {
 	var newEpisodes []*episodeDetails
 	var inProgress []*episodeDetails
 	podcastIDs := make(map[int64]struct{})
-	ne, ip, err := store.LoadEpisodesNewAndInProgress(ctx, acct, NewEpisodeDays)
+	ne, ip, err := s.db.LoadEpisodesNewAndInProgress(ctx, acct, NewEpisodeDays)
 	if err != nil {
 		return err
 	}
+	if err := s.db.LoadEpisodeMetadata(ctx, append(ne, ip...)); err != nil {
+		return err
+	}
+	if err := applyMediaPreference(r, append(ne, ip...)); err != nil {
+		return err
+	}
 
 	for _, ep := range ne {
 		podcastIDs[ep.PodcastID] = struct{}{}
@@ -126,11 +132,11 @@ func handleSubscriptionsGet(w http.ResponseWriter, r *http.Request) error {
 
 // handleSubscriptionsPost handles a POST to /api/podcasts/{id}/subscriptions, and adds a
 // subscription to the given podcast for the given user.
-func handleSubscriptionsPost(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleSubscriptionsPost(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Unauthorized", http.StatusUnauthorized)
 	}
@@ -140,12 +146,12 @@ func handleSubscriptionsPost(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return store.SaveSubscription(ctx, acct, podcastID)
+	return s.db.SaveSubscription(ctx, acct, podcastID)
 }
 
 // handleSubscribeDiscoveredPost handles a POST to /api/podcasts/subscribeDiscovered, and adds a
 // subscription to the discovered podcast. If we do not yet track that podcast, we'll start tracking it.
-func handleSubscribeDiscoveredPost(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleSubscribeDiscoveredPost(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	var req subscribeDiscoveredRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -154,12 +160,12 @@ func handleSubscribeDiscoveredPost(w http.ResponseWriter, r *http.Request) error
 	}
 	defer r.Body.Close()
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Unauthorized", http.StatusUnauthorized)
 	}
 
-	podcast, err := store.LoadPodcastByDiscoverId(ctx, req.DiscoveryID)
+	podcast, err := s.db.LoadPodcastByDiscoverId(ctx, req.DiscoveryID)
 	if err != nil || podcast == nil {
 		// TODO: don't just assume any error is 'not found'. The problem is, row.Scan() doesn't seem to return ErrNoRows
 		// as it's documented to do.
@@ -168,37 +174,38 @@ func handleSubscribeDiscoveredPost(w http.ResponseWriter, r *http.Request) error
 		if err != nil {
 			return apiError("discover ID not an int", http.StatusInternalServerError)
 		}
-		discoverPodcast, _, err := discover.FetchPodcast(int64(discoverId) /*includeEpisodes*/, true)
+		discoverPodcast, _, err := discover.FetchPodcast(ctx, int64(discoverId) /*includeEpisodes*/, true)
 		if err != nil {
 			return err
 		}
 
-		id, err := admin.CreatePodcastFromUrl(ctx, discoverPodcast.Url)
+		id, err := admin.CreatePodcastFromUrl(ctx, s.db, discoverPodcast.Url)
 		if err != nil {
 			return err
 		}
 
-		podcast, err = store.LoadPodcast(ctx, id)
+		podcast, err = s.db.LoadPodcast(ctx, id)
 		if err != nil {
 			return err
 		}
 		podcast.DiscoverID = req.DiscoveryID
-		_, err = cron.UpdatePodcast(ctx, podcast, rss.ForceUpdate)
+		// It's a new podcast, so load its whole back catalogue.
+		_, err = cron.UpdatePodcast(ctx, s.db, podcast, rss.ForceUpdate|rss.Backfill)
 		if err != nil {
 			return nil
 		}
 	}
 
-	return store.SaveSubscription(ctx, acct, podcast.ID)
+	return s.db.SaveSubscription(ctx, acct, podcast.ID)
 }
 
 // handleSubscriptionsDelete handles a DELETE to /api/podcasts/{id}/subscriptions, and removes a
 // subscription from the given podcast for the given user.
-func handleSubscriptionsDelete(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleSubscriptionsDelete(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	vars := mux.Vars(r)
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return apiError("Unauthorized", http.StatusUnauthorized)
 	}
@@ -208,11 +215,11 @@ func handleSubscriptionsDelete(w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return store.DeleteSubscription(ctx, acct, podcastID)
+	return s.db.DeleteSubscription(ctx, acct, podcastID)
 }
 
 // handleSubscriptionsSync handles a request for /api/subscriptions/sync
-func handleSubscriptionsSync(w http.ResponseWriter, r *http.Request) error {
+func (s *server) handleSubscriptionsSync(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
 	var req subscriptionsSyncPostRequest
@@ -222,27 +229,33 @@ func handleSubscriptionsSync(w http.ResponseWriter, r *http.Request) error {
 	}
 	defer r.Body.Close()
 
-	acct, err := authenticate(ctx, r)
+	acct, err := s.authenticate(ctx, r)
 	if err != nil {
 		return err
 	}
 
-	subscriptionDetails, err := getSubscriptions(ctx, acct)
+	subscriptionDetails, err := s.getSubscriptions(ctx, acct)
 	if err != nil {
 		return err
 	}
 
 	// For each podcast, grab the episodes that the client doesn't yet have.
 	for i, sub := range subscriptionDetails {
-		p, err := store.LoadPodcast(ctx, sub.Podcast.ID)
+		p, err := s.db.LoadPodcast(ctx, sub.Podcast.ID)
 		if err != nil {
 			return err
 		}
 
-		p.Episodes, err = store.LoadEpisodesForSubscription(ctx, acct, p)
+		p.Episodes, _, err = s.db.LoadEpisodesForSubscription(ctx, acct, p, store.Page{})
 		if err != nil {
 			return err
 		}
+		if err := s.db.LoadEpisodeMetadata(ctx, p.Episodes); err != nil {
+			return err
+		}
+		if err := applyMediaPreference(r, p.Episodes); err != nil {
+			return err
+		}
 
 		// TODO: don't return episodes they've already got
 		subscriptionDetails[i].Podcast = p
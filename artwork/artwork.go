@@ -0,0 +1,263 @@
+// Package artwork processes podcast icons. When we download an icon, we decode it (which also
+// checks that it really is an image), and save a canonical PNG of it along with thumbnails in a
+// fixed set of sizes and formats, so that we never have to resize anything when serving them.
+package artwork
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+
+	"github.com/podcreep/server/store"
+	"golang.org/x/image/draw"
+
+	// We can't do anything about the formats feeds use for their icons, so we try to decode all of
+	// the ones we've seen. They're all converted to PNG and JPEG on the way in.
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// blobName is the name of the directory in the blob store we keep the icons in.
+	blobName = "icons"
+
+	// canonicalName is the name of the canonical image in an icon's directory.
+	canonicalName = "original.png"
+
+	// MaxSourceSize is the largest icon (in bytes) we'll accept.
+	MaxSourceSize = 20 * 1024 * 1024
+
+	// minDimension and maxDimension are the smallest and largest width or height we'll accept. The
+	// maximum protects us from tiny files that decode to enormous images: it's a bit more than
+	// maxCanonicalDimension, which is all we keep anyway, and decoding a 4096x4096 image takes up
+	// to 64 MB (at 4 bytes per pixel).
+	minDimension = 16
+	maxDimension = 4096
+
+	// maxCanonicalDimension is the largest we'll keep the canonical image. Apple asks for
+	// 3000x3000, anything bigger is just wasting space.
+	maxCanonicalDimension = 3000
+
+	// jpegQuality is the quality we encode the JPEG thumbnails with.
+	jpegQuality = 85
+)
+
+// Sizes are the sizes of the thumbnails we make of each icon. The thumbnails fit within a square
+// of this size.
+var Sizes = []int{64, 128, 256, 512}
+
+// Formats are the formats we make each thumbnail in, keyed by file extension.
+var Formats = map[string]string{
+	"png": "image/png",
+	"jpg": "image/jpeg",
+}
+
+// ErrInvalidImage is returned when an icon can't be decoded, or has a silly size.
+var ErrInvalidImage = errors.New("invalid image")
+
+// Hash returns the hash of the given icon, which identifies it in the blob store and in URLs.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Dir returns the directory in the blob store where we keep the icon with the given hash.
+func Dir(hash string) (string, error) {
+	basePath, err := store.GetBlobStorePath(blobName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(basePath, hash), nil
+}
+
+// IsCanonicalPath returns true if the given path is the path to a canonical image saved by Save.
+// Icons we saved before we processed them were just the bytes we downloaded, in a single file.
+func IsCanonicalPath(path string) bool {
+	return filepath.Base(path) == canonicalName
+}
+
+// HashFromPath returns the hash of the icon with the given canonical path, or "" if it's not a
+// canonical path.
+func HashFromPath(path string) string {
+	if !IsCanonicalPath(path) {
+		return ""
+	}
+	return filepath.Base(filepath.Dir(path))
+}
+
+// VariantName returns the name of the file in an icon's directory with the thumbnail of the given
+// size and format. A size of zero means the canonical image.
+func VariantName(size int, ext string) string {
+	if size == 0 {
+		return canonicalName
+	}
+	return fmt.Sprintf("%d.%s", size, ext)
+}
+
+// NearestSize returns the smallest of our thumbnail sizes that is at least as big as the given
+// size, or zero (the canonical image) if it's bigger than all of them.
+func NearestSize(size int) int {
+	for _, s := range Sizes {
+		if s >= size {
+			return s
+		}
+	}
+	return 0
+}
+
+// Icon is an icon that we've saved to the blob store.
+type Icon struct {
+	// Path is the path to the canonical image.
+	Path string
+
+	// Palette is the set of colours we extracted from the icon.
+	Palette *store.Palette
+}
+
+// Save decodes the given icon (which can be a JPEG, PNG, GIF or WebP), and saves the canonical image
+// and all of the thumbnails to the blob store. For animated GIFs, we just use the first frame. If
+// the icon can't be decoded, returns an error wrapping ErrInvalidImage.
+func Save(data []byte) (*Icon, error) {
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := Dir(Hash(data))
+	if err != nil {
+		return nil, err
+	}
+	icon := &Icon{
+		Path:    filepath.Join(dir, canonicalName),
+		Palette: ExtractPalette(img),
+	}
+	if _, err := os.Stat(icon.Path); err == nil {
+		// We've already processed this exact icon (maybe for another podcast).
+		return icon, nil
+	}
+
+	// Write everything to a temporary directory first, so that we never serve half an icon.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), "tmp-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := writeImage(filepath.Join(tmpDir, canonicalName), resize(img, maxCanonicalDimension), "png"); err != nil {
+		return nil, err
+	}
+	for _, size := range Sizes {
+		thumbnail := resize(img, size)
+		for ext := range Formats {
+			if err := writeImage(filepath.Join(tmpDir, VariantName(size, ext)), thumbnail, ext); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := os.Rename(tmpDir, dir); err != nil {
+		if _, statErr := os.Stat(icon.Path); statErr == nil {
+			// Someone else saved the same icon while we were working on it.
+			return icon, nil
+		}
+		return nil, fmt.Errorf("error saving icon: %w", err)
+	}
+	return icon, nil
+}
+
+// decode decodes the given image, checking that it's a sensible size before we decode the whole
+// thing. Returns an error wrapping ErrInvalidImage if it's not an image we can decode.
+func decode(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if config.Width < minDimension || config.Height < minDimension || config.Width > maxDimension || config.Height > maxDimension {
+		return nil, fmt.Errorf("%w: image is %dx%d", ErrInvalidImage, config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	return img, nil
+}
+
+// EncodePNG decodes the given image (in any of the formats Save accepts) and re-encodes it as a PNG
+// no bigger than a canonical icon. We do this to every image we serve from our own origin, so that
+// whatever a publisher gives us, all we ever serve is a PNG. Returns an error wrapping
+// ErrInvalidImage if it can't be decoded.
+func EncodePNG(data []byte) ([]byte, error) {
+	img, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, resize(img, maxCanonicalDimension)); err != nil {
+		return nil, fmt.Errorf("error encoding PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// resize scales the given image down so that it fits within a size x size square. Images that
+// already fit are returned as-is.
+func resize(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= size && height <= size {
+		return img
+	}
+
+	if width > height {
+		height = height * size / width
+		width = size
+	} else {
+		width = width * size / height
+		height = size
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Rect, img, bounds, draw.Over, nil)
+	return resized
+}
+
+// writeImage encodes the given image in the given format ("png" or "jpg") to the given file.
+func writeImage(path string, img image.Image, ext string) error {
+	var buf bytes.Buffer
+	switch ext {
+	case "png":
+		if err := png.Encode(&buf, img); err != nil {
+			return fmt.Errorf("error encoding PNG: %w", err)
+		}
+	case "jpg":
+		// JPEG has no transparency, so put the image on a white background first.
+		bounds := img.Bounds()
+		opaque := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(opaque, opaque.Rect, image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(opaque, opaque.Rect, img, bounds.Min, draw.Over)
+		if err := jpeg.Encode(&buf, opaque, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return fmt.Errorf("error encoding JPEG: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown format: %s", ext)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package artwork
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+
+	"github.com/podcreep/server/store"
+)
+
+const (
+	// paletteSize is the size we shrink icons to before extracting the palette. There's no need to
+	// look at every pixel of a 3000x3000 image to find its colours.
+	paletteSize = 64
+
+	// minAlpha is the alpha below which we ignore a pixel, as it's (mostly) see-through.
+	minAlpha = 0x8000
+)
+
+// swatch is a group of similar colours in an image.
+type swatch struct {
+	r, g, b    float64
+	population int
+}
+
+// hsl returns the saturation and lightness (both 0-1) of the swatch's colour. We don't need the
+// hue.
+func (s *swatch) hsl() (sat, l float64) {
+	r, g, b := s.r/255, s.g/255, s.b/255
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	l = (max + min) / 2
+	if max == min {
+		return 0, l
+	}
+
+	if l > 0.5 {
+		sat = (max - min) / (2 - max - min)
+	} else {
+		sat = (max - min) / (max + min)
+	}
+	return sat, l
+}
+
+func (s *swatch) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", uint8(math.Round(s.r)), uint8(math.Round(s.g)), uint8(math.Round(s.b)))
+}
+
+// luminance returns the relative luminance of the swatch's colour, as defined by WCAG.
+func (s *swatch) luminance() float64 {
+	channel := func(c float64) float64 {
+		c /= 255
+		if c <= 0.03928 {
+			return c / 12.92
+		}
+		return math.Pow((c+0.055)/1.055, 2.4)
+	}
+	return 0.2126*channel(s.r) + 0.7152*channel(s.g) + 0.0722*channel(s.b)
+}
+
+// contrastRatio returns the WCAG contrast ratio between two colours with the given luminances.
+func contrastRatio(l1, l2 float64) float64 {
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// ExtractPalette works out a small palette from the given image, for clients to theme things with:
+// the dominant colour, a vibrant and a muted colour, and a text colour (black or white) that can be
+// read on top of the dominant colour.
+func ExtractPalette(img image.Image) *store.Palette {
+	swatches := quantize(resize(img, paletteSize))
+	if len(swatches) == 0 {
+		return nil
+	}
+
+	dominant := swatches[0]
+	for _, s := range swatches {
+		if s.population > dominant.population {
+			dominant = s
+		}
+	}
+
+	vibrant := bestSwatch(swatches, dominant, func(sat, l float64) float64 {
+		if sat < 0.35 || l < 0.25 || l > 0.75 {
+			return -1
+		}
+		return sat*3 + (1 - math.Abs(l-0.5)*2)
+	})
+	muted := bestSwatch(swatches, dominant, func(sat, l float64) float64 {
+		if sat > 0.4 || l < 0.25 || l > 0.75 {
+			return -1
+		}
+		return (1-sat)*3 + (1 - math.Abs(l-0.5)*2)
+	})
+
+	// Pick whichever of black or white stands out more on the dominant colour. One of them always
+	// has a contrast ratio of at least 4.5:1, which is what WCAG asks for.
+	text := "#ffffff"
+	lum := dominant.luminance()
+	if contrastRatio(lum, 0) > contrastRatio(lum, 1) {
+		text = "#000000"
+	}
+
+	return &store.Palette{
+		Dominant: dominant.hex(),
+		Vibrant:  vibrant.hex(),
+		Muted:    muted.hex(),
+		Text:     text,
+	}
+}
+
+// quantize groups the pixels of the given image into swatches of similar colours, by dropping the
+// bottom four bits of each channel.
+func quantize(img image.Image) []*swatch {
+	buckets := make(map[int]*swatch)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < minAlpha {
+				continue
+			}
+			// Un-premultiply, and scale down to 8 bits.
+			r, g, b = r*0xFFFF/a>>8, g*0xFFFF/a>>8, b*0xFFFF/a>>8
+
+			key := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			s := buckets[key]
+			if s == nil {
+				s = &swatch{}
+				buckets[key] = s
+			}
+			s.r += float64(r)
+			s.g += float64(g)
+			s.b += float64(b)
+			s.population++
+		}
+	}
+
+	// Go through the buckets in a fixed order, so that ties always go the same way.
+	keys := make([]int, 0, len(buckets))
+	for key := range buckets {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	swatches := make([]*swatch, 0, len(buckets))
+	for _, key := range keys {
+		s := buckets[key]
+		n := float64(s.population)
+		s.r, s.g, s.b = s.r/n, s.g/n, s.b/n
+		swatches = append(swatches, s)
+	}
+	return swatches
+}
+
+// bestSwatch returns the swatch with the highest score, weighted by how common it is. The score
+// function is given the saturation and lightness of each swatch, and returns a negative score for
+// swatches that aren't suitable at all. If none are, fallback is returned.
+func bestSwatch(swatches []*swatch, fallback *swatch, score func(sat, l float64) float64) *swatch {
+	// Ignore tiny specks of colour, they're not what the image looks like.
+	total := 0
+	for _, s := range swatches {
+		total += s.population
+	}
+	minPopulation := total / 200
+
+	var best *swatch
+	bestScore := 0.0
+	maxPopulation := float64(fallback.population)
+	for _, s := range swatches {
+		if s.population < minPopulation {
+			continue
+		}
+		sat, l := s.hsl()
+		sc := score(sat, l)
+		if sc < 0 {
+			continue
+		}
+		sc += 2 * float64(s.population) / maxPopulation
+		if best == nil || sc > bestScore {
+			best = s
+			bestScore = sc
+		}
+	}
+	if best == nil {
+		return fallback
+	}
+	return best
+}
@@ -8,9 +8,12 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/podcreep/server/mediacache"
+	"github.com/podcreep/server/probe"
 	"github.com/podcreep/server/rss"
 	"github.com/podcreep/server/store"
 	"github.com/podcreep/server/util"
+	"github.com/podcreep/server/websub"
 )
 
 var (
@@ -18,63 +21,86 @@ var (
 )
 
 // cronCheckUpdates checks for updates to our podcasts. To decide which podcast to update, we look
-// at how long it has been since the last update: we update all podcasts that have not been updated
-// in at last the last hour.
+// at how long it has been since we last tried to update it: we update all podcasts that we haven't
+// tried to update in at least the last hour. Podcasts that are pushed to us by a WebSub hub are only polled once a
+// day, just in case the hub misses something.
 // TODO: allow us to configure the refresh frequency on a per-podcast basis.
-func cronCheckUpdates(ctx context.Context) error {
-	podcasts, err := store.LoadPodcasts(ctx)
+func cronCheckUpdates(ctx context.Context, db store.Backend) error {
+	podcasts, _, err := db.LoadPodcasts(ctx, store.Page{})
 	if err != nil {
 		return err
 	}
 
+	subs, err := db.LoadWebSubSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	pushed := make(map[int64]bool)
+	for _, sub := range subs {
+		pushed[sub.PodcastID] = sub.IsActive(time.Now())
+	}
+
 	if len(podcasts) == 0 {
 		log.Printf("No podcasts.")
 		return nil
 	}
 
-	// Sort the podcasts by LastFetchTime, so that the first podcast in the list is the one that
-	// we haven't fetched for the longer time.
+	// Sort the podcasts by LastAttemptTime, so that the first podcast in the list is the one that
+	// we haven't tried to fetch for the longest time.
 	sort.Slice(podcasts, func(i, j int) bool {
-		return podcasts[i].LastFetchTime.Before(podcasts[j].LastFetchTime)
+		return podcasts[i].LastAttemptTime.Before(podcasts[j].LastAttemptTime)
 	})
 
-	// Loop through all the podcasts, and stop when we get one that was updated in the last
+	// Loop through all the podcasts, and stop when we get one that we tried to update in the last
 	// hour.
 	for _, p := range podcasts {
-		if p.LastFetchTime.After(time.Now().Add(-1 * time.Hour)) {
-			log.Printf("This podcast ('%s') was only updated at %v, not updating again.", p.Title, p.LastFetchTime)
+		if p.LastAttemptTime.After(time.Now().Add(-1 * time.Hour)) {
+			log.Printf("This podcast ('%s') was only updated at %v, not updating again.", p.Title, p.LastAttemptTime)
 			return nil
 		}
 
-		log.Printf("Updating podcast %s, LastFetchTime = %v", p.Title, p.LastFetchTime)
-		numUpdated, err := UpdatePodcast(ctx, p, 0 /*flags*/)
+		if pushed[p.ID] && p.LastAttemptTime.After(time.Now().Add(-24*time.Hour)) {
+			continue
+		}
+
+		log.Printf("Updating podcast %s, LastFetchTime = %v, LastAttemptTime = %v", p.Title, p.LastFetchTime, p.LastAttemptTime)
+		numUpdated, err := UpdatePodcast(ctx, db, p, 0 /*flags*/)
 		if err != nil {
-			return fmt.Errorf("error updating podcast: %w", err)
+			// Don't let one broken feed stop us from updating all the others.
+			log.Printf("Error updating podcast: %v", err)
+			continue
 		}
 		log.Printf(" - updated %d episodes", numUpdated)
 	}
 	return nil
 }
 
-func UpdatePodcast(ctx context.Context, podcast *store.Podcast, flags rss.UpdatePodcastFlags) (int, error) {
+func UpdatePodcast(ctx context.Context, db store.Backend, podcast *store.Podcast, flags rss.UpdatePodcastFlags) (int, error) {
 	// The podcast we get here will not have the episodes populated, as it comes from the list.
 	// So fetch the episodes manually. We just get the latest 10 episodes. Anything older than this
 	// we will ignore entirely.
-	episodes, err := store.LoadEpisodes(ctx, podcast.ID, 10)
+	episodes, err := db.LoadEpisodes(ctx, podcast.ID, 10)
 	if err != nil {
 		return 0, fmt.Errorf("error fetching podcast: %v", err)
 	}
 	podcast.Episodes = episodes
 
 	// Actually do the update.
-	numUpdated, err := rss.UpdatePodcast(ctx, podcast, flags)
+	numUpdated, err := rss.UpdatePodcast(ctx, db, podcast, flags)
+	podcast.LastAttemptTime = time.Now()
 	if err != nil {
+		// We still update the last attempt time, so that the podcast goes to the back of the queue.
+		// Otherwise a broken feed would be the first one we try every time. The last fetch time
+		// stays as it was, since it's what we ask the server for changes since.
+		if _, saveErr := db.SavePodcast(ctx, podcast); saveErr != nil {
+			log.Printf("Error saving last attempt time of podcast %d: %v", podcast.ID, saveErr)
+		}
 		return 0, fmt.Errorf("error updating podcast '%s': %v", podcast.Title, err)
 	}
 
 	// Update the last fetch time.
-	podcast.LastFetchTime = time.Now()
-	_, err = store.SavePodcast(ctx, podcast)
+	podcast.LastFetchTime = podcast.LastAttemptTime
+	_, err = db.SavePodcast(ctx, podcast)
 
 	return numUpdated, err
 }
@@ -108,11 +134,11 @@ func RunCronJob(ctx context.Context, now time.Time, job *store.CronJob) error {
 }
 
 // cronIterate is run in a goroutine to actually execute the cron tasks.
-func cronIterate() error {
+func cronIterate(db store.CronStore) error {
 	ctx := context.Background()
 
 	now := time.Now()
-	jobs, err := store.LoadPendingCronJobs(ctx, now)
+	jobs, err := db.LoadPendingCronJobs(ctx, now)
 	if err != nil {
 		return err
 	}
@@ -122,7 +148,7 @@ func cronIterate() error {
 		if err != nil {
 			return err
 		} else {
-			err := store.SaveCronJob(ctx, job)
+			err := db.SaveCronJob(ctx, job)
 			if err != nil {
 				return err
 			}
@@ -133,22 +159,22 @@ func cronIterate() error {
 }
 
 // runCronIterate is a helper that runs cronIterate and then schedules itself to run again.
-func runCronIterate() {
+func runCronIterate(db store.CronStore) {
 	ctx := context.Background()
 	now := time.Now()
-	timeToWait := store.GetTimeToNextCronJob(ctx, now)
+	timeToWait := db.GetTimeToNextCronJob(ctx, now)
 
 	log.Printf("Waiting %v to next cron job", timeToWait)
 	time.Sleep(timeToWait)
 
-	err := cronIterate()
+	err := cronIterate(db)
 	if err != nil {
 		log.Printf("Error running cronIterate: %v", err)
 		// Keep going, schedule again.
 	}
 
 	// Schedule to run again.
-	go runCronIterate()
+	go runCronIterate(db)
 }
 
 // Gets a list of the cron job names.
@@ -160,13 +186,18 @@ func GetCronJobNames() []string {
 	return names
 }
 
-// Setup is called from server.go and sets up our routes, etc.
-func Setup(r *mux.Router) error {
+// Setup is called from server.go and sets up our routes, etc. All of the jobs use the given backend.
+func Setup(r *mux.Router, db store.Backend) error {
 	Jobs = make(map[string]func(context.Context) error)
-	Jobs["check-updates"] = cronCheckUpdates
+	Jobs["check-updates"] = func(ctx context.Context) error { return cronCheckUpdates(ctx, db) }
+	Jobs["websub-renew"] = func(ctx context.Context) error { return websub.RenewSubscriptions(ctx, db) }
+	Jobs["resanitize-descriptions"] = func(ctx context.Context) error { return rss.ResanitizeDescriptions(ctx, db) }
+	Jobs["probe-durations"] = func(ctx context.Context) error { return probe.ProbeDurations(ctx, db) }
+	Jobs["cache-media"] = func(ctx context.Context) error { return mediacache.CacheMedia(ctx, db) }
+	Jobs["evict-media"] = func(ctx context.Context) error { return mediacache.EvictMedia(ctx, db) }
 
 	// Run the cron goroutine start away.
-	go runCronIterate()
+	go runCronIterate(db)
 
 	return nil
 }
@@ -1,6 +1,7 @@
 package discover
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/json"
 	"fmt"
@@ -11,15 +12,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/podcreep/server/fetch"
 	"github.com/podcreep/server/util"
 )
 
 var (
 	apiKey    string
 	apiSecret string
-
-	// http.Client we'll use to make HTTP requests.
-	httpClient = &http.Client{}
 )
 
 type Podcast struct {
@@ -45,6 +44,8 @@ type PodcastEpisode struct {
 	Description   string `json:"description"`
 	DatePublished int64  `json:"datePublished"`
 	Url           string `json:"enclosureUrl"`
+	EnclosureType string `json:"enclosureType"`
+	EnclosureSize int64  `json:"enclosureLength"`
 	Duration      int64  `json:"duration"`
 }
 
@@ -64,8 +65,8 @@ type PodcastResult struct {
 }
 
 // makeRequest makes a request for the given URL and then appends all
-func makeRequest(url string) (*http.Request, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func makeRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching %s: %w", url, err)
 	}
@@ -94,7 +95,7 @@ func Setup() error {
 }
 
 func performQuery(req *http.Request) ([]Podcast, error) {
-	resp, err := httpClient.Do(req)
+	resp, err := fetch.Default.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching trending: %v", err)
 	}
@@ -114,8 +115,8 @@ func performQuery(req *http.Request) ([]Podcast, error) {
 	return res.Feeds, nil
 }
 
-func FetchTrending() ([]Podcast, error) {
-	req, err := makeRequest("https://api.podcastindex.org/api/1.0/podcasts/trending")
+func FetchTrending(ctx context.Context) ([]Podcast, error) {
+	req, err := makeRequest(ctx, "https://api.podcastindex.org/api/1.0/podcasts/trending")
 	if err != nil {
 		return nil, fmt.Errorf("error making request: %v", err)
 	}
@@ -123,8 +124,8 @@ func FetchTrending() ([]Podcast, error) {
 	return performQuery(req)
 }
 
-func Search(query string) ([]Podcast, error) {
-	req, err := makeRequest("https://api.podcastindex.org/api/1.0/search/byterm")
+func Search(ctx context.Context, query string) ([]Podcast, error) {
+	req, err := makeRequest(ctx, "https://api.podcastindex.org/api/1.0/search/byterm")
 	if err != nil {
 		return nil, fmt.Errorf("error making request: %v", err)
 	}
@@ -136,8 +137,8 @@ func Search(query string) ([]Podcast, error) {
 	return performQuery(req)
 }
 
-func FetchPodcast(id int64, includeEpisodes bool) (*Podcast, []*PodcastEpisode, error) {
-	req, err := makeRequest(fmt.Sprintf("https://api.podcastindex.org/api/1.0/podcasts/byfeedid"))
+func FetchPodcast(ctx context.Context, id int64, includeEpisodes bool) (*Podcast, []*PodcastEpisode, error) {
+	req, err := makeRequest(ctx, fmt.Sprintf("https://api.podcastindex.org/api/1.0/podcasts/byfeedid"))
 	if err != nil {
 		return nil, nil, fmt.Errorf("error making request: %v", err)
 	}
@@ -145,7 +146,7 @@ func FetchPodcast(id int64, includeEpisodes bool) (*Podcast, []*PodcastEpisode,
 	q.Add("id", strconv.FormatInt(id, 10))
 	req.URL.RawQuery = q.Encode()
 
-	resp, err := httpClient.Do(req)
+	resp, err := fetch.Default.Do(req)
 	if err != nil {
 		return nil, nil, fmt.Errorf("error fetching trending: %v", err)
 	}
@@ -164,13 +165,13 @@ func FetchPodcast(id int64, includeEpisodes bool) (*Podcast, []*PodcastEpisode,
 
 	episodes := EpisodeListResult{}
 	if includeEpisodes {
-		req, err = makeRequest(fmt.Sprintf("https://api.podcastindex.org/api/1.0/episodes/byfeedid"))
+		req, err = makeRequest(ctx, fmt.Sprintf("https://api.podcastindex.org/api/1.0/episodes/byfeedid"))
 		if err != nil {
 			return nil, nil, fmt.Errorf("error making request: %v", err)
 		}
 		req.URL.RawQuery = q.Encode()
 
-		resp, err = httpClient.Do(req)
+		resp, err = fetch.Default.Do(req)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error fetching trending: %v", err)
 		}
@@ -0,0 +1,137 @@
+package fetch
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fake is a Fetcher that serves files from a local directory rather than making real requests,
+// which lets us test feeds from fixtures. A request for http://example.com/feeds/show.xml is served
+// from <Dir>/example.com/feeds/show.xml, and a request for a URL ending in "/" is served from the
+// "index" file in that directory. Anything that doesn't exist is a 404.
+type Fake struct {
+	Dir string
+}
+
+// NewFake creates a new Fake that serves files from the given directory.
+func NewFake(dir string) *Fake {
+	return &Fake{Dir: dir}
+}
+
+// Do serves the given request from the Fake's directory. If-Modified-Since is honoured, using the
+// modification time of the file, and so are simple (single) Range requests.
+func (f *Fake) Do(req *http.Request) (*http.Response, error) {
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	p := path.Clean("/" + req.URL.Path)
+	if strings.HasSuffix(req.URL.Path, "/") || p == "/" {
+		p = path.Join(p, "index")
+	}
+	filename := filepath.Join(f.Dir, req.URL.Host, filepath.FromSlash(p))
+
+	data, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return newFakeResponse(req, http.StatusNotFound, nil), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading fixture %s: %w", filename, err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading fixture %s: %w", filename, err)
+	}
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	if ims, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil && !modTime.After(ims) {
+		return newFakeResponse(req, http.StatusNotModified, nil), nil
+	}
+
+	status := http.StatusOK
+	var contentRange string
+	if start, end, ok := parseRange(req.Header.Get("Range"), int64(len(data))); ok {
+		if start >= int64(len(data)) {
+			return newFakeResponse(req, http.StatusRequestedRangeNotSatisfiable, nil), nil
+		}
+		status = http.StatusPartialContent
+		contentRange = fmt.Sprintf("bytes %d-%d/%d", start, end, len(data))
+		data = data[start : end+1]
+	}
+
+	resp := newFakeResponse(req, status, data)
+	if contentRange != "" {
+		resp.Header.Set("Content-Range", contentRange)
+	}
+	resp.Header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+	// We leave off the charset that mime adds, the fixture's XML declaration should say what it is.
+	if contentType, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(filename))); err == nil {
+		resp.Header.Set("Content-Type", contentType)
+	}
+	return resp, nil
+}
+
+// parseRange parses a Range header with a single range (e.g. "bytes=0-1023", "bytes=1024-" or
+// "bytes=-128") for a file of the given size, and returns the first and last byte offsets. Returns
+// false if there's no Range header, or it's not one we understand, in which case we just serve the
+// whole file.
+func parseRange(header string, size int64) (start, end int64, ok bool) {
+	if !strings.HasPrefix(header, "bytes=") || strings.Contains(header, ",") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(header, "bytes="), "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	var err error
+	if parts[0] == "" {
+		// A suffix range: the last n bytes.
+		n, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	if start, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, 0, false
+	}
+	end = size - 1
+	if parts[1] != "" {
+		if end, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if end < start && start < size {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func newFakeResponse(req *http.Request, status int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,83 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header     string
+		size       int64
+		start, end int64
+		ok         bool
+	}{
+		{"", 100, 0, 0, false},
+		{"bytes=0-9", 100, 0, 9, true},
+		{"bytes=10-", 100, 10, 99, true},
+		{"bytes=-10", 100, 90, 99, true},
+		{"bytes=-200", 100, 0, 99, true},
+		{"bytes=90-200", 100, 90, 99, true},
+		{"bytes=100-", 100, 100, 99, true},
+		{"bytes=9-0", 100, 0, 0, false},
+		{"bytes=0-9,20-29", 100, 0, 0, false},
+		{"items=0-9", 100, 0, 0, false},
+		{"bytes=a-9", 100, 0, 0, false},
+		{"bytes=0-b", 100, 0, 0, false},
+		{"bytes=-c", 100, 0, 0, false},
+		{"bytes=10", 100, 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, ok := parseRange(test.header, test.size)
+		if ok != test.ok || (ok && (start != test.start || end != test.end)) {
+			t.Errorf("parseRange(%q, %d) = %d, %d, %v, want %d, %d, %v", test.header, test.size, start, end, ok, test.start, test.end, test.ok)
+		}
+	}
+}
+
+func TestFake(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "example.com", "feeds"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "example.com", "feeds", "show.xml"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFake(dir)
+
+	get := func(url string, header ...string) (*http.Response, string) {
+		req, _ := http.NewRequestWithContext(context.Background(), "GET", url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := f.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return resp, string(data)
+	}
+
+	if resp, body := get("http://example.com/feeds/show.xml"); resp.StatusCode != 200 || body != "0123456789" || resp.Header.Get("Content-Type") != "text/xml" {
+		t.Errorf("got %d %q (%s), want 200 %q (text/xml)", resp.StatusCode, body, resp.Header.Get("Content-Type"), "0123456789")
+	}
+	if resp, _ := get("http://example.com/feeds/missing.xml"); resp.StatusCode != 404 {
+		t.Errorf("missing file: got %d, want 404", resp.StatusCode)
+	}
+	if resp, body := get("http://example.com/feeds/show.xml", "Range", "bytes=2-4"); resp.StatusCode != 206 || body != "234" || resp.Header.Get("Content-Range") != "bytes 2-4/10" {
+		t.Errorf("range: got %d %q (%s), want 206 %q (bytes 2-4/10)", resp.StatusCode, body, resp.Header.Get("Content-Range"), "234")
+	}
+	if resp, _ := get("http://example.com/feeds/show.xml", "Range", "bytes=20-"); resp.StatusCode != 416 {
+		t.Errorf("range past the end: got %d, want 416", resp.StatusCode)
+	}
+
+	resp, _ := get("http://example.com/feeds/show.xml")
+	if resp, _ := get("http://example.com/feeds/show.xml", "If-Modified-Since", resp.Header.Get("Last-Modified")); resp.StatusCode != 304 {
+		t.Errorf("If-Modified-Since: got %d, want 304", resp.StatusCode)
+	}
+}
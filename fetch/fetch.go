@@ -0,0 +1,299 @@
+// Package fetch is how we make outgoing HTTP requests for feeds, images and so on. Everything goes
+// through a Fetcher, which adds timeouts, size limits and retries on top of http.Client, and which
+// can be swapped out for a Fake that serves local files.
+package fetch
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/podcreep/server/util"
+)
+
+// Fetcher makes HTTP requests. The response body must be closed by the caller, as with
+// http.Client.Do.
+type Fetcher interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Options controls the behaviour of the Fetcher returned by New.
+type Options struct {
+	// ConnectTimeout is how long we'll wait to connect to the server (including the TLS handshake).
+	ConnectTimeout time.Duration
+
+	// ReadTimeout is how long we'll wait for the server to send the response headers, and then how
+	// long we'll wait between reads of the body. A slow server is fine, as long as it keeps sending.
+	ReadTimeout time.Duration
+
+	// MaxResponseSize is the maximum number of bytes we'll read from a response body (after
+	// decompressing it). Reading past this returns ErrResponseTooLarge. Zero means no limit.
+	MaxResponseSize int64
+
+	// MaxRetries is the number of times we'll retry a request that fails with a network error or a
+	// 5xx/429 status. Only GET and HEAD requests are retried.
+	MaxRetries int
+
+	// RetryBackoff is how long we wait before the first retry. It doubles for each retry after that.
+	RetryBackoff time.Duration
+}
+
+// ErrResponseTooLarge is returned when reading a response body that is larger than the
+// MaxResponseSize.
+var ErrResponseTooLarge = errors.New("response too large")
+
+// DefaultOptions returns the Options we use when nothing else has been configured.
+func DefaultOptions() Options {
+	return Options{
+		ConnectTimeout:  10 * time.Second,
+		ReadTimeout:     30 * time.Second,
+		MaxResponseSize: 50 * 1024 * 1024,
+		MaxRetries:      2,
+		RetryBackoff:    time.Second,
+	}
+}
+
+// Default is the Fetcher everything uses, unless told otherwise.
+var Default Fetcher = New(DefaultOptions())
+
+// defaultMaxMediaSize is the default MaxResponseSize of the Media fetcher.
+const defaultMaxMediaSize = 2 * 1024 * 1024 * 1024
+
+// Media is the Fetcher we use to download episode media, which is the same as Default except that
+// it allows much larger responses.
+var Media Fetcher = New(mediaOptions(DefaultOptions()))
+
+// mediaOptions returns the Options for the Media fetcher, based on the given Default options.
+func mediaOptions(opts Options) Options {
+	opts.MaxResponseSize = defaultMaxMediaSize
+	return opts
+}
+
+// Setup configures the Default and Media fetchers from the environment. The timeouts and limits
+// can be overridden with FETCH_CONNECT_TIMEOUT, FETCH_READ_TIMEOUT (both durations, e.g. "10s"),
+// FETCH_MAX_SIZE (in bytes) and FETCH_MAX_RETRIES, and the Media fetcher's size limit with
+// FETCH_MAX_MEDIA_SIZE. If FETCH_FIXTURES_DIR is set, we don't make any real requests at all, and
+// serve everything from that directory instead (see Fake).
+func Setup() error {
+	if dir := os.Getenv("FETCH_FIXTURES_DIR"); dir != "" {
+		log.Printf("Serving all fetches from fixtures in: %s", dir)
+		Default = NewFake(dir)
+		Media = Default
+		return nil
+	}
+
+	opts := DefaultOptions()
+	if str := os.Getenv("FETCH_CONNECT_TIMEOUT"); str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("invalid FETCH_CONNECT_TIMEOUT: %w", err)
+		}
+		opts.ConnectTimeout = d
+	}
+	if str := os.Getenv("FETCH_READ_TIMEOUT"); str != "" {
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return fmt.Errorf("invalid FETCH_READ_TIMEOUT: %w", err)
+		}
+		opts.ReadTimeout = d
+	}
+	if str := os.Getenv("FETCH_MAX_SIZE"); str != "" {
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FETCH_MAX_SIZE: %w", err)
+		}
+		opts.MaxResponseSize = n
+	}
+	if str := os.Getenv("FETCH_MAX_RETRIES"); str != "" {
+		n, err := strconv.Atoi(str)
+		if err != nil {
+			return fmt.Errorf("invalid FETCH_MAX_RETRIES: %w", err)
+		}
+		opts.MaxRetries = n
+	}
+
+	Default = New(opts)
+
+	mopts := mediaOptions(opts)
+	if str := os.Getenv("FETCH_MAX_MEDIA_SIZE"); str != "" {
+		n, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid FETCH_MAX_MEDIA_SIZE: %w", err)
+		}
+		mopts.MaxResponseSize = n
+	}
+	Media = New(mopts)
+	return nil
+}
+
+// httpFetcher is the real Fetcher, which makes requests with an http.Client.
+type httpFetcher struct {
+	opts   Options
+	client *http.Client
+}
+
+// New creates a new Fetcher that makes real HTTP requests with the given options.
+func New(opts Options) Fetcher {
+	dialer := &net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.ConnectTimeout,
+		ResponseHeaderTimeout: opts.ReadTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConnsPerHost:   4,
+		// We handle gzip ourselves, so that the size limit applies to the decompressed body.
+		DisableCompression: true,
+	}
+
+	return &httpFetcher{
+		opts:   opts,
+		client: &http.Client{Transport: transport},
+	}
+}
+
+// Do makes the given request, retrying if it fails with something that looks temporary.
+func (f *httpFetcher) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", util.GetUserAgent())
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	canRetry := (req.Method == "GET" || req.Method == "HEAD") && req.Body == nil
+	backoff := f.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := f.do(req)
+		if !canRetry || attempt >= f.opts.MaxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		if err != nil {
+			log.Printf("Error fetching %s, will retry in %s: %v", req.URL, backoff, err)
+		} else {
+			log.Printf("Error fetching %s, will retry in %s: status=%d", req.URL, backoff, resp.StatusCode)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// do makes a single attempt at the given request.
+func (f *httpFetcher) do(req *http.Request) (*http.Response, error) {
+	// The ResponseHeaderTimeout covers us until we get the headers, after that we cancel the request
+	// if the body stalls for longer than the ReadTimeout.
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	body := &limitedBody{
+		ReadCloser: resp.Body,
+		remaining:  f.opts.MaxResponseSize,
+		cancel:     cancel,
+	}
+	if body.remaining <= 0 {
+		body.remaining = math.MaxInt64
+	}
+	if f.opts.ReadTimeout > 0 {
+		body.timeout = f.opts.ReadTimeout
+		body.timer = time.AfterFunc(f.opts.ReadTimeout, cancel)
+	}
+	resp.Body = body
+
+	hasBody := req.Method != "HEAD" && resp.StatusCode != http.StatusNotModified && resp.StatusCode != http.StatusNoContent
+	if resp.Header.Get("Content-Encoding") == "gzip" && hasBody {
+		gz, err := gzip.NewReader(body.ReadCloser)
+		if err != nil {
+			body.Close()
+			return nil, fmt.Errorf("error decompressing response: %w", err)
+		}
+		body.ReadCloser = &gzipBody{Reader: gz, underlying: body.ReadCloser}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+
+	return resp, nil
+}
+
+// shouldRetry returns true if the given result of a request looks like a temporary error.
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		// Don't bother retrying if the request was cancelled by the caller.
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// limitedBody wraps a response body to enforce the MaxResponseSize and the ReadTimeout.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	timeout   time.Duration
+	timer     *time.Timer
+	cancel    context.CancelFunc
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// We've read as much as we're allowed, it's only an error if there's actually more.
+		var one [1]byte
+		if n, err := b.ReadCloser.Read(one[:]); n == 0 {
+			return 0, err
+		}
+		return 0, ErrResponseTooLarge
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	if b.timer != nil {
+		b.timer.Reset(b.timeout)
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// gzipBody decompresses a response body, and closes the underlying body when it's closed.
+type gzipBody struct {
+	*gzip.Reader
+	underlying io.ReadCloser
+}
+
+func (b *gzipBody) Close() error {
+	b.Reader.Close()
+	return b.underlying.Close()
+}
@@ -0,0 +1,121 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimitedBody(t *testing.T) {
+	tests := []struct {
+		body      string
+		remaining int64
+		want      string
+		wantErr   error
+	}{
+		{"hello", 10, "hello", nil},
+		{"hello", 5, "hello", nil},
+		{"hello, world", 5, "hello", ErrResponseTooLarge},
+		{"", 0, "", nil},
+	}
+	for _, test := range tests {
+		cancelled := false
+		body := &limitedBody{
+			ReadCloser: io.NopCloser(strings.NewReader(test.body)),
+			remaining:  test.remaining,
+			cancel:     func() { cancelled = true },
+		}
+		data, err := io.ReadAll(body)
+		if string(data) != test.want || !errors.Is(err, test.wantErr) {
+			t.Errorf("reading %q with a limit of %d: got %q, %v, want %q, %v", test.body, test.remaining, data, err, test.want, test.wantErr)
+		}
+		if err := body.Close(); err != nil || !cancelled {
+			t.Errorf("closing %q: got %v, cancelled=%v, want nil, cancelled=true", test.body, err, cancelled)
+		}
+	}
+}
+
+// newTestFetcher returns a Fetcher with the given size limit and no retries, and a server that
+// serves the given content, gzipped if the request asks for it.
+func newTestFetcher(t *testing.T, maxSize int64, content string) (Fetcher, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") == "gzip" && r.Header.Get("Range") == "" {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, content)
+			gz.Close()
+			return
+		}
+		http.ServeContent(w, r, "content.txt", time.Time{}, strings.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+
+	opts := DefaultOptions()
+	opts.MaxResponseSize = maxSize
+	opts.MaxRetries = 0
+	return New(opts), server
+}
+
+func TestFetchGzip(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	f, server := newTestFetcher(t, 2000, content)
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+	resp, err := f.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if !resp.Uncompressed || resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("got Uncompressed=%v Content-Encoding=%q, want a decompressed response", resp.Uncompressed, resp.Header.Get("Content-Encoding"))
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil || string(data) != content {
+		t.Errorf("got %d bytes, %v, want the %d bytes of content", len(data), err, len(content))
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	// The content compresses to much less than the limit, but the limit applies to the decompressed
+	// body.
+	content := strings.Repeat("0123456789", 100)
+	f, server := newTestFetcher(t, 500, content)
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+	resp, err := f.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if !errors.Is(err, ErrResponseTooLarge) || len(data) != 500 {
+		t.Errorf("got %d bytes, %v, want 500 bytes, %v", len(data), err, ErrResponseTooLarge)
+	}
+}
+
+func TestFetchRange(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	f, server := newTestFetcher(t, 2000, content)
+
+	req, _ := http.NewRequestWithContext(context.Background(), "GET", server.URL, nil)
+	req.Header.Set("Range", "bytes=10-19")
+	resp, err := f.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(data, []byte("0123456789")) {
+		t.Errorf("got status %d, %q, want %d, %q", resp.StatusCode, data, http.StatusPartialContent, "0123456789")
+	}
+}
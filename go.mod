@@ -9,8 +9,11 @@ require (
 	github.com/gorilla/schema v1.2.0
 	github.com/jackc/pgtype v1.13.0
 	github.com/jackc/pgx/v4 v4.17.2
+	github.com/mattn/go-sqlite3 v1.14.19
 	github.com/microcosm-cc/bluemonday v1.0.21
 	golang.org/x/crypto v0.4.0
+	golang.org/x/net v0.4.0
+	golang.org/x/text v0.5.0
 )
 
 require (
@@ -28,6 +31,4 @@ require (
 	github.com/jackc/puddle v1.3.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	golang.org/x/image v0.2.0
-	golang.org/x/net v0.4.0 // indirect
-	golang.org/x/text v0.5.0 // indirect
 )
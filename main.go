@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,7 +14,9 @@ import (
 	"github.com/podcreep/server/api"
 	"github.com/podcreep/server/cron"
 	"github.com/podcreep/server/discover"
+	"github.com/podcreep/server/fetch"
 	"github.com/podcreep/server/store"
+	"github.com/podcreep/server/websub"
 )
 
 func setupStaticFiles(r *mux.Router) {
@@ -28,6 +32,33 @@ func setupStaticFiles(r *mux.Router) {
 	})
 }
 
+// runMigrateCommand runs one of the -migrate commands against the database.
+func runMigrateCommand(command string, target int) error {
+	ctx := context.Background()
+	db, err := store.Connect()
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case "status":
+		return store.PrintSchemaStatus(ctx, db, os.Stdout)
+	case "dry-run":
+		return store.MigrateSchema(ctx, db, store.MigrateOptions{Target: target, DryRun: true})
+	case "up":
+		return store.MigrateSchema(ctx, db, store.MigrateOptions{Target: target})
+	case "down":
+		if target < 0 {
+			target = store.GetCurrentSchemaVersion(ctx, db) - 1
+		}
+		if target < 0 {
+			return fmt.Errorf("already at version 0")
+		}
+		return store.MigrateSchema(ctx, db, store.MigrateOptions{Target: target})
+	}
+	return fmt.Errorf("unknown migrate command: %s", command)
+}
+
 func main() {
 	log.SetFlags(log.Lshortfile | log.LstdFlags)
 
@@ -36,20 +67,38 @@ func main() {
 		port = "8080"
 	}
 
-	if err := store.Setup(); err != nil {
+	migrate := flag.String("migrate", "", "Run a schema migration command and exit: status, dry-run, up or down")
+	migrateTo := flag.Int("migrate-to", -1, "The version to migrate to with -migrate (default: latest for up and dry-run, one version down for down)")
+	flag.Parse()
+
+	if *migrate != "" {
+		if err := runMigrateCommand(*migrate, *migrateTo); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	db, err := store.Setup()
+	if err != nil {
 		panic(err)
 	}
 	r := mux.NewRouter()
-	if err := admin.Setup(r); err != nil {
+	if err := admin.Setup(r, db); err != nil {
 		panic(err)
 	}
-	if err := api.Setup(r); err != nil {
+	if err := api.Setup(r, db); err != nil {
 		panic(err)
 	}
 	if err := discover.Setup(); err != nil {
 		panic(err)
 	}
-	if err := cron.Setup(r); err != nil {
+	if err := fetch.Setup(); err != nil {
+		panic(err)
+	}
+	if err := cron.Setup(r, db); err != nil {
+		panic(err)
+	}
+	if err := websub.Setup(r, db); err != nil {
 		panic(err)
 	}
 	setupStaticFiles(r)
@@ -0,0 +1,393 @@
+// Package mediacache downloads episode media into the blob store, for podcasts that have it turned
+// on. That way we can keep serving episodes even if the publisher deletes them, and clients get
+// consistent, fast downloads from us rather than whatever the publisher's host is like.
+package mediacache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/podcreep/server/fetch"
+	"github.com/podcreep/server/store"
+)
+
+const (
+	// blobName is the name of the directory in the blob store we keep the media in.
+	blobName = "media"
+
+	// maxDownloadsPerRun is the maximum number of episodes we'll download each time the cron job
+	// runs, so that it doesn't run forever when caching is turned on for a big podcast.
+	maxDownloadsPerRun = 20
+
+	// tempPrefix is the prefix of the files we download into, before renaming them into place.
+	tempPrefix = "download-"
+
+	// maxTempAge is how long we leave temporary files before assuming they're left over from a
+	// download that crashed.
+	maxTempAge = 24 * time.Hour
+)
+
+// errTooBig is returned by download when the media turns out to be bigger than the podcast has room
+// for.
+var errTooBig = errors.New("media is over the podcast's size limit")
+
+// Path returns the path in the blob store to the given episode's cached media.
+func Path(episodeID int64) (string, error) {
+	basePath, err := store.GetBlobStorePath(blobName)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(basePath, strconv.FormatInt(episodeID, 10)), nil
+}
+
+// maxTotalBytes returns the limit on the size of the whole cache, from MEDIA_CACHE_MAX_BYTES. Zero
+// means there's no limit.
+func maxTotalBytes() (int64, error) {
+	str := os.Getenv("MEDIA_CACHE_MAX_BYTES")
+	if str == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid MEDIA_CACHE_MAX_BYTES: %w", err)
+	}
+	return n, nil
+}
+
+// loadCachedEpisodes loads the episodes that CacheMedia downloads, that is the newest MaxEpisodes
+// episodes of each podcast with caching enabled. The result maps each episode's ID to its position
+// in its podcast, newest first.
+func loadCachedEpisodes(ctx context.Context, db store.EpisodeStore, settings []*store.MediaCacheSettings) (map[int64]int, error) {
+	positions := make(map[int64]int)
+	for _, s := range settings {
+		if !s.Enabled || s.MaxEpisodes <= 0 {
+			continue
+		}
+
+		episodes, err := db.LoadEpisodes(ctx, s.PodcastID, s.MaxEpisodes)
+		if err != nil {
+			return nil, err
+		}
+		for i, ep := range episodes {
+			positions[ep.ID] = i
+		}
+	}
+	return positions, nil
+}
+
+// CacheMedia is run as a cron job. It downloads the media of the most recent episodes of each
+// podcast that has caching enabled, up to the podcast's limits (and MEDIA_CACHE_MAX_BYTES, if
+// it's set).
+func CacheMedia(ctx context.Context, db store.Backend) error {
+	maxTotalBytes, err := maxTotalBytes()
+	if err != nil {
+		return err
+	}
+
+	settings, err := db.LoadAllMediaCacheSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	var cacheBytes int64
+	if maxTotalBytes > 0 {
+		media, err := db.LoadAllEpisodeMedia(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range media {
+			cacheBytes += m.Size
+		}
+	}
+
+	numDownloaded := 0
+	for _, s := range settings {
+		if !s.Enabled || s.MaxEpisodes <= 0 {
+			continue
+		}
+
+		episodes, err := db.LoadEpisodes(ctx, s.PodcastID, s.MaxEpisodes)
+		if err != nil {
+			return err
+		}
+
+		var totalBytes int64
+		for _, ep := range episodes {
+			m, err := db.LoadEpisodeMedia(ctx, ep.ID)
+			if err != nil {
+				return err
+			}
+			if m != nil {
+				totalBytes += m.Size
+				continue
+			}
+			if ep.RemovedAt != nil || ep.MediaURL == "" {
+				// Too late, we won't be able to download it now.
+				continue
+			}
+			if s.MaxBytes > 0 && (totalBytes >= s.MaxBytes ||
+				(ep.MediaLength != nil && totalBytes+*ep.MediaLength > s.MaxBytes)) {
+				// The episodes are newest first, so this one and everything older is over the limit.
+				break
+			}
+			if maxTotalBytes > 0 && (cacheBytes >= maxTotalBytes ||
+				(ep.MediaLength != nil && cacheBytes+*ep.MediaLength > maxTotalBytes)) {
+				// EvictMedia would only have to delete it again.
+				log.Printf("Media cache is full, not caching any more of podcast %d", s.PodcastID)
+				break
+			}
+
+			if numDownloaded >= maxDownloadsPerRun {
+				log.Printf("Downloaded %d episodes, will download the rest next time", numDownloaded)
+				return nil
+			}
+			numDownloaded++
+
+			// The feed's length is often missing or wrong, so download won't go over what's left either.
+			var maxBytes int64
+			if s.MaxBytes > 0 {
+				maxBytes = s.MaxBytes - totalBytes
+			}
+			if maxTotalBytes > 0 && (maxBytes == 0 || maxTotalBytes-cacheBytes < maxBytes) {
+				maxBytes = maxTotalBytes - cacheBytes
+			}
+			m, err = download(ctx, db, ep, maxBytes)
+			if errors.Is(err, errTooBig) {
+				log.Printf("Media for episode %d is over the limit of podcast %d", ep.ID, s.PodcastID)
+				break
+			}
+			if err != nil {
+				// Don't let one bad episode stop us from downloading the others.
+				log.Printf("Error downloading media for episode %d: %v", ep.ID, err)
+				continue
+			}
+			totalBytes += m.Size
+			cacheBytes += m.Size
+		}
+	}
+
+	log.Printf("Downloaded %d episodes", numDownloaded)
+	return nil
+}
+
+// ContentType returns the content type we serve cached media with, given the one we got from the
+// publisher. Only audio and video types are allowed, anything else could be something like HTML that
+// a browser would happily run on our domain.
+func ContentType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !(strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/")) {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// download downloads the given episode's media into the blob store. If maxBytes is more than zero,
+// it gives up with errTooBig once the media gets bigger than that.
+func download(ctx context.Context, db store.EpisodeStore, ep *store.Episode, maxBytes int64) (*store.EpisodeMedia, error) {
+	path, err := Path(ep.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Downloading media for episode %d: %s", ep.ID, ep.MediaURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", ep.MediaURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	// Media files don't compress, and we want the exact bytes anyway.
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := fetch.Media.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching media: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching media: status=%d", resp.StatusCode)
+	}
+
+	// Download into a temporary file first, so that we never serve a partial file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	var body io.Reader = resp.Body
+	if maxBytes > 0 {
+		// Read one more byte than we allow, so we can tell if it's too big.
+		body = io.LimitReader(body, maxBytes+1)
+	}
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error downloading media: %w", err)
+	}
+	if maxBytes > 0 && size > maxBytes {
+		return nil, errTooBig
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("error saving media: %w", err)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if (contentType == "" || contentType == "application/octet-stream") && ep.MediaType != "" {
+		// Lots of hosts don't bother with a proper content type, the feed is usually better.
+		contentType = ep.MediaType
+	}
+	contentType = ContentType(contentType)
+
+	now := time.Now()
+	m := &store.EpisodeMedia{
+		EpisodeID:      ep.ID,
+		PodcastID:      ep.PodcastID,
+		Size:           size,
+		ContentType:    contentType,
+		SHA256:         hex.EncodeToString(h.Sum(nil)),
+		CachedAt:       now,
+		LastAccessedAt: now,
+	}
+	if err := db.SaveEpisodeMedia(ctx, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EvictMedia is run as a cron job. It deletes the media of each podcast that is over its limits
+// (and all of the media of podcasts that no longer have caching enabled). If MEDIA_CACHE_MAX_BYTES
+// is set, it also deletes media across all podcasts until the whole cache is under that size.
+//
+// We keep the media of the episodes CacheMedia downloads first, newest first just like CacheMedia,
+// and only then the rest, least recently used first. Otherwise we'd delete media that CacheMedia
+// would just download again the next time it runs.
+func EvictMedia(ctx context.Context, db store.Backend) error {
+	maxTotalBytes, err := maxTotalBytes()
+	if err != nil {
+		return err
+	}
+
+	settings, err := db.LoadAllMediaCacheSettings(ctx)
+	if err != nil {
+		return err
+	}
+	settingsByPodcast := make(map[int64]*store.MediaCacheSettings)
+	for _, s := range settings {
+		settingsByPodcast[s.PodcastID] = s
+	}
+
+	positions, err := loadCachedEpisodes(ctx, db, settings)
+	if err != nil {
+		return err
+	}
+
+	media, err := db.LoadAllEpisodeMedia(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The media is most recently accessed first. Move the episodes CacheMedia downloads to the front,
+	// and then we keep everything until we hit a limit.
+	sort.SliceStable(media, func(i, j int) bool {
+		posI, okI := positions[media[i].EpisodeID]
+		posJ, okJ := positions[media[j].EpisodeID]
+		if okI != okJ {
+			return okI
+		}
+		return okI && posI < posJ
+	})
+	numEpisodes := make(map[int64]int)
+	numBytes := make(map[int64]int64)
+	var totalBytes int64
+	cached := make(map[string]bool)
+	numEvicted := 0
+	for _, m := range media {
+		s := settingsByPodcast[m.PodcastID]
+		evict := s == nil || !s.Enabled ||
+			numEpisodes[m.PodcastID] >= s.MaxEpisodes ||
+			(s.MaxBytes > 0 && numBytes[m.PodcastID]+m.Size > s.MaxBytes) ||
+			(maxTotalBytes > 0 && totalBytes+m.Size > maxTotalBytes)
+		if !evict {
+			numEpisodes[m.PodcastID]++
+			numBytes[m.PodcastID] += m.Size
+			totalBytes += m.Size
+			cached[strconv.FormatInt(m.EpisodeID, 10)] = true
+			continue
+		}
+
+		if err := evictEpisode(ctx, db, m.EpisodeID); err != nil {
+			return err
+		}
+		numEvicted++
+	}
+
+	if err := removeOrphans(cached); err != nil {
+		return err
+	}
+
+	log.Printf("Evicted %d episodes, %d episodes (%d bytes) still cached", numEvicted, len(cached), totalBytes)
+	return nil
+}
+
+// evictEpisode deletes the given episode's cached media.
+func evictEpisode(ctx context.Context, db store.EpisodeStore, episodeID int64) error {
+	path, err := Path(episodeID)
+	if err != nil {
+		return err
+	}
+
+	// Delete the row first, so that we never have a row without a file.
+	if err := db.DeleteEpisodeMedia(ctx, episodeID); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error deleting media: %w", err)
+	}
+	return nil
+}
+
+// removeOrphans deletes files in the media directory that aren't in the given set of cached files,
+// e.g. because their episode was deleted, or a download crashed part way through.
+func removeOrphans(cached map[string]bool) error {
+	basePath, err := store.GetBlobStorePath(blobName)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(basePath)
+	if err != nil {
+		return fmt.Errorf("error listing media: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || cached[entry.Name()] {
+			continue
+		}
+		if strings.HasPrefix(entry.Name(), tempPrefix) {
+			info, err := entry.Info()
+			if err != nil || info.ModTime().After(time.Now().Add(-maxTempAge)) {
+				continue
+			}
+		}
+
+		log.Printf("Removing orphaned media file: %s", entry.Name())
+		if err := os.Remove(filepath.Join(basePath, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error deleting media: %w", err)
+		}
+	}
+	return nil
+}
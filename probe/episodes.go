@@ -0,0 +1,57 @@
+package probe
+
+import (
+	"context"
+	"log"
+	"math"
+	"time"
+
+	"github.com/podcreep/server/store"
+)
+
+const (
+	// probeBatchSize is the maximum number of episodes we'll probe each time the cron job runs.
+	probeBatchSize = 50
+
+	// probeTimeout is how long we'll spend probing a single episode.
+	probeTimeout = time.Minute
+
+	// retryAfter is how long we wait before trying again on an episode we couldn't probe.
+	retryAfter = 7 * 24 * time.Hour
+)
+
+// ProbeDurations is run as a cron job. It works out the duration of episodes whose feeds didn't
+// give us one, newest episodes first.
+func ProbeDurations(ctx context.Context, db store.EpisodeStore) error {
+	now := time.Now()
+	episodes, err := db.LoadEpisodesWithoutDuration(ctx, now.Add(-retryAfter), probeBatchSize)
+	if err != nil {
+		return err
+	}
+
+	numProbed := 0
+	for _, ep := range episodes {
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		var size int64
+		if ep.MediaLength != nil {
+			size = *ep.MediaLength
+		}
+		duration, err := Duration(probeCtx, ep.MediaURL, size)
+		cancel()
+
+		if err != nil {
+			// We still save the episode, so that we don't try it again until retryAfter.
+			log.Printf("Error probing duration of episode %d (%s): %v", ep.ID, ep.MediaURL, err)
+		} else {
+			secs := int32(math.Round(duration.Seconds()))
+			ep.DurationSecs = &secs
+			numProbed++
+		}
+		if err := db.SaveEpisodeDuration(ctx, ep, now); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Probed duration of %d of %d episodes", numProbed, len(episodes))
+	return nil
+}
@@ -0,0 +1,204 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// mp3Bitrates are the bitrates, in kbps, for each bitrate index. The first index is MPEG-1 (0) or
+// MPEG-2/2.5 (1), the second is the layer (I, II, III).
+var mp3Bitrates = [2][3][15]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mp3SampleRates are the sample rates for each sample rate index, for MPEG-1, 2 and 2.5.
+var mp3SampleRates = [3][3]int{
+	{44100, 48000, 32000},
+	{22050, 24000, 16000},
+	{11025, 12000, 8000},
+}
+
+// mp3Frame is the header of a single MPEG audio frame.
+type mp3Frame struct {
+	// version is 1 for MPEG-1, 2 for MPEG-2 and 3 for MPEG-2.5.
+	version int
+
+	// layer is 1, 2 or 3.
+	layer int
+
+	// bitrate is in bits per second.
+	bitrate    int
+	sampleRate int
+	padding    bool
+	mono       bool
+}
+
+// parseFrame parses the four-byte MPEG audio frame header at the start of b. Returns false if it's
+// not a valid header.
+func parseFrame(b []byte) (mp3Frame, bool) {
+	var f mp3Frame
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return f, false
+	}
+
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		f.version = 3
+	case 2:
+		f.version = 2
+	case 3:
+		f.version = 1
+	default:
+		return f, false
+	}
+	f.layer = 4 - int((b[1]>>1)&3)
+	if f.layer == 4 {
+		return f, false
+	}
+
+	bitrateIndex := int(b[2] >> 4)
+	sampleRateIndex := int((b[2] >> 2) & 3)
+	if bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		// We don't bother with "free" bitrate files, they're vanishingly rare.
+		return f, false
+	}
+	table := 0
+	if f.version != 1 {
+		table = 1
+	}
+	f.bitrate = mp3Bitrates[table][f.layer-1][bitrateIndex] * 1000
+	f.sampleRate = mp3SampleRates[f.version-1][sampleRateIndex]
+	f.padding = (b[2]>>1)&1 == 1
+	f.mono = b[3]>>6 == 3
+	return f, true
+}
+
+// samplesPerFrame returns the number of samples in each frame.
+func (f mp3Frame) samplesPerFrame() int {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && f.version != 1:
+		return 576
+	}
+	return 1152
+}
+
+// length returns the length of the frame in bytes, including the header.
+func (f mp3Frame) length() int {
+	padding := 0
+	if f.padding {
+		padding = 1
+	}
+	if f.layer == 1 {
+		return (12*f.bitrate/f.sampleRate + padding) * 4
+	}
+	return f.samplesPerFrame()/8*f.bitrate/f.sampleRate + padding
+}
+
+// sideInfoLength returns the length of the side information that follows the header, which is
+// where the Xing header goes.
+func (f mp3Frame) sideInfoLength() int {
+	switch {
+	case f.version == 1 && f.mono:
+		return 17
+	case f.version == 1:
+		return 32
+	case f.mono:
+		return 9
+	}
+	return 17
+}
+
+// findFrame returns the offset of the first MPEG audio frame in b, or -1 if there isn't one. To
+// avoid being fooled by random data that happens to look like a header, a frame only counts if it's
+// followed by another one (or by the end of b).
+func findFrame(b []byte) int {
+	for i := 0; i+4 <= len(b); i++ {
+		f, ok := parseFrame(b[i:])
+		if !ok {
+			continue
+		}
+		next := i + f.length()
+		if next+4 > len(b) {
+			return i
+		}
+		if _, ok := parseFrame(b[next:]); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// mp3Duration works out the duration of an MP3 file. For VBR files we use the frame count from the
+// Xing (or VBRI) header in the first frame. For CBR files, we work it out from the size of the file
+// and the bitrate.
+func mp3Duration(src *source, head []byte) (time.Duration, error) {
+	audioStart := int64(0)
+	if len(head) >= 10 && string(head[:3]) == "ID3" {
+		// Skip the ID3v2 tag. Its size is a "syncsafe" integer, with 7 bits per byte.
+		size := int64(head[6]&0x7F)<<21 | int64(head[7]&0x7F)<<14 | int64(head[8]&0x7F)<<7 | int64(head[9]&0x7F)
+		audioStart = size + 10
+		if head[5]&0x10 != 0 {
+			// There's a footer, too.
+			audioStart += 10
+		}
+
+		if audioStart+headSize/2 > int64(len(head)) {
+			// The tag is big (it probably has cover art in it), fetch the bit that comes after it.
+			var err error
+			head, err = src.readAt(audioStart, headSize)
+			if err != nil {
+				return 0, err
+			}
+		} else {
+			head = head[audioStart:]
+		}
+	}
+
+	offset := findFrame(head)
+	if offset < 0 {
+		return 0, errors.New("no MPEG audio frames found")
+	}
+	audioStart += int64(offset)
+	frame := head[offset:]
+	f, _ := parseFrame(frame)
+
+	xing := 4 + f.sideInfoLength()
+	if len(frame) >= xing+12 && (string(frame[xing:xing+4]) == "Xing" || string(frame[xing:xing+4]) == "Info") {
+		flags := binary.BigEndian.Uint32(frame[xing+4:])
+		if flags&1 != 0 {
+			numFrames := binary.BigEndian.Uint32(frame[xing+8:])
+			return secondsToDuration(float64(numFrames) * float64(f.samplesPerFrame()) / float64(f.sampleRate)), nil
+		}
+	}
+
+	// The VBRI header is always 32 bytes after the frame header.
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		numFrames := binary.BigEndian.Uint32(frame[36+14:])
+		return secondsToDuration(float64(numFrames) * float64(f.samplesPerFrame()) / float64(f.sampleRate)), nil
+	}
+
+	if src.size <= 0 {
+		return 0, errors.New("constant bitrate file, but we don't know its size")
+	}
+	audioEnd := src.size
+	if tail, err := src.readTail(128); err == nil && len(tail) == 128 && string(tail[:3]) == "TAG" {
+		// Don't count the ID3v1 tag at the end, either.
+		audioEnd -= 128
+	}
+	if audioEnd <= audioStart {
+		return 0, errors.New("no audio data")
+	}
+	return secondsToDuration(float64(audioEnd-audioStart) * 8 / float64(f.bitrate)), nil
+}
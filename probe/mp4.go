@@ -0,0 +1,127 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// maxBoxes is the maximum number of top-level boxes we'll look through for the moov box.
+const maxBoxes = 32
+
+// box is the header of an MP4 box (or "atom").
+type box struct {
+	typ string
+
+	// size is the size of the whole box, including the header. Zero means it goes to the end of the
+	// file.
+	size int64
+
+	// headerLen is the size of the header: 8 bytes, or 16 if it has a 64-bit size.
+	headerLen int
+}
+
+// parseBox parses the box header at the start of b.
+func parseBox(b []byte) (box, bool) {
+	if len(b) < 8 {
+		return box{}, false
+	}
+	bx := box{
+		typ:       string(b[4:8]),
+		size:      int64(binary.BigEndian.Uint32(b)),
+		headerLen: 8,
+	}
+	if bx.size == 1 {
+		if len(b) < 16 {
+			return box{}, false
+		}
+		bx.size = int64(binary.BigEndian.Uint64(b[8:]))
+		bx.headerLen = 16
+	}
+	if bx.size != 0 && bx.size < int64(bx.headerLen) {
+		return box{}, false
+	}
+	return bx, true
+}
+
+// mp4Duration works out the duration of an MP4 (M4A) file from the mvhd box inside the moov box.
+// The moov box is either right at the start of the file, or (quite often) after all the media data
+// at the end, in which case we skip over the boxes in between with Range requests.
+func mp4Duration(src *source, head []byte) (time.Duration, error) {
+	buf := head
+	bufStart := int64(0)
+	offset := int64(0)
+	for i := 0; i < maxBoxes; i++ {
+		if offset+16 > bufStart+int64(len(buf)) {
+			if src.size > 0 && offset >= src.size {
+				break
+			}
+			var err error
+			buf, err = src.readAt(offset, headSize)
+			if err != nil {
+				return 0, err
+			}
+			bufStart = offset
+		}
+
+		b := buf[offset-bufStart:]
+		bx, ok := parseBox(b)
+		if !ok {
+			break
+		}
+		if bx.typ == "moov" {
+			body := b[bx.headerLen:]
+			if bx.size != 0 && int64(len(body)) > bx.size-int64(bx.headerLen) {
+				body = body[:bx.size-int64(bx.headerLen)]
+			}
+			return mvhdDuration(body)
+		}
+		if bx.size == 0 {
+			break
+		}
+		offset += bx.size
+	}
+	return 0, errors.New("no moov box found")
+}
+
+// mvhdDuration finds the mvhd box in the given moov box body, and returns the duration from it.
+func mvhdDuration(moov []byte) (time.Duration, error) {
+	for len(moov) >= 8 {
+		bx, ok := parseBox(moov)
+		if !ok {
+			break
+		}
+		if bx.typ != "mvhd" {
+			if bx.size == 0 || bx.size > int64(len(moov)) {
+				break
+			}
+			moov = moov[bx.size:]
+			continue
+		}
+
+		mvhd := moov[bx.headerLen:]
+		var timescale uint32
+		var duration uint64
+		switch {
+		case len(mvhd) >= 20 && mvhd[0] == 0:
+			timescale = binary.BigEndian.Uint32(mvhd[12:])
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+			if duration == 0xFFFFFFFF {
+				duration = 0
+			}
+		case len(mvhd) >= 32 && mvhd[0] == 1:
+			timescale = binary.BigEndian.Uint32(mvhd[20:])
+			duration = binary.BigEndian.Uint64(mvhd[24:])
+			if duration == 0xFFFFFFFFFFFFFFFF {
+				duration = 0
+			}
+		default:
+			return 0, errors.New("invalid mvhd box")
+		}
+		if timescale == 0 || duration == 0 {
+			return 0, errors.New("mvhd box has no duration")
+		}
+		return secondsToDuration(float64(duration) / float64(timescale)), nil
+	}
+	return 0, errors.New("no mvhd box found")
+}
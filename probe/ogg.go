@@ -0,0 +1,55 @@
+package probe
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// oggDuration works out the duration of an Ogg Vorbis or Opus file. The granule position of the
+// last page is the number of samples in the file, and the sample rate comes from the codec's
+// identification header in the first page.
+func oggDuration(src *source, head []byte) (time.Duration, error) {
+	if len(head) < 27 {
+		return 0, errors.New("truncated Ogg page")
+	}
+	serial := binary.LittleEndian.Uint32(head[14:])
+	packetStart := 27 + int(head[26])
+	if packetStart > len(head) {
+		return 0, errors.New("truncated Ogg page")
+	}
+	packet := head[packetStart:]
+
+	var sampleRate float64
+	var preSkip int64
+	switch {
+	case len(packet) >= 16 && packet[0] == 1 && string(packet[1:7]) == "vorbis":
+		sampleRate = float64(binary.LittleEndian.Uint32(packet[12:]))
+	case len(packet) >= 12 && string(packet[:8]) == "OpusHead":
+		// Opus granule positions are always at 48kHz, whatever the input sample rate was, and include
+		// the "pre-skip" samples that the decoder throws away.
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(packet[10:]))
+	default:
+		return 0, ErrUnknownFormat
+	}
+	if sampleRate == 0 {
+		return 0, errors.New("invalid sample rate")
+	}
+
+	tail, err := src.readTail(tailSize)
+	if err != nil {
+		return 0, err
+	}
+	for i := len(tail) - 27; i >= 0; i-- {
+		if string(tail[i:i+4]) != "OggS" || tail[i+4] != 0 || binary.LittleEndian.Uint32(tail[i+14:]) != serial {
+			continue
+		}
+		// A granule position of -1 means no packet finishes on this page, keep looking.
+		granule := int64(binary.LittleEndian.Uint64(tail[i+6:]))
+		if granule > preSkip {
+			return secondsToDuration(float64(granule-preSkip) / sampleRate), nil
+		}
+	}
+	return 0, errors.New("no Ogg page with a granule position found")
+}
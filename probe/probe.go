@@ -0,0 +1,140 @@
+// Package probe works out the duration of an episode's media file, for feeds that don't tell us.
+// Rather than downloading the whole file, it fetches a few kilobytes from the start and the end
+// with HTTP Range requests, and parses the MP3, MP4 or Ogg headers it finds there.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/podcreep/server/fetch"
+)
+
+const (
+	// headSize is how much we fetch from the start of the file (and from anywhere else we need to
+	// look in the middle of it).
+	headSize = 64 * 1024
+
+	// tailSize is how much we fetch from the end of the file.
+	tailSize = 64 * 1024
+)
+
+var (
+	// ErrUnknownFormat is returned when the file isn't one of the formats we know how to probe.
+	ErrUnknownFormat = errors.New("unknown media format")
+
+	// ErrRangeNotSupported is returned when we need part of the file other than the start, but the
+	// server doesn't support Range requests.
+	ErrRangeNotSupported = errors.New("server does not support range requests")
+)
+
+// Duration works out the duration of the media file at the given URL. size is the size of the file
+// in bytes if we already know it (e.g. from the feed's <enclosure>), or zero if not.
+func Duration(ctx context.Context, url string, size int64) (time.Duration, error) {
+	src := &source{ctx: ctx, url: url, size: size}
+	head, err := src.readAt(0, headSize)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case len(head) >= 4 && string(head[:4]) == "OggS":
+		return oggDuration(src, head)
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return mp4Duration(src, head)
+	case len(head) >= 3 && string(head[:3]) == "ID3":
+		return mp3Duration(src, head)
+	case findFrame(head) >= 0:
+		return mp3Duration(src, head)
+	}
+	return 0, ErrUnknownFormat
+}
+
+// source is a remote file that we read parts of with Range requests.
+type source struct {
+	ctx context.Context
+	url string
+
+	// size is the total size of the file, or zero if we don't know it yet. It's filled in from the
+	// responses to our requests.
+	size int64
+}
+
+// readAt reads up to n bytes of the file, starting at the given offset. It returns fewer bytes if
+// the file ends first.
+func (s *source) readAt(offset int64, n int) ([]byte, error) {
+	return s.read(fmt.Sprintf("bytes=%d-%d", offset, offset+int64(n)-1), offset, n)
+}
+
+// readTail reads the last n bytes of the file (or the whole file, if it's smaller than that).
+func (s *source) readTail(n int) ([]byte, error) {
+	return s.read(fmt.Sprintf("bytes=-%d", n), -1, n)
+}
+
+func (s *source) read(byteRange string, offset int64, n int) ([]byte, error) {
+	req, err := http.NewRequestWithContext(s.ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Range", byteRange)
+	// A compressed range of the file would be no use to us.
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := fetch.Default.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", s.url, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if total := contentRangeTotal(resp.Header.Get("Content-Range")); total > 0 {
+			s.size = total
+		}
+	case http.StatusOK:
+		// The server ignored the Range header and is sending us the whole file. That's fine if we
+		// wanted the start anyway, we'll just stop reading once we have enough.
+		if resp.ContentLength > 0 {
+			s.size = resp.ContentLength
+		}
+		if offset != 0 {
+			return nil, ErrRangeNotSupported
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// We asked for something past the end of the file.
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("error fetching %s: status=%d", s.url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(n)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", s.url, err)
+	}
+	return data, nil
+}
+
+// contentRangeTotal returns the total size from the given Content-Range header (e.g.
+// "bytes 0-1023/146515"), or zero if it doesn't say.
+func contentRangeTotal(header string) int64 {
+	index := strings.LastIndex(header, "/")
+	if index < 0 {
+		return 0
+	}
+	total, err := strconv.ParseInt(header[index+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return total
+}
+
+// secondsToDuration converts a (possibly fractional) number of seconds to a time.Duration.
+func secondsToDuration(secs float64) time.Duration {
+	return time.Duration(secs * float64(time.Second))
+}
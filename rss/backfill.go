@@ -0,0 +1,116 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/podcreep/server/fetch"
+	"github.com/podcreep/server/store"
+)
+
+// maxBackfillPages is the most pages of a feed we'll walk in one go, in case a feed links to pages
+// forever.
+const maxBackfillPages = 500
+
+// nextPageURL returns the URL of the page of older episodes, given the links of a page of a paged
+// or archived feed (RFC 5005). Paged feeds link to the next page with rel="next", archived feeds
+// link to the previous archive with rel="prev-archive". The URL is resolved relative to the URL of
+// the page. Returns an empty string if there are no more pages.
+func nextPageURL(links []AtomLink, pageURL string) string {
+	href := findLink(links, "next")
+	if href == "" {
+		href = findLink(links, "prev-archive")
+	}
+	if href == "" {
+		return ""
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		log.Printf(" - invalid page link: %s", href)
+		return ""
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// hasOlderPages returns true if the given links are those of a page of a paged or archived feed
+// that links to older pages.
+func hasOlderPages(links []AtomLink) bool {
+	return findLink(links, "next") != "" || findLink(links, "prev-archive") != ""
+}
+
+// backfill walks the older pages of a paged or archived feed, starting from the links in the given
+// state (which is the state of the main feed). We stop once we get to a page where we already knew
+// about every episode (i.e. they're in known), or when we run out of pages. The GUIDs of every
+// episode we see are added to the state.
+//
+// Returns the number of episodes updated, and true if we walked every page of the feed.
+func backfill(ctx context.Context, db Store, p *store.Podcast, flags UpdatePodcastFlags, state *updateState, known map[string]bool) (int, bool, error) {
+	numUpdated := 0
+	visited := map[string]bool{p.FeedURL: true}
+	pageURL := nextPageURL(state.links, p.FeedURL)
+	for numPages := 0; pageURL != ""; numPages++ {
+		if visited[pageURL] {
+			// The pages link back around to one we've already seen, so we have seen them all.
+			log.Printf(" - page %s already visited, stopping", pageURL)
+			break
+		}
+		if numPages >= maxBackfillPages {
+			log.Printf(" - walked %d pages, stopping", numPages)
+			return numUpdated, false, nil
+		}
+		visited[pageURL] = true
+
+		log.Printf(" - backfilling from: %s", pageURL)
+		pageState := &updateState{fetch: state.fetch, isArchivePage: true, isPartial: true}
+		n, err := decodeFeedPage(ctx, db, pageURL, p, flags, pageState)
+		numUpdated += n
+		state.seenGUIDs = append(state.seenGUIDs, pageState.seenGUIDs...)
+		if err != nil {
+			return numUpdated, false, err
+		}
+
+		allKnown := true
+		for _, guid := range pageState.seenGUIDs {
+			if !known[guid] {
+				allKnown = false
+				break
+			}
+		}
+		if allKnown {
+			log.Printf(" - already have every episode on this page, stopping")
+			return numUpdated, false, nil
+		}
+
+		pageURL = nextPageURL(pageState.links, pageURL)
+	}
+
+	return numUpdated, true, nil
+}
+
+// decodeFeedPage fetches and decodes a single page of a paged feed.
+func decodeFeedPage(ctx context.Context, db Store, pageURL string, p *store.Podcast, flags UpdatePodcastFlags, state *updateState) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %w", err)
+	}
+
+	resp, err := fetch.Default.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching URL: %s: %w", pageURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("error fetching URL: %s status=%d", pageURL, resp.StatusCode)
+	}
+
+	return decodeFeed(ctx, db, countingReader{resp.Body, &state.fetch.Bytes}, resp.Header.Get("Content-Type"), p, flags, state)
+}
@@ -0,0 +1,323 @@
+package rss
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/podcreep/server/artwork"
+	"github.com/podcreep/server/fetch"
+	"github.com/podcreep/server/store"
+	"github.com/podcreep/server/util"
+)
+
+const (
+	// maxChapterImageSize is the biggest chapter image we'll download. Anything bigger is ignored.
+	maxChapterImageSize = 5 * 1024 * 1024
+)
+
+// PSCChapter is a single <psc:chapter> of Podlove Simple Chapters.
+type PSCChapter struct {
+	Start string `xml:"start,attr"`
+	Title string `xml:"title,attr"`
+	Href  string `xml:"href,attr"`
+	Image string `xml:"image,attr"`
+}
+
+// PSCChapters is a <psc:chapters> element, which embeds the chapters directly in the feed.
+type PSCChapters struct {
+	Chapters []PSCChapter `xml:"http://podlove.org/simple-chapters chapter"`
+}
+
+// jsonChapters is the format of a Podcasting 2.0 JSON chapters file.
+type jsonChapters struct {
+	Version  string `json:"version"`
+	Chapters []struct {
+		StartTime float64  `json:"startTime"`
+		EndTime   *float64 `json:"endTime"`
+		Title     string   `json:"title"`
+		Img       string   `json:"img"`
+		URL       string   `json:"url"`
+		TOC       *bool    `json:"toc"`
+	} `json:"chapters"`
+}
+
+// parseNormalPlayTime parses a time in the "normal play time" format that Podlove uses, for
+// example "01:02:03.500", "02:03" or "123.5". The result is in seconds.
+func parseNormalPlayTime(str string) (float64, error) {
+	str = strings.TrimSpace(str)
+	parts := strings.Split(str, ":")
+	if str == "" || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time: %s", str)
+	}
+
+	var secs float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid time: %s", str)
+		}
+		secs = secs*60 + n
+	}
+	return secs, nil
+}
+
+// parseJSONChapters parses a Podcasting 2.0 JSON chapters file.
+func parseJSONChapters(r io.Reader) ([]*store.Chapter, error) {
+	var doc jsonChapters
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("error parsing chapters: %w", err)
+	}
+
+	var chapters []*store.Chapter
+	for _, c := range doc.Chapters {
+		chapter := &store.Chapter{
+			StartSecs: c.StartTime,
+			EndSecs:   c.EndTime,
+			Title:     strings.TrimSpace(c.Title),
+			URL:       c.URL,
+			ImageURL:  c.Img,
+			TOC:       c.TOC == nil || *c.TOC,
+		}
+		chapters = append(chapters, chapter)
+	}
+	return chapters, nil
+}
+
+// parsePSCChapters converts embedded Podlove Simple Chapters to our chapters.
+func parsePSCChapters(psc PSCChapters) []*store.Chapter {
+	var chapters []*store.Chapter
+	for _, c := range psc.Chapters {
+		start, err := parseNormalPlayTime(c.Start)
+		if err != nil {
+			log.Printf(" - ignoring chapter '%s': %v", c.Title, err)
+			continue
+		}
+		chapters = append(chapters, &store.Chapter{
+			StartSecs: start,
+			Title:     strings.TrimSpace(c.Title),
+			URL:       c.Href,
+			ImageURL:  c.Image,
+			TOC:       true,
+		})
+	}
+	return chapters
+}
+
+// normalizeChapters sorts the chapters by start time, drops ones that don't make sense and fills
+// in the end time of each chapter from the start of the next one (the last one ends at the end of
+// the episode, if we know how long it is).
+func normalizeChapters(chapters []*store.Chapter, durationSecs *int32) []*store.Chapter {
+	var result []*store.Chapter
+	for _, c := range chapters {
+		if c.StartSecs < 0 || (c.EndSecs != nil && *c.EndSecs < c.StartSecs) {
+			continue
+		}
+		result = append(result, c)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].StartSecs < result[j].StartSecs
+	})
+
+	for i, c := range result {
+		if c.EndSecs != nil {
+			continue
+		}
+		if i+1 < len(result) {
+			end := result[i+1].StartSecs
+			c.EndSecs = &end
+		} else if durationSecs != nil && float64(*durationSecs) > c.StartSecs {
+			end := float64(*durationSecs)
+			c.EndSecs = &end
+		}
+	}
+	return result
+}
+
+// chaptersFile is a chapters file we've fetched, along with what we need to tell if it has changed.
+type chaptersFile struct {
+	chapters []*store.Chapter
+	etag     string
+	hash     string
+}
+
+// fetchJSONChapters fetches and parses the chapters file at the given URL. If etag isn't empty, we
+// only fetch the file if it no longer matches, and return nil if it does.
+func fetchJSONChapters(ctx context.Context, url, etag string) (*chaptersFile, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	req.Header["User-Agent"] = []string{util.GetUserAgent()}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := fetch.Default.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 304 {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error fetching %s: status=%d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	chapters, err := parseJSONChapters(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(data)
+	return &chaptersFile{
+		chapters: chapters,
+		etag:     resp.Header.Get("ETag"),
+		hash:     hex.EncodeToString(hash[:]),
+	}, nil
+}
+
+// saveChapterImage downloads the given chapter image into the blob store, and returns the URL we
+// serve it from. The image is re-encoded as a PNG, so that we only ever serve real images from our
+// origin, whatever the publisher gave us. The files are named after the SHA256 of their contents,
+// so chapters that share an image (which is very common) only store it once.
+func saveChapterImage(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	req.Header["User-Agent"] = []string{util.GetUserAgent()}
+
+	resp, err := fetch.Default.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("error fetching %s: status=%d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxChapterImageSize+1))
+	if err != nil {
+		return "", fmt.Errorf("error reading image: %w", err)
+	}
+	if len(data) > maxChapterImageSize {
+		return "", fmt.Errorf("image is too big: %s", url)
+	}
+	data, err = artwork.EncodePNG(data)
+	if err != nil {
+		return "", fmt.Errorf("error decoding image %s: %w", url, err)
+	}
+
+	hash := sha256.Sum256(data)
+	sha := hex.EncodeToString(hash[:])
+
+	basePath, err := store.GetBlobStorePath("chapters")
+	if err != nil {
+		return "", err
+	}
+	imagePath := path.Join(basePath, sha)
+	if _, err := os.Stat(imagePath); os.IsNotExist(err) {
+		if err := os.WriteFile(imagePath, data, 0644); err != nil {
+			return "", fmt.Errorf("error saving chapter image %s: %w", imagePath, err)
+		}
+	}
+
+	return "/blobs/chapters/" + sha, nil
+}
+
+// chaptersSource returns a string that identifies where the chapters for the given item come
+// from. For a <podcast:chapters> file, it's the URL of the file. For embedded chapters, it's a
+// hash of the chapters themselves. If this doesn't change, we don't need to re-process the chapters.
+func chaptersSource(item Item) string {
+	if item.PodcastItem.Chapters.URL != "" {
+		return item.PodcastItem.Chapters.URL
+	}
+	if len(item.PSCChapters.Chapters) > 0 {
+		data, _ := json.Marshal(item.PSCChapters)
+		hash := sha256.Sum256(data)
+		return "psc:" + hex.EncodeToString(hash[:])
+	}
+	return ""
+}
+
+// updateChapters fetches (or parses) the chapters for the given item, if they have changed since
+// we last saw them, and saves them to the given episode. Publishers often correct a chapters file
+// without changing its URL, so we check the file again each time, with If-None-Match if the server
+// gave us an ETag.
+func updateChapters(ctx context.Context, db store.EpisodeStore, item Item, ep *store.Episode) error {
+	url := item.PodcastItem.Chapters.URL
+	source := &store.ChaptersSource{Source: chaptersSource(item)}
+	existing, err := db.LoadChaptersSource(ctx, ep.ID)
+	if err != nil {
+		return err
+	}
+	sameSource := source.Source == existing.Source
+	if sameSource && url == "" {
+		// Embedded chapters are identified by their hash, so they haven't changed.
+		return nil
+	}
+
+	var chapters []*store.Chapter
+	if url != "" {
+		var etag string
+		if sameSource {
+			etag = existing.ETag
+		}
+		log.Printf(" - fetching chapters: %s", url)
+		file, err := fetchJSONChapters(ctx, url, etag)
+		if err == nil && (file == nil || (sameSource && file.hash == existing.Hash)) {
+			// Not modified, nothing to do.
+			return nil
+		} else if err == nil {
+			chapters = file.chapters
+			source.ETag = file.etag
+			source.Hash = file.hash
+		} else if sameSource || len(item.PSCChapters.Chapters) == 0 {
+			// If we already have chapters from this file, we'll keep them until we can fetch it again.
+			return err
+		} else {
+			// We can fall back to the embedded chapters.
+			log.Printf(" - error fetching chapters, using embedded chapters: %v", err)
+			chapters = parsePSCChapters(item.PSCChapters)
+		}
+	} else {
+		chapters = parsePSCChapters(item.PSCChapters)
+	}
+	chapters = normalizeChapters(chapters, ep.DurationSecs)
+
+	for _, c := range chapters {
+		if c.ImageURL == "" {
+			continue
+		}
+		imageURL, err := saveChapterImage(ctx, c.ImageURL)
+		if err != nil {
+			// Just go without the image, it's not worth failing the whole thing.
+			log.Printf(" - error saving chapter image: %v", err)
+			c.ImageURL = ""
+			continue
+		}
+		c.ImageURL = imageURL
+	}
+
+	return db.SaveChapters(ctx, ep.ID, source, chapters)
+}
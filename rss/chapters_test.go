@@ -0,0 +1,56 @@
+package rss
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/podcreep/server/fetch"
+	"github.com/podcreep/server/store"
+)
+
+func TestUpdateChaptersRefetch(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	old := fetch.Default
+	fetch.Default = fetch.NewFake(dir)
+	t.Cleanup(func() { fetch.Default = old })
+
+	filename := filepath.Join(dir, "example.com", "chapters.json")
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeChapters := func(title string) {
+		data := `{"version": "1.2.0", "chapters": [{"startTime": 0, "title": "` + title + `"}]}`
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := store.NewMemoryBackend()
+	p := newPodcast(t, db, "https://example.com/feed.xml")
+	ep := &store.Episode{GUID: "ep-1", Title: "Episode 1", PubDate: time.Now()}
+	if err := db.SaveEpisode(ctx, p, ep, nil); err != nil {
+		t.Fatal(err)
+	}
+	var item Item
+	item.PodcastItem.Chapters.URL = "https://example.com/chapters.json"
+
+	// The publisher corrects the chapters file without changing its URL, and we should pick up the
+	// new version.
+	for _, title := range []string{"Intro", "Introduction"} {
+		writeChapters(title)
+		if err := updateChapters(ctx, db, item, ep); err != nil {
+			t.Fatal(err)
+		}
+		chapters, err := db.LoadChapters(ctx, ep.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chapters) != 1 || chapters[0].Title != title {
+			t.Errorf("got chapters %+v, want one titled %q", chapters, title)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package rss
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// newDecoder returns an xml.Decoder for the given feed which transcodes it to UTF-8 if needed.
+// contentType is the Content-Type header the feed was served with, which may be empty. If it names
+// a charset, that takes precedence over the encoding in the XML declaration (as per RFC 7303).
+// Otherwise, we use whatever the XML declaration says.
+func newDecoder(r io.Reader, contentType string) *xml.Decoder {
+	if label := contentTypeCharset(contentType); label != "" {
+		enc, err := htmlindex.Get(label)
+		if err != nil {
+			log.Printf(" - unknown charset in Content-Type '%s', ignoring", label)
+		} else {
+			decoder := xml.NewDecoder(enc.NewDecoder().Reader(r))
+			// The decoder only ever sees UTF-8, so ignore whatever the XML declaration says.
+			decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+				return input, nil
+			}
+			return decoder
+		}
+	}
+
+	decoder := xml.NewDecoder(r)
+	decoder.CharsetReader = charsetReader
+	return decoder
+}
+
+// contentTypeCharset returns the charset parameter of the given Content-Type header, or an empty
+// string if it doesn't have one.
+func contentTypeCharset(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(params["charset"])
+}
+
+// charsetReader is used as the xml.Decoder's CharsetReader, and transcodes the input from the
+// charset in the XML declaration to UTF-8.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(label)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset '%s': %w", label, err)
+	}
+	return enc.NewDecoder().Reader(input), nil
+}
@@ -0,0 +1,54 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/podcreep/server/store"
+)
+
+// PermanentRedirectURL returns the final URL of the given response if we got there by following
+// only permanent (301 or 308) redirects. If there were no redirects, or any of them were temporary,
+// an empty string is returned.
+func PermanentRedirectURL(resp *http.Response) string {
+	if resp.Request == nil || resp.Request.Response == nil {
+		// No redirects at all.
+		return ""
+	}
+
+	// Each request made because of a redirect has the redirect response attached to it, so we can
+	// walk back along the chain.
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		status := req.Response.StatusCode
+		if status != http.StatusMovedPermanently && status != http.StatusPermanentRedirect {
+			return ""
+		}
+	}
+	return resp.Request.URL.String()
+}
+
+// IsValidFeedURL returns true if the given URL looks like something we could fetch a feed from.
+func IsValidFeedURL(str string) bool {
+	u, err := url.Parse(str)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// maybeMoveFeed updates the feed URL of the given podcast, if it's different from newURL. The
+// reason is recorded in the podcast's feed URL history.
+func maybeMoveFeed(ctx context.Context, db store.PodcastStore, p *store.Podcast, newURL, reason string) error {
+	if newURL == "" || newURL == p.FeedURL {
+		return nil
+	}
+	if !IsValidFeedURL(newURL) {
+		return fmt.Errorf("invalid new feed URL: %s", newURL)
+	}
+
+	log.Printf(" - feed has moved (%s): %s -> %s", reason, p.FeedURL, newURL)
+	return db.UpdatePodcastFeedURL(ctx, p, newURL, reason)
+}
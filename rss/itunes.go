@@ -0,0 +1,79 @@
+package rss
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// itunesNamespace is the XML namespace of the <itunes:*> elements.
+	itunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+)
+
+// parseDuration parses the value of an <itunes:duration> element. The spec says it should be a
+// number of seconds, but in practice we see "HH:MM:SS", "MM:SS" and fractional seconds as well.
+func parseDuration(str string) (int32, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return 0, fmt.Errorf("duration is empty")
+	}
+
+	parts := strings.Split(str, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("too many parts in duration: %s", str)
+	}
+
+	var secs float64
+	for _, part := range parts {
+		n, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration: %s", str)
+		}
+		secs = secs*60 + n
+	}
+	return int32(secs + 0.5), nil
+}
+
+// parseExplicit parses the value of an <itunes:explicit> element. Apple used to want "yes", "no"
+// and "clean" but now wants "true" and "false", so we accept all of them.
+func parseExplicit(str string) bool {
+	switch strings.ToLower(strings.TrimSpace(str)) {
+	case "yes", "true", "explicit":
+		return true
+	default:
+		return false
+	}
+}
+
+// parseEpisodeType parses the value of an <itunes:episodeType> element. Anything we don't
+// recognize is assumed to be a full episode, which is also the default if there's no element.
+func parseEpisodeType(str string) string {
+	switch t := strings.ToLower(strings.TrimSpace(str)); t {
+	case "trailer", "bonus":
+		return t
+	default:
+		return "full"
+	}
+}
+
+// parseOptionalInt parses the given string as an integer, returning nil if it's empty or not a
+// valid number.
+func parseOptionalInt(str string) *int32 {
+	n, err := strconv.ParseInt(strings.TrimSpace(str), 10, 32)
+	if err != nil {
+		return nil
+	}
+	val := int32(n)
+	return &val
+}
+
+// parseLength parses the length (in bytes) of an enclosure. Returns nil if it's missing or invalid.
+// Some feeds use 0 to mean "unknown", so we treat that as missing as well.
+func parseLength(str string) *int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+	if err != nil || n <= 0 {
+		return nil
+	}
+	return &n
+}
@@ -1,21 +1,23 @@
 package rss
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"html"
 	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
-	"os"
-	"path"
 	"strings"
 	"time"
 
+	"github.com/podcreep/server/artwork"
+	"github.com/podcreep/server/fetch"
 	"github.com/podcreep/server/store"
 	"github.com/podcreep/server/util"
 
@@ -25,16 +27,27 @@ import (
 var (
 	// An empty policy will strip all HTML tags, which is what we actually want.
 	htmlPolicy = bluemonday.NewPolicy()
-
-	// http.Client we'll use to make HTTP requests.
-	httpClient = &http.Client{}
 )
 
+// maxErrorBodySize is how much of the body of an error response we keep in the podcast's fetch
+// history.
+const maxErrorBodySize = 4 << 10
+
+// Store is the part of the store.Backend that we need to update podcasts.
+type Store interface {
+	store.PodcastStore
+	store.EpisodeStore
+}
+
 type UpdatePodcastFlags int
 
 const (
 	ForceUpdate UpdatePodcastFlags = 1 << iota
 	IconOnly
+
+	// Backfill walks the older pages of a paged or archived feed (RFC 5005), to load the podcast's
+	// whole back catalogue.
+	Backfill
 )
 
 // maybeAddIfModifiedSince will add an If-Modified-Since header to the given request, based on the
@@ -45,28 +58,124 @@ func maybeAddIfModifiedSince(req *http.Request, p *store.Podcast) {
 	}
 }
 
-func updateEpisode(ctx context.Context, item Item, p *store.Podcast) error {
+// addConditionalHeaders adds If-None-Match and If-Modified-Since headers to the given feed request,
+// based on the ETag and Last-Modified headers the server gave us last time. If the server didn't
+// give us either, we fall back to the last fetch time of the podcast.
+func addConditionalHeaders(req *http.Request, p *store.Podcast) {
+	if p.ETag == "" && p.LastModified == "" {
+		maybeAddIfModifiedSince(req, p)
+		return
+	}
+
+	if p.ETag != "" {
+		req.Header.Set("If-None-Match", p.ETag)
+	}
+	if p.LastModified != "" {
+		req.Header.Set("If-Modified-Since", p.LastModified)
+	}
+}
+
+// saveValidators remembers the ETag and Last-Modified headers of the given response, so that we
+// can send them back next time.
+func saveValidators(resp *http.Response, p *store.Podcast) {
+	if etag := resp.Header.Get("ETag"); etag != "" || resp.StatusCode == 200 {
+		p.ETag = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" || resp.StatusCode == 200 {
+		p.LastModified = lastModified
+	}
+}
+
+// episodeIdentity returns the value we use to identify the given item. Normally that's the GUID,
+// but plenty of feeds leave it out, in which case we fall back to the enclosure URL, and failing
+// that, a hash of the title and publish date.
+func episodeIdentity(item Item) string {
+	if guid := strings.TrimSpace(item.GUID); guid != "" {
+		return guid
+	}
+	if item.Media.URL != "" {
+		return item.Media.URL
+	}
+	sha := sha256.Sum256([]byte(item.Title + "\n" + item.PubDate))
+	return "sha256:" + hex.EncodeToString(sha[:])
+}
+
+// updateEpisodes saves all of the episodes we've decoded into the given state. Returns the number of
+// episodes saved.
+func updateEpisodes(ctx context.Context, db Store, p *store.Podcast, state *updateState) int {
+	// The main document of a paged feed only has the latest episodes, so it's partial as well. Any
+	// episodes that were renamed are merged by ReconcileEpisodes, once a forced backfill has seen
+	// every page.
+	if hasOlderPages(state.links) {
+		state.isPartial = true
+	}
+
+	var currentGUIDs []string
+	if !state.isPartial {
+		currentGUIDs = state.seenGUIDs
+	}
+
+	numUpdated := 0
+	for _, item := range state.items {
+		if err := updateEpisode(ctx, db, item, p, currentGUIDs); err != nil {
+			// Error updating this item, but keep going.
+			log.Printf("error updating episode '%s' [guid:%s]: %v", item.Title, item.GUID, err)
+			continue
+		}
+		numUpdated++
+	}
+	state.items = nil
+	return numUpdated
+}
+
+// savePartial saves the episodes we managed to decode before the given error happened, and returns
+// the error. We can't have seen every GUID in the feed, so none of the episodes are renamed.
+func savePartial(ctx context.Context, db Store, p *store.Podcast, state *updateState, err error) (int, error) {
+	state.isPartial = true
+	return updateEpisodes(ctx, db, p, state), err
+}
+
+func updateEpisode(ctx context.Context, db Store, item Item, p *store.Podcast, currentGUIDs []string) error {
 	pubDate, err := parsePubDate(item.PubDate)
 	if err != nil {
 		return fmt.Errorf("error parsing date: %v", err)
 	}
 
 	var ep = store.Episode{
-		GUID:             item.GUID,
+		GUID:             episodeIdentity(item),
 		MediaURL:         item.Media.URL,
+		MediaLength:      parseLength(item.Media.Length),
+		MediaType:        strings.TrimSpace(item.Media.Type),
 		Title:            item.Title,
 		Description:      item.Description,
 		DescriptionHTML:  false,
 		ShortDescription: item.Description,
 		PubDate:          pubDate,
+		Season:           parseOptionalInt(item.ITunesItem.Season),
+		EpisodeNumber:    parseOptionalInt(item.ITunesItem.Episode),
+		EpisodeType:      parseEpisodeType(item.ITunesItem.EpisodeType),
+		Explicit:         parseExplicit(item.ITunesItem.Explicit),
+	}
+
+	if item.ITunesItem.Duration != "" {
+		duration, err := parseDuration(item.ITunesItem.Duration)
+		if err != nil {
+			// Not a big deal, we'll just not have a duration for this one.
+			log.Printf(" - error parsing duration: %v", err)
+		} else {
+			ep.DurationSecs = &duration
+		}
+	}
+	if item.ITunesItem.Image.Href != "" {
+		ep.ImageURL = &item.ITunesItem.Image.Href
 	}
+	populateEpisodeMetadata(item.PodcastItem, &ep)
 
 	if item.EncodedDescription != "" {
 		ep.Description = item.EncodedDescription
 		ep.DescriptionHTML = true
-	} else {
-		ep.Description = htmlPolicy.Sanitize(ep.Description)
 	}
+	sanitizeDescription(&ep)
 	ep.ShortDescription = htmlPolicy.Sanitize(ep.ShortDescription)
 	ep.ShortDescription = html.UnescapeString(ep.ShortDescription)
 	if len(ep.ShortDescription) > 80 {
@@ -75,39 +184,37 @@ func updateEpisode(ctx context.Context, item Item, p *store.Podcast) error {
 	}
 
 	log.Printf(" - episode [%v] [%s] '%s', updating", ep.GUID, ep.PubDate, ep.Title)
-	if err := store.SaveEpisode(ctx, p, &ep); err != nil {
+	if err := db.SaveEpisode(ctx, p, &ep, currentGUIDs); err != nil {
 		return fmt.Errorf("error saving episode: %v", err)
 	}
 
-	return nil
-}
-
-func calculateSha1(filepath string) (string, error) {
-	f, err := os.Open(filepath)
-	if err != nil {
-		return "", err
+	if err := updateChapters(ctx, db, item, &ep); err != nil {
+		// Not a big deal, the episode is still usable without chapters.
+		log.Printf(" - error updating chapters: %v", err)
 	}
-	defer f.Close()
-
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, f); err != nil {
-		return "", err
+	if err := updateTranscript(ctx, db, &ep); err != nil {
+		log.Printf(" - error updating transcript: %v", err)
 	}
 
-	return hex.EncodeToString(hasher.Sum(nil)), nil
+	return nil
 }
 
+// updateChannelImage downloads the podcast's icon from the given URL, and if it has changed, saves
+// it (and its thumbnails) to the blob store. If the icon can't be downloaded or isn't a valid
+// image, we just keep the one we had.
 func updateChannelImage(ctx context.Context, url string, p *store.Podcast) error {
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("error fetching %s: %w", url, err)
 	}
-	if p.ImagePath != nil {
+	if p.ImagePath != nil && artwork.IsCanonicalPath(*p.ImagePath) && p.Palette != nil {
+		// Icons we saved before we started processing them (or extracting their palette) need to be
+		// downloaded again.
 		maybeAddIfModifiedSince(req, p)
 	}
 	req.Header["User-Agent"] = []string{util.GetUserAgent()}
 
-	resp, err := httpClient.Do(req)
+	resp, err := fetch.Default.Do(req)
 	if err != nil {
 		log.Printf("Error fetching image URL: %s %v", url, err)
 		// We don't consider this a bad enough error to stop fetching the rest of the podcast URL.
@@ -117,58 +224,117 @@ func updateChannelImage(ctx context.Context, url string, p *store.Podcast) error
 
 	if resp.StatusCode == 304 {
 		log.Printf("Image hasn't been updated, no need to fetch again.")
-	} else {
-		file, err := os.CreateTemp("", "icon")
-		if err != nil {
-			return fmt.Errorf("error creating a temporary file: %w", err)
-		}
-		defer os.Remove(file.Name())
+		return nil
+	}
+	if resp.StatusCode != 200 {
+		log.Printf("Error fetching image URL: %s status=%d", url, resp.StatusCode)
+		return nil
+	}
 
-		_, err = io.Copy(file, resp.Body)
-		if err != nil {
-			return fmt.Errorf("error saving image: %w", err)
-		}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, artwork.MaxSourceSize+1))
+	if err != nil {
+		log.Printf("Error fetching image URL: %s %v", url, err)
+		return nil
+	}
+	if len(data) > artwork.MaxSourceSize {
+		log.Printf("Image is too large: %s", url)
+		return nil
+	}
+
+	newHash := artwork.Hash(data)
+	log.Printf("New image hash: %s", newHash)
+	if p.ImagePath != nil && artwork.HashFromPath(*p.ImagePath) == newHash && p.Palette != nil {
+		return nil
+	}
 
-		newSha1, _ := calculateSha1(file.Name())
-		log.Printf("New image SHA1: %s", newSha1)
+	icon, err := artwork.Save(data)
+	if errors.Is(err, artwork.ErrInvalidImage) {
+		log.Printf("Ignoring invalid image %s: %v", url, err)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error saving image: %w", err)
+	}
 
-		oldSha1 := ""
-		if p.ImagePath != nil {
-			oldSha1, err = calculateSha1(*p.ImagePath)
-			if err != nil {
-				return fmt.Errorf("error calculating SHA1: %w", err)
-			}
-		}
+	p.ImagePath = &icon.Path
+	p.Palette = icon.Palette
+	p.ImageURL = fmt.Sprintf("/blobs/podcasts/%d/icon/%s.png", p.ID, newHash)
+	p.IsImageExternal = false
+	return nil
+}
 
-		if oldSha1 != newSha1 {
-			log.Printf("SHA mismatch: %s != %s", newSha1, oldSha1)
-			basePath, err := store.GetBlobStorePath("icons")
-			if err != nil {
-				return err
-			}
+// updateState holds the things we learn about a feed while we're decoding it, that we need to act
+// on once we're done.
+type updateState struct {
+	// newFeedURL is the value of the feed's <itunes:new-feed-url>, if it has one.
+	newFeedURL string
 
-			iconPath := path.Join(basePath, newSha1+".png")
-			iconFile, err := os.Create(iconPath)
-			if err != nil {
-				return fmt.Errorf("error opening icon file %s: %w", iconPath, err)
-			}
-			file.Seek(0, 0)
-			_, err = io.Copy(iconFile, file)
-			if err != nil {
-				return err
-			}
+	// fetch is the record of this fetch, which we fill in as we go.
+	fetch *store.FeedFetch
+
+	// seenGUIDs is the identity of every episode we've seen in the feed.
+	seenGUIDs []string
+
+	// items is the episodes we've seen in the document we're decoding. We only save them once we've
+	// seen all of them, so that we know which GUIDs are still in the feed.
+	items []Item
 
-			p.ImagePath = &iconPath
-			p.ImageURL = fmt.Sprintf("/blobs/podcasts/%d/icon/%s.png", p.ID, newSha1)
-			p.IsImageExternal = false
+	// isPartial is true if the document might not have every episode of the feed in it, e.g. one
+	// that was pushed to us by a WebSub hub, or one page of a paged feed. Then we can't tell whether
+	// an episode's GUID has been changed, or the episode just isn't in the document.
+	isPartial bool
+
+	// links is all of the feed's <atom:link> elements.
+	links []AtomLink
+
+	// isArchivePage is true if we're decoding one of the older pages of a paged feed. In that case
+	// we only care about the episodes, the podcast's details come from the main feed.
+	isArchivePage bool
+}
+
+// countingReader is an io.Reader that counts the number of bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	*cr.n += int64(n)
+	return n, err
+}
+
+// decodeCommonElement decodes the channel-level elements that are the same for both RSS and Atom
+// feeds. Returns false if the element is not one of those (in which case nothing is consumed).
+func decodeCommonElement(se xml.StartElement, decoder *xml.Decoder, p *store.Podcast, state *updateState) (bool, error) {
+	if se.Name.Space == itunesNamespace && se.Name.Local == "new-feed-url" {
+		var newFeedURL string
+		if err := decoder.DecodeElement(&newFeedURL, &se); err != nil {
+			return true, fmt.Errorf("error parsing new-feed-url: %w", err)
 		}
+		state.newFeedURL = strings.TrimSpace(newFeedURL)
+		return true, nil
 	}
 
-	return nil
+	if se.Name.Space == atomNamespace && se.Name.Local == "link" {
+		var link AtomLink
+		if err := decoder.DecodeElement(&link, &se); err != nil {
+			return true, fmt.Errorf("error parsing link: %w", err)
+		}
+		state.links = append(state.links, link)
+		return true, nil
+	}
+
+	if state.isArchivePage {
+		return false, nil
+	}
+	return decodePodcastElement(se, decoder, p)
 }
 
-func decodeChannelElement(ctx context.Context, se xml.StartElement, decoder *xml.Decoder, p *store.Podcast, flags UpdatePodcastFlags) (int, error) {
-	numUpdated := 0
+func decodeChannelElement(ctx context.Context, db Store, se xml.StartElement, decoder *xml.Decoder, p *store.Podcast, flags UpdatePodcastFlags, state *updateState) (int, error) {
+	if !state.isArchivePage {
+		p.Persons = nil
+		p.Funding = nil
+	}
 	for {
 		token, err := decoder.Token()
 		if err != nil {
@@ -176,33 +342,34 @@ func decodeChannelElement(ctx context.Context, se xml.StartElement, decoder *xml
 				// that's fine, we're at the end of the stream.
 				break
 			} else {
-				// TODO: not just end of stream but maybe some other error?
-				log.Printf("Error in top-level decoding: %v", err)
-				break
+				return savePartial(ctx, db, p, state, fmt.Errorf("error decoding feed: %w", err))
 			}
 		}
 
 		var item Item
 		switch se := token.(type) {
 		case xml.StartElement:
+			if handled, err := decodeCommonElement(se, decoder, p, state); handled {
+				if err != nil {
+					return savePartial(ctx, db, p, state, err)
+				}
+				continue
+			}
+
 			if se.Name.Local == "item" {
 				err := decoder.DecodeElement(&item, &se)
 				if err != nil {
-					return 0, fmt.Errorf("error parsing item: %w", err)
+					return savePartial(ctx, db, p, state, fmt.Errorf("error parsing item: %w", err))
 				}
-
+				state.fetch.NumParsed++
+				state.seenGUIDs = append(state.seenGUIDs, episodeIdentity(item))
 				if (flags & IconOnly) == 0 {
-					if err := updateEpisode(ctx, item, p); err != nil {
-						// Error updating this item, but keep going.
-						log.Printf("error updating episode '%s' [guid:%s]: %v", item.Title, item.GUID, err)
-						continue
-					}
-					numUpdated++
+					state.items = append(state.items, item)
 				}
-			} else if se.Name.Local == "image" {
+			} else if se.Name.Local == "image" && !state.isArchivePage {
 				var image Image
 				if err := decoder.DecodeElement(&image, &se); err != nil {
-					return 0, fmt.Errorf("error parsing image: %w", err)
+					return savePartial(ctx, db, p, state, fmt.Errorf("error parsing image: %w", err))
 				}
 
 				url := image.URL
@@ -213,61 +380,283 @@ func decodeChannelElement(ctx context.Context, se xml.StartElement, decoder *xml
 				}
 
 				if err := updateChannelImage(ctx, url, p); err != nil {
-					return 0, fmt.Errorf("error updating channel image: %w", err)
+					return savePartial(ctx, db, p, state, fmt.Errorf("error updating channel image: %w", err))
 				}
 			}
 		}
 	}
 
+	numUpdated := updateEpisodes(ctx, db, p, state)
+	if (flags&IconOnly) == 0 && !state.isArchivePage {
+		if err := db.SavePodcastMetadata(ctx, p); err != nil {
+			return numUpdated, fmt.Errorf("error saving podcast metadata: %w", err)
+		}
+	}
+
+	return numUpdated, nil
+}
+
+// decodeFeedElement is the Atom equivalent of decodeChannelElement: it decodes the children of the
+// root <feed> element, updating an episode for each <entry>.
+func decodeFeedElement(ctx context.Context, db Store, se xml.StartElement, decoder *xml.Decoder, p *store.Podcast, flags UpdatePodcastFlags, state *updateState) (int, error) {
+	var logo, icon, itunesImage string
+	if !state.isArchivePage {
+		p.Persons = nil
+		p.Funding = nil
+	}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				// that's fine, we're at the end of the stream.
+				break
+			} else {
+				return savePartial(ctx, db, p, state, fmt.Errorf("error decoding feed: %w", err))
+			}
+		}
+
+		switch se := token.(type) {
+		case xml.StartElement:
+			if handled, err := decodeCommonElement(se, decoder, p, state); handled {
+				if err != nil {
+					return savePartial(ctx, db, p, state, err)
+				}
+				continue
+			}
+
+			switch se.Name.Local {
+			case "entry":
+				var entry AtomEntry
+				if err := decoder.DecodeElement(&entry, &se); err != nil {
+					return savePartial(ctx, db, p, state, fmt.Errorf("error parsing entry: %w", err))
+				}
+				state.fetch.NumParsed++
+				item := entry.ToItem()
+				state.seenGUIDs = append(state.seenGUIDs, episodeIdentity(item))
+				if (flags & IconOnly) == 0 {
+					state.items = append(state.items, item)
+				}
+			case "logo":
+				if err := decoder.DecodeElement(&logo, &se); err != nil {
+					return savePartial(ctx, db, p, state, fmt.Errorf("error parsing logo: %w", err))
+				}
+			case "icon":
+				if err := decoder.DecodeElement(&icon, &se); err != nil {
+					return savePartial(ctx, db, p, state, fmt.Errorf("error parsing icon: %w", err))
+				}
+			case "image":
+				var image Image
+				if err := decoder.DecodeElement(&image, &se); err != nil {
+					return savePartial(ctx, db, p, state, fmt.Errorf("error parsing image: %w", err))
+				}
+				itunesImage = image.Href
+			}
+		}
+	}
+
+	// Unlike RSS, we only know which image to use once we've seen the whole feed.
+	feed := AtomFeed{Logo: strings.TrimSpace(logo), Icon: strings.TrimSpace(icon), Image: Image{Href: itunesImage}}
+	if url := feed.ImageURL(); url != "" && !state.isArchivePage {
+		if err := updateChannelImage(ctx, url, p); err != nil {
+			// We have seen the whole feed, so the episodes can still be saved as normal.
+			return updateEpisodes(ctx, db, p, state), fmt.Errorf("error updating channel image: %w", err)
+		}
+	}
+
+	numUpdated := updateEpisodes(ctx, db, p, state)
+	if (flags&IconOnly) == 0 && !state.isArchivePage {
+		if err := db.SavePodcastMetadata(ctx, p); err != nil {
+			return numUpdated, fmt.Errorf("error saving podcast metadata: %w", err)
+		}
+	}
+
 	return numUpdated, nil
 }
 
 // UpdatePodcast fetches the feed URL for the given podcast, parses it and updates all of the
-// episodes we have stored for the podcast. This method updates the passed-in store.Podcast with
-// the latest details.
+// episodes we have stored for the podcast. Both RSS 2.0 and Atom feeds are supported. This method
+// updates the passed-in store.Podcast with the latest details.
+//
+// If the feed has moved, either with a permanent redirect or an <itunes:new-feed-url>, we update
+// the podcast's feed URL as well.
 //
 // To keep memory usage managable, we use the xml.Decoder interface to decode the XML file in a
-// streaming fashion.
+// streaming fashion. We do hold on to the decoded items until the end, though, because we need to
+// know every GUID in the feed before we can tell whether an episode's GUID has been changed.
 //
 // If force is false, then we assume the podcast only has the latest handful of episodes -- anything
 // older than the oldest episode we have already stored is ignored (if there's no existing episode
 // then we assume this is a new podcast and load everything).
 //
 // If flags contains ForceUpdate, then we ignore existing episodes and re-store all episodes in the
-// RSS file. If it contains IconOnly, we skip updating episodes and just update the icon.
-func UpdatePodcast(ctx context.Context, p *store.Podcast, flags UpdatePodcastFlags) (int, error) {
+// RSS file. If it contains IconOnly, we skip updating episodes and just update the icon. If it
+// contains Backfill, we also follow the feed's links to older pages, see backfill.
+//
+// Every call is recorded as a store.FeedFetch, so we can see later what happened.
+func UpdatePodcast(ctx context.Context, db Store, p *store.Podcast, flags UpdatePodcastFlags) (int, error) {
 	log.Printf("Updating podcast: [%d] %s", p.ID, p.Title)
 
+	return recordFetch(ctx, db, p, func(record *store.FeedFetch) (int, error) {
+		return updatePodcast(ctx, db, p, flags, record)
+	})
+}
+
+// IngestFeed updates the given podcast from a copy of its feed that we already have, for example
+// one that was pushed to us by a WebSub hub. Episodes are updated exactly as in UpdatePodcast, but
+// since we don't know that the document has every episode, we never mark any as removed.
+func IngestFeed(ctx context.Context, db Store, p *store.Podcast, r io.Reader, contentType string) (int, error) {
+	log.Printf("Ingesting feed for podcast: [%d] %s", p.ID, p.Title)
+
+	return recordFetch(ctx, db, p, func(record *store.FeedFetch) (int, error) {
+		state := &updateState{fetch: record, isPartial: true}
+		numUpdated, err := decodeFeed(ctx, db, countingReader{r, &record.Bytes}, contentType, p, 0, state)
+		if err != nil {
+			return numUpdated, err
+		}
+
+		// Hubs often push just the new entries, without the feed's links. We only want to lose our
+		// subscription if the feed we fetch ourselves stops advertising the hub.
+		if hubURL := findLink(state.links, "hub"); hubURL != "" {
+			p.HubURL = hubURL
+		}
+		if selfURL := findLink(state.links, "self"); selfURL != "" {
+			p.SelfURL = selfURL
+		}
+		return numUpdated, nil
+	})
+}
+
+// recordFetch calls the given function to update the given podcast, and records the result as a
+// store.FeedFetch.
+func recordFetch(ctx context.Context, db store.PodcastStore, p *store.Podcast, fn func(record *store.FeedFetch) (int, error)) (int, error) {
+	record := &store.FeedFetch{PodcastID: p.ID, StartTime: time.Now()}
+	numUpdated, err := fn(record)
+	record.EndTime = time.Now()
+	record.NumUpdated = numUpdated
+	if err != nil {
+		errStr := err.Error()
+		record.Error = &errStr
+	}
+
+	if err := db.SaveFeedFetch(ctx, record); err != nil {
+		// Not worth failing the whole update for.
+		log.Printf(" - error saving feed fetch: %v", err)
+	}
+
+	return numUpdated, err
+}
+
+// updatePodcast does the actual work of UpdatePodcast, filling in the given FeedFetch as it goes.
+func updatePodcast(ctx context.Context, db Store, p *store.Podcast, flags UpdatePodcastFlags, record *store.FeedFetch) (int, error) {
 	// Fetch the RSS feed via a HTTP request.
-	req, err := http.NewRequest("GET", p.FeedURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", p.FeedURL, nil)
 	if err != nil {
 		log.Printf(" - error creating RSS request: %v", err)
 		return 0, err
 	}
 	if (flags & ForceUpdate) == 0 {
-		maybeAddIfModifiedSince(req, p)
+		addConditionalHeaders(req, p)
 	}
 	req.Header["User-Agent"] = []string{util.GetUserAgent()}
 
-	resp, err := httpClient.Do(req)
+	resp, err := fetch.Default.Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("error fetching URL: %s: %v", p.FeedURL, err)
 	}
+	defer resp.Body.Close()
 	log.Printf(" - fetched %d bytes, status %d %s\n", resp.ContentLength, resp.StatusCode, resp.Status)
+	record.HTTPStatus = &resp.StatusCode
+
+	if resp.StatusCode == 200 || resp.StatusCode == 304 {
+		if err := maybeMoveFeed(ctx, db, p, PermanentRedirectURL(resp), "redirect"); err != nil {
+			log.Printf(" - error moving feed: %v", err)
+		}
+	}
+
 	if resp.StatusCode == 304 {
 		log.Printf(" - podcast has not changed since %s, not updating\n", p.LastFetchTime)
+		saveValidators(resp, p)
 		return 0, nil
 	}
 	if resp.StatusCode != 200 {
+		// The whole response goes in the log, but the fetch history only gets the start of the body.
 		dump, _ := httputil.DumpResponse(resp, true)
-		return 0, fmt.Errorf("error fetching URL: %s status=%d\n%s", p.FeedURL, resp.StatusCode, string(dump))
+		log.Printf(" - error response:\n%s", string(dump))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		body = bytes.ToValidUTF8(bytes.ReplaceAll(body, []byte{0}, nil), []byte("\uFFFD"))
+		return 0, fmt.Errorf("error fetching URL: %s status=%s\n%s", p.FeedURL, resp.Status, string(body))
 	}
 
-	// Unmarshal the RSS feed, loading epsiodes as we go. We are extremely forgiving on the XML
-	// structure, basically skipping everything that's not an <item> element (where the episode
-	// details are stored).
-	decoder := xml.NewDecoder(resp.Body)
-	numUpdated := 0
+	var known map[string]bool
+	if (flags & Backfill) != 0 {
+		// We need to know which episodes we had before we started, so we know when to stop.
+		known, err = db.LoadEpisodeGUIDs(ctx, p.ID)
+		if err != nil {
+			return 0, fmt.Errorf("error loading existing episodes: %w", err)
+		}
+	}
+
+	state := &updateState{fetch: record}
+	numUpdated, err := decodeFeed(ctx, db, countingReader{resp.Body, &record.Bytes}, resp.Header.Get("Content-Type"), p, flags, state)
+	if err != nil {
+		return numUpdated, err
+	}
+
+	// Only now that we've successfully processed the feed can we tell the server we've seen it.
+	saveValidators(resp, p)
+	updateHubLinks(p, state)
+
+	// If the feed is paged, then the episodes we've seen so far are only the latest ones.
+	complete := nextPageURL(state.links, p.FeedURL) == ""
+	if (flags&Backfill) != 0 && (flags&IconOnly) == 0 && !complete {
+		n, walkedAll, err := backfill(ctx, db, p, flags, state, known)
+		numUpdated += n
+		if err != nil {
+			// We've still updated the latest page, so we'll keep going, we just can't be sure we've
+			// seen all the episodes.
+			log.Printf(" - error backfilling: %v", err)
+		}
+		complete = walkedAll && err == nil
+	}
+
+	// On a forced update where we've seen every page of the feed, we can clean up any duplicates
+	// left behind by the publisher changing their GUIDs. If we've only seen some of the pages, an
+	// older episode on a page we haven't seen could look like a duplicate of a newer one.
+	if (flags&ForceUpdate) != 0 && (flags&IconOnly) == 0 && len(state.seenGUIDs) > 0 && complete {
+		if n, err := db.ReconcileEpisodes(ctx, p.ID, state.seenGUIDs); err != nil {
+			log.Printf(" - error reconciling episodes: %v", err)
+		} else if n > 0 {
+			log.Printf(" - merged %d duplicate episodes", n)
+		}
+
+		// Anything we have that's no longer in the feed has been removed by the publisher.
+		if n, err := db.MarkEpisodesRemoved(ctx, p.ID, state.seenGUIDs); err != nil {
+			log.Printf(" - error marking removed episodes: %v", err)
+		} else if n > 0 {
+			log.Printf(" - marked %d episodes as removed", n)
+		}
+	}
+
+	// We only move the feed once we've successfully processed it, the current feed is still
+	// valid until then.
+	if err := maybeMoveFeed(ctx, db, p, state.newFeedURL, "new-feed-url"); err != nil {
+		log.Printf(" - error moving feed: %v", err)
+	}
+	return numUpdated, nil
+}
+
+// updateHubLinks saves the feed's WebSub hub and self links to the podcast.
+func updateHubLinks(p *store.Podcast, state *updateState) {
+	p.HubURL = findLink(state.links, "hub")
+	p.SelfURL = findLink(state.links, "self")
+}
+
+// decodeFeed decodes the RSS or Atom feed in the given reader, updating episodes as we go. We are
+// extremely forgiving on the XML structure, basically skipping everything that's not an <item>
+// element (where the episode details are stored). Atom feeds are handled the same way, with <entry>
+// instead of <item>.
+func decodeFeed(ctx context.Context, db Store, r io.Reader, contentType string, p *store.Podcast, flags UpdatePodcastFlags, state *updateState) (int, error) {
+	decoder := newDecoder(r, contentType)
 	for {
 		token, err := decoder.Token()
 		if err != nil {
@@ -275,19 +664,18 @@ func UpdatePodcast(ctx context.Context, p *store.Podcast, flags UpdatePodcastFla
 				// that's fine, we're at the end of the stream.
 				break
 			} else {
-				// TODO: not just end of stream but maybe some other error?
-				log.Printf("Error in top-level decoding: %v", err)
-				break
+				return 0, fmt.Errorf("error decoding feed: %w", err)
 			}
 		}
 
-		switch se := token.(type) {
-		case xml.StartElement:
+		if se, ok := token.(xml.StartElement); ok {
 			if se.Name.Local == "channel" {
-				return decodeChannelElement(ctx, se, decoder, p, flags)
+				return decodeChannelElement(ctx, db, se, decoder, p, flags, state)
+			} else if isAtomFeed(se) {
+				return decodeFeedElement(ctx, db, se, decoder, p, flags, state)
 			}
 		}
 	}
 
-	return numUpdated, err
+	return 0, fmt.Errorf("no <channel> or <feed> element found")
 }
@@ -0,0 +1,207 @@
+package rss
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/podcreep/server/fetch"
+	"github.com/podcreep/server/store"
+)
+
+// useFixtures serves every fetch from the fixtures in testdata for the rest of the test.
+func useFixtures(t *testing.T) {
+	old := fetch.Default
+	fetch.Default = fetch.NewFake("testdata")
+	t.Cleanup(func() { fetch.Default = old })
+}
+
+// newPodcast saves a new podcast with the given feed URL to the given backend.
+func newPodcast(t *testing.T, db store.Backend, feedURL string) *store.Podcast {
+	p := &store.Podcast{Title: "Untitled", FeedURL: feedURL}
+	if _, err := db.SavePodcast(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// episodeGUIDs returns the GUIDs of all of the given podcast's episodes, newest first.
+func episodeGUIDs(t *testing.T, db store.Backend, p *store.Podcast) []string {
+	episodes, err := db.LoadEpisodes(context.Background(), p.ID, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var guids []string
+	for _, ep := range episodes {
+		guids = append(guids, ep.GUID)
+	}
+	return guids
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUpdatePodcast(t *testing.T) {
+	useFixtures(t)
+	ctx := context.Background()
+	db := store.NewMemoryBackend()
+	p := newPodcast(t, db, "https://example.com/feed.xml")
+
+	numUpdated, err := UpdatePodcast(ctx, db, p, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numUpdated != 2 {
+		t.Errorf("got %d episodes updated, want 2", numUpdated)
+	}
+
+	episodes, err := db.LoadEpisodes(ctx, p.ID, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 2 {
+		t.Fatalf("got %d episodes, want 2", len(episodes))
+	}
+	ep := episodes[0]
+	if ep.GUID != "ep-2" || ep.Title != "Episode 2" || ep.MediaURL != "https://example.com/media/ep-2.mp3" {
+		t.Errorf("got episode [%s] %q %s, want [ep-2] \"Episode 2\" https://example.com/media/ep-2.mp3", ep.GUID, ep.Title, ep.MediaURL)
+	}
+	if ep.DurationSecs == nil || *ep.DurationSecs != 3723 {
+		t.Errorf("got duration %v, want 3723", ep.DurationSecs)
+	}
+	if ep.Season == nil || *ep.Season != 1 || ep.EpisodeNumber == nil || *ep.EpisodeNumber != 2 {
+		t.Errorf("got season %v episode %v, want season 1 episode 2", ep.Season, ep.EpisodeNumber)
+	}
+
+	fetches, err := db.LoadFeedFetches(ctx, p.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fetches) != 1 {
+		t.Fatalf("got %d feed fetches, want 1", len(fetches))
+	}
+	if f := fetches[0]; f.Error != nil || f.HTTPStatus == nil || *f.HTTPStatus != 200 || f.NumParsed != 2 || f.Bytes == 0 {
+		t.Errorf("got feed fetch %+v, want a successful fetch of 2 episodes", f)
+	}
+}
+
+func TestUpdatePodcastAtom(t *testing.T) {
+	useFixtures(t)
+	db := store.NewMemoryBackend()
+	p := newPodcast(t, db, "https://example.com/atom.xml")
+
+	if _, err := UpdatePodcast(context.Background(), db, p, 0); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"urn:example:atom:2", "urn:example:atom:1"}
+	if got := episodeGUIDs(t, db, p); !equalStrings(got, want) {
+		t.Errorf("got episodes %v, want %v", got, want)
+	}
+}
+
+func TestUpdatePodcastCharset(t *testing.T) {
+	useFixtures(t)
+	ctx := context.Background()
+	db := store.NewMemoryBackend()
+	p := newPodcast(t, db, "https://example.com/latin1.xml")
+
+	if _, err := UpdatePodcast(ctx, db, p, 0); err != nil {
+		t.Fatal(err)
+	}
+	episodes, err := db.LoadEpisodes(ctx, p.ID, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 1 {
+		t.Fatalf("got %d episodes, want 1", len(episodes))
+	}
+	if ep := episodes[0]; ep.Title != "Crème brûlée" || ep.Description != "A naïve discussion." {
+		t.Errorf("got episode %q: %q, want %q: %q", ep.Title, ep.Description, "Crème brûlée", "A naïve discussion.")
+	}
+}
+
+func TestUpdatePodcastTruncated(t *testing.T) {
+	useFixtures(t)
+	db := store.NewMemoryBackend()
+	p := newPodcast(t, db, "https://example.com/truncated.xml")
+
+	// The episodes before the one that was cut off should still be saved.
+	numUpdated, err := UpdatePodcast(context.Background(), db, p, 0)
+	if err == nil {
+		t.Errorf("got no error for a truncated feed")
+	}
+	if numUpdated != 2 {
+		t.Errorf("got %d episodes updated, want 2", numUpdated)
+	}
+	want := []string{"trunc-3", "trunc-2"}
+	if got := episodeGUIDs(t, db, p); !equalStrings(got, want) {
+		t.Errorf("got episodes %v, want %v", got, want)
+	}
+}
+
+func TestUpdatePodcastBackfill(t *testing.T) {
+	useFixtures(t)
+	ctx := context.Background()
+
+	// Without Backfill, we only get the first page.
+	db := store.NewMemoryBackend()
+	p := newPodcast(t, db, "https://example.com/paged/feed.xml")
+	if _, err := UpdatePodcast(ctx, db, p, ForceUpdate); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"paged-5", "paged-4"}
+	if got := episodeGUIDs(t, db, p); !equalStrings(got, want) {
+		t.Errorf("without Backfill: got episodes %v, want %v", got, want)
+	}
+
+	// With Backfill, we walk every page. The link from the first page is relative, the one from the
+	// second page is absolute.
+	numUpdated, err := UpdatePodcast(ctx, db, p, ForceUpdate|Backfill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numUpdated != 5 {
+		t.Errorf("with Backfill: got %d episodes updated, want 5", numUpdated)
+	}
+	want = []string{"paged-5", "paged-4", "paged-3", "paged-2", "paged-1"}
+	if got := episodeGUIDs(t, db, p); !equalStrings(got, want) {
+		t.Errorf("with Backfill: got episodes %v, want %v", got, want)
+	}
+}
+
+func TestIngestFeed(t *testing.T) {
+	ctx := context.Background()
+	db := store.NewMemoryBackend()
+	p := newPodcast(t, db, "https://example.com/feed.xml")
+	p.HubURL = "https://hub.example.com/"
+	p.SelfURL = "https://example.com/feed.xml"
+
+	// A pushed document with just the new entry, and none of the feed's links.
+	pushed := `<?xml version="1.0" encoding="UTF-8"?>
+		<feed xmlns="http://www.w3.org/2005/Atom">
+		  <entry>
+		    <id>urn:example:pushed</id>
+		    <title>Pushed entry</title>
+		    <published>2024-01-03T10:00:00Z</published>
+		  </entry>
+		</feed>`
+	numUpdated, err := IngestFeed(ctx, db, p, strings.NewReader(pushed), "application/atom+xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if numUpdated != 1 {
+		t.Errorf("got %d episodes updated, want 1", numUpdated)
+	}
+	if p.HubURL != "https://hub.example.com/" || p.SelfURL != "https://example.com/feed.xml" {
+		t.Errorf("got hub %q self %q, want the links we had before", p.HubURL, p.SelfURL)
+	}
+}
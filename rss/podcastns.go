@@ -0,0 +1,221 @@
+package rss
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/podcreep/server/store"
+)
+
+const (
+	// podcastNamespace is the XML namespace of the Podcasting 2.0 <podcast:*> elements.
+	podcastNamespace = "https://podcastindex.org/namespace/1.0"
+)
+
+// PodcastChapters is a <podcast:chapters> element, which links to a chapters file.
+type PodcastChapters struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// PodcastTranscript is a <podcast:transcript> element, which links to a transcript file.
+type PodcastTranscript struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Language string `xml:"language,attr"`
+	Rel      string `xml:"rel,attr"`
+}
+
+// PodcastPerson is a <podcast:person> element.
+type PodcastPerson struct {
+	Name  string `xml:",chardata"`
+	Role  string `xml:"role,attr"`
+	Group string `xml:"group,attr"`
+	Img   string `xml:"img,attr"`
+	Href  string `xml:"href,attr"`
+}
+
+// PodcastFunding is a <podcast:funding> element.
+type PodcastFunding struct {
+	URL     string `xml:"url,attr"`
+	Message string `xml:",chardata"`
+}
+
+// PodcastSoundbite is a <podcast:soundbite> element.
+type PodcastSoundbite struct {
+	StartTime string `xml:"startTime,attr"`
+	Duration  string `xml:"duration,attr"`
+	Title     string `xml:",chardata"`
+}
+
+// PodcastSource is a <podcast:source> element, one of the places an alternate enclosure can be
+// downloaded from.
+type PodcastSource struct {
+	URI         string `xml:"uri,attr"`
+	ContentType string `xml:"contentType,attr"`
+}
+
+// PodcastAlternateEnclosure is a <podcast:alternateEnclosure> element, a different version of the
+// episode's media (a different bitrate, codec, video, etc).
+type PodcastAlternateEnclosure struct {
+	Type    string          `xml:"type,attr"`
+	Length  string          `xml:"length,attr"`
+	Bitrate string          `xml:"bitrate,attr"`
+	Height  string          `xml:"height,attr"`
+	Lang    string          `xml:"lang,attr"`
+	Title   string          `xml:"title,attr"`
+	Rel     string          `xml:"rel,attr"`
+	Codecs  string          `xml:"codecs,attr"`
+	Default string          `xml:"default,attr"`
+	Sources []PodcastSource `xml:"https://podcastindex.org/namespace/1.0 source"`
+}
+
+// PodcastItem holds the <podcast:*> elements we care about in an <item> (or an Atom <entry>).
+type PodcastItem struct {
+	Chapters            PodcastChapters             `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+	Transcripts         []PodcastTranscript         `xml:"https://podcastindex.org/namespace/1.0 transcript"`
+	Persons             []PodcastPerson             `xml:"https://podcastindex.org/namespace/1.0 person"`
+	Soundbites          []PodcastSoundbite          `xml:"https://podcastindex.org/namespace/1.0 soundbite"`
+	AlternateEnclosures []PodcastAlternateEnclosure `xml:"https://podcastindex.org/namespace/1.0 alternateEnclosure"`
+}
+
+// sourceURL returns the first source of the alternate enclosure that we can actually download from
+// (i.e. an http or https URL, not a torrent or IPFS hash). Returns an empty string if there isn't
+// one.
+func (e PodcastAlternateEnclosure) sourceURL() string {
+	for _, source := range e.Sources {
+		uri := strings.TrimSpace(source.URI)
+		if strings.HasPrefix(uri, "https://") || strings.HasPrefix(uri, "http://") {
+			return uri
+		}
+	}
+	return ""
+}
+
+func (e PodcastAlternateEnclosure) toStore(url string) *store.Enclosure {
+	enclosure := &store.Enclosure{
+		URL:       url,
+		Type:      strings.TrimSpace(e.Type),
+		Length:    parseLength(e.Length),
+		Height:    parseOptionalInt(e.Height),
+		Language:  strings.TrimSpace(e.Lang),
+		Title:     strings.TrimSpace(e.Title),
+		Rel:       strings.TrimSpace(e.Rel),
+		Codecs:    strings.TrimSpace(e.Codecs),
+		IsDefault: strings.TrimSpace(e.Default) == "true",
+	}
+	if bitrate, err := strconv.ParseFloat(strings.TrimSpace(e.Bitrate), 64); err == nil && bitrate > 0 {
+		enclosure.Bitrate = &bitrate
+	}
+	return enclosure
+}
+
+func (p PodcastPerson) toStore() *store.Person {
+	person := &store.Person{
+		Name:     strings.TrimSpace(p.Name),
+		Role:     strings.ToLower(strings.TrimSpace(p.Role)),
+		Group:    strings.ToLower(strings.TrimSpace(p.Group)),
+		ImageURL: p.Img,
+		Href:     p.Href,
+	}
+	// The spec says these are the defaults if the attributes are missing.
+	if person.Role == "" {
+		person.Role = "host"
+	}
+	if person.Group == "" {
+		person.Group = "cast"
+	}
+	return person
+}
+
+// populateEpisodeMetadata copies the <podcast:*> elements of the given item into the episode.
+func populateEpisodeMetadata(item PodcastItem, ep *store.Episode) {
+	if item.Chapters.URL != "" {
+		ep.ChaptersURL = &item.Chapters.URL
+		ep.ChaptersType = &item.Chapters.Type
+	}
+
+	for _, t := range item.Transcripts {
+		if t.URL == "" {
+			continue
+		}
+		ep.Transcripts = append(ep.Transcripts, &store.Transcript{
+			URL:      t.URL,
+			Type:     t.Type,
+			Language: t.Language,
+			Rel:      t.Rel,
+		})
+	}
+
+	for _, p := range item.Persons {
+		if strings.TrimSpace(p.Name) == "" {
+			continue
+		}
+		ep.Persons = append(ep.Persons, p.toStore())
+	}
+
+	for _, e := range item.AlternateEnclosures {
+		url := e.sourceURL()
+		if url == "" {
+			continue
+		}
+		ep.Enclosures = append(ep.Enclosures, e.toStore(url))
+	}
+
+	for _, sb := range item.Soundbites {
+		start, err := strconv.ParseFloat(strings.TrimSpace(sb.StartTime), 64)
+		if err != nil {
+			continue
+		}
+		duration, err := strconv.ParseFloat(strings.TrimSpace(sb.Duration), 64)
+		if err != nil {
+			continue
+		}
+		ep.Soundbites = append(ep.Soundbites, &store.Soundbite{
+			StartSecs:    start,
+			DurationSecs: duration,
+			Title:        strings.TrimSpace(sb.Title),
+		})
+	}
+}
+
+// decodePodcastElement decodes a channel-level <podcast:*> element into the given podcast. Returns
+// false if the element is not one that we handle (in which case nothing is consumed).
+func decodePodcastElement(se xml.StartElement, decoder *xml.Decoder, p *store.Podcast) (bool, error) {
+	if se.Name.Space != podcastNamespace {
+		return false, nil
+	}
+
+	switch se.Name.Local {
+	case "guid":
+		var guid string
+		if err := decoder.DecodeElement(&guid, &se); err != nil {
+			return true, fmt.Errorf("error parsing podcast:guid: %w", err)
+		}
+		p.GUID = strings.TrimSpace(guid)
+	case "person":
+		var person PodcastPerson
+		if err := decoder.DecodeElement(&person, &se); err != nil {
+			return true, fmt.Errorf("error parsing podcast:person: %w", err)
+		}
+		if strings.TrimSpace(person.Name) != "" {
+			p.Persons = append(p.Persons, person.toStore())
+		}
+	case "funding":
+		var funding PodcastFunding
+		if err := decoder.DecodeElement(&funding, &se); err != nil {
+			return true, fmt.Errorf("error parsing podcast:funding: %w", err)
+		}
+		if funding.URL != "" {
+			p.Funding = append(p.Funding, &store.Funding{
+				URL:     funding.URL,
+				Message: strings.TrimSpace(funding.Message),
+			})
+		}
+	default:
+		return false, nil
+	}
+	return true, nil
+}
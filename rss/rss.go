@@ -1,6 +1,19 @@
 // Package rss ...
 package rss
 
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+)
+
+const (
+	// atomNamespace is the XML namespace of Atom (RFC 4287) elements.
+	atomNamespace = "http://www.w3.org/2005/Atom"
+)
+
 // Image ...
 type Image struct {
 	URL   string `xml:"url"`
@@ -11,8 +24,10 @@ type Image struct {
 
 // Media ...
 type Media struct {
-	URL    string `xml:"url,attr"`
-	Length int    `xml:"length,attr"`
+	URL string `xml:"url,attr"`
+	// Length is a string, because plenty of feeds have an empty or otherwise invalid length, and we
+	// don't want that to stop us from parsing the rest of the item.
+	Length string `xml:"length,attr"`
 	Type   string `xml:"type,attr"`
 }
 
@@ -24,26 +39,221 @@ type Item struct {
 	PubDate            string `xml:"pubDate"`
 	GUID               string `xml:"guid"`
 	Media              Media  `xml:"enclosure"`
+
+	PSCChapters PSCChapters `xml:"http://podlove.org/simple-chapters chapters"`
+
+	ITunesItem
+	PodcastItem
+}
+
+// ITunesItem holds the <itunes:*> elements we care about in an <item> (or an Atom <entry>).
+type ITunesItem struct {
+	Duration    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Season      string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	Episode     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	EpisodeType string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episodeType"`
+	Explicit    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+	Image       Image  `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
 }
 
 // AtomLink ...
 type AtomLink struct {
-	Href string `xml:"href,attr"`
-	Rel  string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+// findLink returns the href of the first link in the given list with the given rel, or an empty
+// string if there isn't one.
+func findLink(links []AtomLink, rel string) string {
+	for _, link := range links {
+		if link.Rel == rel {
+			return strings.TrimSpace(link.Href)
+		}
+	}
+	return ""
 }
 
 // Channel ...
 type Channel struct {
-	Title       string   `xml:"title"`
-	Link        AtomLink `xml:"http://www.w3.org/2005/Atom link"`
-	Language    string   `xml:"language"`
-	Copyright   string   `xml:"copright"`
-	Description string   `xml:"description"`
-	Image       Image    `xml:"image"`
-	Items       []Item   `xml:"item"`
+	Title       string     `xml:"title"`
+	Links       []AtomLink `xml:"http://www.w3.org/2005/Atom link"`
+	Language    string     `xml:"language"`
+	Copyright   string     `xml:"copright"`
+	Description string     `xml:"description"`
+	Image       Image      `xml:"image"`
+	Items       []Item     `xml:"item"`
+
+	NewFeedURL string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd new-feed-url"`
+
+	GUID    string           `xml:"https://podcastindex.org/namespace/1.0 guid"`
+	Persons []PodcastPerson  `xml:"https://podcastindex.org/namespace/1.0 person"`
+	Funding []PodcastFunding `xml:"https://podcastindex.org/namespace/1.0 funding"`
 }
 
 // Feed ...
 type Feed struct {
 	Channel Channel `xml:"channel"`
 }
+
+// AtomText is an Atom text construct (e.g. <title>, <summary> or <content>), which can be plain
+// text, escaped HTML or inline XHTML depending on the type attribute.
+type AtomText struct {
+	Type     string `xml:"type,attr"`
+	Body     string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// IsHTML returns true if this text construct contains HTML (either escaped or inline XHTML).
+func (t AtomText) IsHTML() bool {
+	return t.Type == "html" || t.Type == "xhtml"
+}
+
+// String returns the text content. For inline XHTML, this is the markup itself.
+func (t AtomText) String() string {
+	if t.Type == "xhtml" {
+		return strings.TrimSpace(t.InnerXML)
+	}
+	return strings.TrimSpace(t.Body)
+}
+
+// PlainText returns the text content with any HTML markup removed.
+func (t AtomText) PlainText() string {
+	if t.IsHTML() {
+		return html.UnescapeString(htmlPolicy.Sanitize(t.String()))
+	}
+	return t.String()
+}
+
+// AtomEntry is a single <entry> in an Atom feed, the equivalent of an RSS <item>.
+type AtomEntry struct {
+	ID        string     `xml:"id"`
+	Title     AtomText   `xml:"title"`
+	Summary   AtomText   `xml:"summary"`
+	Content   AtomText   `xml:"content"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Links     []AtomLink `xml:"link"`
+
+	PSCChapters PSCChapters `xml:"http://podlove.org/simple-chapters chapters"`
+
+	ITunesItem
+	PodcastItem
+}
+
+// ToItem converts this Atom entry to the equivalent RSS Item, so that the rest of the code only has
+// to deal with one kind of episode.
+func (e AtomEntry) ToItem() Item {
+	item := Item{
+		Title:       e.Title.PlainText(),
+		PubDate:     e.Published,
+		GUID:        e.ID,
+		PSCChapters: e.PSCChapters,
+		ITunesItem:  e.ITunesItem,
+		PodcastItem: e.PodcastItem,
+	}
+	if item.PubDate == "" {
+		item.PubDate = e.Updated
+	}
+
+	item.Description = e.Summary.String()
+	if e.Content.IsHTML() {
+		item.EncodedDescription = e.Content.String()
+	} else if item.Description == "" {
+		item.Description = e.Content.String()
+	}
+	if e.Summary.IsHTML() && item.EncodedDescription == "" {
+		item.EncodedDescription = item.Description
+	}
+
+	for _, link := range e.Links {
+		if link.Rel == "enclosure" {
+			item.Media = Media{URL: link.Href, Length: link.Length, Type: link.Type}
+			break
+		}
+	}
+	return item
+}
+
+// AtomFeed is the root <feed> element of an Atom document. The Image field picks up an
+// <itunes:image> if the feed has one.
+type AtomFeed struct {
+	Title    AtomText    `xml:"title"`
+	Subtitle AtomText    `xml:"subtitle"`
+	Icon     string      `xml:"icon"`
+	Logo     string      `xml:"logo"`
+	Image    Image       `xml:"image"`
+	Entries  []AtomEntry `xml:"entry"`
+	Links    []AtomLink  `xml:"link"`
+
+	NewFeedURL string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd new-feed-url"`
+}
+
+// ImageURL returns the best image we can find for this feed: the <logo>, then the <icon>, and
+// finally the <itunes:image>.
+func (f AtomFeed) ImageURL() string {
+	if f.Logo != "" {
+		return f.Logo
+	}
+	if f.Icon != "" {
+		return f.Icon
+	}
+	return f.Image.Href
+}
+
+// ToChannel converts this Atom feed to the equivalent RSS Channel.
+func (f AtomFeed) ToChannel() Channel {
+	ch := Channel{
+		Title:       f.Title.PlainText(),
+		Description: f.Subtitle.PlainText(),
+		Image:       Image{URL: f.ImageURL()},
+		NewFeedURL:  f.NewFeedURL,
+		Links:       f.Links,
+	}
+	for _, entry := range f.Entries {
+		ch.Items = append(ch.Items, entry.ToItem())
+	}
+	return ch
+}
+
+// isAtomFeed returns true if the given element is the root <feed> element of an Atom document.
+func isAtomFeed(se xml.StartElement) bool {
+	return se.Name.Local == "feed" && (se.Name.Space == atomNamespace || se.Name.Space == "")
+}
+
+// DecodeFeed decodes the given RSS or Atom document and returns its <channel>. Atom feeds are
+// converted to the equivalent RSS Channel. contentType is the Content-Type header the document was
+// served with (or empty if unknown), which we use to work out its charset.
+func DecodeFeed(r io.Reader, contentType string) (*Channel, error) {
+	decoder := newDecoder(r, contentType)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("no <rss> or <feed> element found")
+			}
+			return nil, err
+		}
+
+		se, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if se.Name.Local == "rss" {
+			var feed Feed
+			if err := decoder.DecodeElement(&feed, &se); err != nil {
+				return nil, err
+			}
+			return &feed.Channel, nil
+		} else if isAtomFeed(se) {
+			var feed AtomFeed
+			if err := decoder.DecodeElement(&feed, &se); err != nil {
+				return nil, err
+			}
+			ch := feed.ToChannel()
+			return &ch, nil
+		}
+	}
+}
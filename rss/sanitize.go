@@ -0,0 +1,77 @@
+package rss
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/podcreep/server/store"
+)
+
+// descriptionPolicyVersion is the version of richHTMLPolicy. Bump it whenever the policy changes,
+// and the resanitize-descriptions cron job will re-sanitise all of the existing descriptions.
+const descriptionPolicyVersion = 1
+
+// resanitizeBatchSize is the number of episodes we re-sanitise at a time.
+const resanitizeBatchSize = 500
+
+var (
+	// richHTMLPolicy is the policy we use for episode descriptions that are HTML (i.e. from
+	// <content:encoded>), which the clients render as-is. It allows the usual formatting, links,
+	// lists, images and <time> elements, but nothing that can run script. Links to other sites open
+	// in a new tab, with rel="noopener".
+	richHTMLPolicy = newRichHTMLPolicy()
+
+	// timestampPattern matches the datetime of a <time> element. Podcasts mostly use these for
+	// offsets into the episode (e.g. "01:23:45" or "PT1H23M45S") rather than dates, which the UGC
+	// policy's own pattern doesn't allow.
+	timestampPattern = regexp.MustCompile(`^[0-9A-Z:.+\- ]{1,40}$`)
+)
+
+func newRichHTMLPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+	p.AllowAttrs("datetime").Matching(timestampPattern).OnElements("time")
+	return p
+}
+
+// sanitizeDescription sanitises the given episode's description with the appropriate policy, and
+// records which version of the policy we used.
+func sanitizeDescription(ep *store.Episode) {
+	if ep.DescriptionHTML {
+		ep.Description = richHTMLPolicy.Sanitize(ep.Description)
+	} else {
+		ep.Description = htmlPolicy.Sanitize(ep.Description)
+	}
+	ep.DescriptionPolicy = descriptionPolicyVersion
+}
+
+// ResanitizeDescriptions is run as a cron job, and re-sanitises the descriptions of all episodes
+// that were sanitised with an older version of the policy (or before we sanitised HTML descriptions
+// at all).
+func ResanitizeDescriptions(ctx context.Context, db store.EpisodeStore) error {
+	total := 0
+	for {
+		episodes, err := db.LoadEpisodesByDescriptionPolicy(ctx, descriptionPolicyVersion, resanitizeBatchSize)
+		if err != nil {
+			return fmt.Errorf("error loading episodes: %w", err)
+		}
+		if len(episodes) == 0 {
+			break
+		}
+
+		for _, ep := range episodes {
+			sanitizeDescription(ep)
+			if err := db.UpdateEpisodeDescription(ctx, ep); err != nil {
+				return fmt.Errorf("error updating episode %d: %w", ep.ID, err)
+			}
+		}
+		total += len(episodes)
+	}
+
+	log.Printf("Re-sanitised %d episode descriptions", total)
+	return nil
+}
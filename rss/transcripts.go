@@ -0,0 +1,320 @@
+package rss
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/podcreep/server/fetch"
+	"github.com/podcreep/server/store"
+	"github.com/podcreep/server/util"
+	xhtml "golang.org/x/net/html"
+)
+
+const (
+	// maxTranscriptSize is the biggest transcript file we'll download.
+	maxTranscriptSize = 10 * 1024 * 1024
+)
+
+var (
+	// transcriptFormats is the list of transcript formats we support, in order of preference. The
+	// formats with timestamps and speakers come first.
+	transcriptFormats = []struct {
+		types []string
+		parse func(data []byte) ([]*store.TranscriptSegment, error)
+	}{
+		{[]string{"application/json"}, parseJSONTranscript},
+		{[]string{"text/vtt"}, parseVTTTranscript},
+		{[]string{"application/srt", "application/x-subrip", "text/srt"}, parseSRTTranscript},
+		{[]string{"text/html"}, parseHTMLTranscript},
+		{[]string{"text/plain"}, parsePlainTranscript},
+	}
+
+	// vttVoiceRegex matches a WebVTT voice span, e.g. "<v Bob>", and captures the speaker's name.
+	vttVoiceRegex = regexp.MustCompile(`^<v(?:\.[^ >]+)*\s+([^>]+)>`)
+
+	// vttTagRegex matches any WebVTT tag, e.g. "<i>", "</v>" or "<00:01:02.000>".
+	vttTagRegex = regexp.MustCompile(`<[^>]*>`)
+)
+
+// jsonTranscript is the format of a Podcasting 2.0 JSON transcript.
+type jsonTranscript struct {
+	Segments []struct {
+		Speaker   string   `json:"speaker"`
+		StartTime float64  `json:"startTime"`
+		EndTime   *float64 `json:"endTime"`
+		Body      string   `json:"body"`
+	} `json:"segments"`
+}
+
+func parseJSONTranscript(data []byte) ([]*store.TranscriptSegment, error) {
+	var doc jsonTranscript
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error parsing transcript: %w", err)
+	}
+
+	var segments []*store.TranscriptSegment
+	for _, s := range doc.Segments {
+		segments = append(segments, &store.TranscriptSegment{
+			StartSecs: s.StartTime,
+			EndSecs:   s.EndTime,
+			Speaker:   strings.TrimSpace(s.Speaker),
+			Body:      strings.TrimSpace(s.Body),
+		})
+	}
+	return segments, nil
+}
+
+// parseCueTiming parses a "start --> end" cue timing line from an SRT or WebVTT file. Anything
+// after the end time (WebVTT cue settings) is ignored.
+func parseCueTiming(line string) (float64, float64, error) {
+	parts := strings.SplitN(line, "-->", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("not a cue timing: %s", line)
+	}
+	endFields := strings.Fields(parts[1])
+	if len(endFields) == 0 {
+		return 0, 0, fmt.Errorf("not a cue timing: %s", line)
+	}
+
+	// SRT uses a comma for the decimal point, WebVTT uses a period.
+	start, err := parseNormalPlayTime(strings.ReplaceAll(parts[0], ",", "."))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseNormalPlayTime(strings.ReplaceAll(endFields[0], ",", "."))
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// parseCues parses the cues of an SRT or WebVTT file. Both are made up of blocks separated by
+// blank lines, where each cue has an optional identifier, a timing line and then the text. Blocks
+// without a timing line (the WEBVTT header, NOTEs, etc) are skipped.
+func parseCues(data []byte) ([]*store.TranscriptSegment, error) {
+	var segments []*store.TranscriptSegment
+	var block []string
+
+	flush := func() {
+		defer func() { block = nil }()
+		for i, line := range block {
+			if !strings.Contains(line, "-->") {
+				continue
+			}
+			start, end, err := parseCueTiming(line)
+			if err != nil {
+				return
+			}
+
+			text := strings.Join(block[i+1:], " ")
+			speaker := ""
+			if m := vttVoiceRegex.FindStringSubmatch(text); m != nil {
+				speaker = strings.TrimSpace(m[1])
+			}
+			text = html.UnescapeString(vttTagRegex.ReplaceAllString(text, ""))
+			text = strings.TrimSpace(text)
+			if text == "" {
+				return
+			}
+
+			segments = append(segments, &store.TranscriptSegment{
+				StartSecs: start,
+				EndSecs:   &end,
+				Speaker:   speaker,
+				Body:      text,
+			})
+			return
+		}
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxTranscriptSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			flush()
+			continue
+		}
+		block = append(block, line)
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+func parseVTTTranscript(data []byte) ([]*store.TranscriptSegment, error) {
+	if !bytes.HasPrefix(bytes.TrimPrefix(data, []byte("\ufeff")), []byte("WEBVTT")) {
+		return nil, fmt.Errorf("not a WebVTT file")
+	}
+	return parseCues(data)
+}
+
+func parseSRTTranscript(data []byte) ([]*store.TranscriptSegment, error) {
+	return parseCues(data)
+}
+
+// parseHTMLTranscript parses an HTML transcript. The Podcasting 2.0 spec suggests a structure of
+// <cite>Speaker:</cite> <time>0:00</time> <p>Text...</p>, so that's what we look for. If there are
+// no <p> elements at all, the whole document becomes one segment.
+func parseHTMLTranscript(data []byte) ([]*store.TranscriptSegment, error) {
+	var segments []*store.TranscriptSegment
+	var speaker string
+	var start float64
+	var current string
+	var text strings.Builder
+	var allText strings.Builder
+
+	tokenizer := xhtml.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := tokenizer.Next()
+		if tt == xhtml.ErrorToken {
+			if tokenizer.Err() != io.EOF {
+				return nil, tokenizer.Err()
+			}
+			break
+		}
+
+		switch tt {
+		case xhtml.StartTagToken:
+			name, _ := tokenizer.TagName()
+			current = string(name)
+			switch current {
+			case "cite", "time", "p":
+				text.Reset()
+			}
+		case xhtml.TextToken:
+			if current == "script" || current == "style" {
+				continue
+			}
+			str := string(tokenizer.Text())
+			text.WriteString(str)
+			allText.WriteString(str)
+			allText.WriteString(" ")
+		case xhtml.EndTagToken:
+			name, _ := tokenizer.TagName()
+			value := strings.TrimSpace(text.String())
+			current = ""
+			switch string(name) {
+			case "cite":
+				speaker = strings.TrimSuffix(value, ":")
+			case "time":
+				if t, err := parseNormalPlayTime(value); err == nil {
+					start = t
+				}
+			case "p":
+				if value != "" {
+					segments = append(segments, &store.TranscriptSegment{
+						StartSecs: start,
+						Speaker:   speaker,
+						Body:      strings.Join(strings.Fields(value), " "),
+					})
+				}
+			default:
+				continue
+			}
+			text.Reset()
+		}
+	}
+
+	if len(segments) == 0 {
+		return parsePlainTranscript([]byte(allText.String()))
+	}
+	return segments, nil
+}
+
+func parsePlainTranscript(data []byte) ([]*store.TranscriptSegment, error) {
+	body := strings.Join(strings.Fields(string(data)), " ")
+	if body == "" {
+		return nil, nil
+	}
+	return []*store.TranscriptSegment{{Body: body}}, nil
+}
+
+// chooseTranscript picks the transcript we'd most like to ingest for an episode, and the function
+// to parse it with. Returns nil if there are no transcripts in a format we support.
+func chooseTranscript(transcripts []*store.Transcript) (*store.Transcript, func([]byte) ([]*store.TranscriptSegment, error)) {
+	for _, format := range transcriptFormats {
+		for _, t := range transcripts {
+			mimeType := strings.ToLower(strings.TrimSpace(strings.Split(t.Type, ";")[0]))
+			for _, formatType := range format.types {
+				if mimeType == formatType {
+					return t, format.parse
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// fetchTranscript downloads the transcript at the given URL.
+func fetchTranscript(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	req.Header["User-Agent"] = []string{util.GetUserAgent()}
+
+	resp, err := fetch.Default.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("error fetching %s: status=%d", url, resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxTranscriptSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading transcript: %w", err)
+	}
+	if len(data) > maxTranscriptSize {
+		return nil, fmt.Errorf("transcript is too big: %s", url)
+	}
+	return data, nil
+}
+
+// updateTranscript fetches and parses the transcript of the given episode, if it has changed since
+// we last saw it, and saves it.
+func updateTranscript(ctx context.Context, db store.EpisodeStore, ep *store.Episode) error {
+	transcript, parse := chooseTranscript(ep.Transcripts)
+	source := ""
+	if transcript != nil {
+		source = transcript.URL
+	}
+
+	existingSource, err := db.LoadTranscriptSource(ctx, ep.ID)
+	if err != nil {
+		return err
+	}
+	if source == existingSource {
+		return nil
+	}
+
+	var segments []*store.TranscriptSegment
+	if transcript != nil {
+		log.Printf(" - fetching transcript: %s", transcript.URL)
+		data, err := fetchTranscript(ctx, transcript.URL)
+		if err != nil {
+			return err
+		}
+		segments, err = parse(data)
+		if err != nil {
+			return fmt.Errorf("error parsing transcript %s: %w", transcript.URL, err)
+		}
+	}
+
+	return db.SaveTranscript(ctx, ep.ID, source, segments)
+}
@@ -0,0 +1,263 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// AccountStore stores accounts, and the podcasts each account is subscribed to.
+type AccountStore interface {
+	// SaveAccount saves an account to the data store.
+	SaveAccount(ctx context.Context, username, password string) (*Account, error)
+
+	// VerifyUsernameExists returns true if the given username exists or false if it does not exist.
+	// An error is returned if there is an error talking to the database.
+	VerifyUsernameExists(ctx context.Context, username string) (bool, error)
+
+	// LoadAccountByUsername loads the Account for the user with the given username. Returns nil, nil
+	// if no account with that username exists.
+	LoadAccountByUsername(ctx context.Context, username, password string) (*Account, error)
+
+	// LoadAccountByCookie loads the Account for the user with the given cookie. Returns an error
+	// if no account with that cookie exists.
+	LoadAccountByCookie(ctx context.Context, cookie string) (*Account, error)
+
+	// SaveSubscription saves a new subscription to the data store.
+	SaveSubscription(ctx context.Context, acct *Account, podcastID int64) error
+
+	// DeleteSubscription deletes a subscription for the given podcast.
+	DeleteSubscription(ctx context.Context, acct *Account, podcastID int64) error
+
+	// GetSubscriptions return the Podcasts that this account is subscribed to.
+	GetSubscriptions(ctx context.Context, acct *Account) ([]*Podcast, error)
+
+	// LoadSubscriptionIDs gets the ID of all the podcasts the given account is subscribed to.
+	LoadSubscriptionIDs(ctx context.Context, acct *Account) (map[int64]struct{}, error)
+
+	// IsSubscribed returns true if the given account is subscribed to the given podcast or not.
+	IsSubscribed(ctx context.Context, acct *Account, podcastID int64) bool
+}
+
+// PodcastStore stores podcasts, along with everything we keep track of per-podcast: metadata, the
+// history of its feed, its WebSub subscription and its media cache settings.
+type PodcastStore interface {
+	// SavePodcast saves the given podcast to the store.
+	SavePodcast(ctx context.Context, p *Podcast) (int64, error)
+
+	// LoadPodcast returns the podcast with the given ID.
+	LoadPodcast(ctx context.Context, podcastID int64) (*Podcast, error)
+
+	// LoadPodcastByDiscoverId attempts to load a podcast with the given discover ID.
+	LoadPodcastByDiscoverId(ctx context.Context, discoverID string) (*Podcast, error)
+
+	// LoadPodcasts loads a page of podcasts from the data store, in the order they were added. Also
+	// returns the cursor of the next page, which is empty if this was the last one.
+	// TODO: support filtering, sorting(?), etc.
+	LoadPodcasts(ctx context.Context, page Page) ([]*Podcast, string, error)
+
+	// DeletePodcast deletes the podcast with the given ID. This should remove the podcast as well as
+	// all episodes, subscriptions and so on.
+	DeletePodcast(ctx context.Context, podcast *Podcast) error
+
+	// SavePodcastMetadata replaces the podcast-level persons and funding of the given podcast with the
+	// ones on the Podcast struct.
+	SavePodcastMetadata(ctx context.Context, p *Podcast) error
+
+	// LoadPodcastMetadata populates the persons and funding of the given podcast.
+	LoadPodcastMetadata(ctx context.Context, p *Podcast) error
+
+	// UpdatePodcastFeedURL changes the feed URL of the given podcast, and records the old URL in the
+	// podcast's feed URL history. The podcast keeps its ID, so subscriptions and episodes are not
+	// affected.
+	UpdatePodcastFeedURL(ctx context.Context, p *Podcast, newURL, reason string) error
+
+	// LoadFeedURLHistory loads all of the previous feed URLs of the given podcast, most recent first.
+	LoadFeedURLHistory(ctx context.Context, podcastID int64) ([]*FeedURLChange, error)
+
+	// SaveFeedFetch saves the given feed fetch, and deletes the oldest fetches of the podcast if
+	// there are more than we want to keep.
+	SaveFeedFetch(ctx context.Context, f *FeedFetch) error
+
+	// LoadFeedFetches loads the most recent fetches of the given podcast's feed, most recent first.
+	LoadFeedFetches(ctx context.Context, podcastID int64, limit int) ([]*FeedFetch, error)
+
+	// SaveWebSubSubscription saves the given subscription, replacing any existing subscription for the
+	// same podcast.
+	SaveWebSubSubscription(ctx context.Context, sub *WebSubSubscription) error
+
+	// LoadWebSubSubscription loads the subscription for the given podcast. Returns nil (and no error)
+	// if we don't have one.
+	LoadWebSubSubscription(ctx context.Context, podcastID int64) (*WebSubSubscription, error)
+
+	// LoadWebSubSubscriptions loads all of our subscriptions.
+	LoadWebSubSubscriptions(ctx context.Context) ([]*WebSubSubscription, error)
+
+	// DeleteWebSubSubscription deletes the subscription for the given podcast, if there is one.
+	DeleteWebSubSubscription(ctx context.Context, podcastID int64) error
+
+	// LoadMediaCacheSettings loads the media cache settings of the given podcast. If it doesn't have
+	// any, the default (disabled) settings are returned.
+	LoadMediaCacheSettings(ctx context.Context, podcastID int64) (*MediaCacheSettings, error)
+
+	// LoadAllMediaCacheSettings loads the media cache settings of all podcasts that have any.
+	LoadAllMediaCacheSettings(ctx context.Context) ([]*MediaCacheSettings, error)
+
+	// SaveMediaCacheSettings saves the given media cache settings.
+	SaveMediaCacheSettings(ctx context.Context, settings *MediaCacheSettings) error
+}
+
+// EpisodeStore stores episodes, along with their metadata, chapters, transcripts and cached media.
+type EpisodeStore interface {
+	// SaveEpisode saves the given episode to the data store, along with its transcripts, persons and
+	// soundbites. Episodes are identified by their GUID, but if the GUID is new and looks like it was
+	// rewritten from an existing episode's, we update that episode instead.
+	//
+	// currentGUIDs must be the GUIDs of every episode in the feed: an existing episode is only treated
+	// as renamed if its GUID is no longer in there. If it's nil, we don't know what's in the feed, so
+	// we never rename anything.
+	SaveEpisode(ctx context.Context, p *Podcast, ep *Episode, currentGUIDs []string) error
+
+	// LoadEpisode gets the episode with the given ID for the given podcast.
+	LoadEpisode(ctx context.Context, p *Podcast, episodeID int64) (*Episode, error)
+
+	// LoadEpisodes loads all episodes for the given podcast, up to the given limit. If limit is < 0
+	// then loads all episodes.
+	LoadEpisodes(ctx context.Context, podcastID int64, limit int) ([]*Episode, error)
+
+	// LoadEpisodeMetadata populates the transcripts, persons, soundbites, enclosures and cached media
+	// URL of all of the given episodes. We do this in one query per table, rather than one query per
+	// episode.
+	LoadEpisodeMetadata(ctx context.Context, episodes []*Episode) error
+
+	// LoadEpisodeGUIDs returns the GUIDs of all of the given podcast's episodes, as a set.
+	LoadEpisodeGUIDs(ctx context.Context, podcastID int64) (map[string]bool, error)
+
+	// LoadEpisodesWithoutDuration loads up to limit episodes (newest first) that have no duration, and
+	// that we haven't tried to probe for a duration since the given time.
+	LoadEpisodesWithoutDuration(ctx context.Context, probedBefore time.Time, limit int) ([]*Episode, error)
+
+	// SaveEpisodeDuration saves the DurationSecs of the given episode (which may be null, if we
+	// couldn't work it out), and records that we probed it at the given time.
+	SaveEpisodeDuration(ctx context.Context, ep *Episode, probedAt time.Time) error
+
+	// LoadEpisodesByDescriptionPolicy loads up to limit episodes whose descriptions were sanitised with
+	// a policy older than the given version. Only the ID, Description and DescriptionHTML fields are
+	// populated.
+	LoadEpisodesByDescriptionPolicy(ctx context.Context, version, limit int) ([]*Episode, error)
+
+	// UpdateEpisodeDescription saves just the Description and DescriptionPolicy of the given episode.
+	UpdateEpisodeDescription(ctx context.Context, ep *Episode) error
+
+	// ReconcileEpisodes looks for episodes of the given podcast that are duplicates of an episode in the
+	// feed, but with a GUID that is no longer in the feed. These were created when the publisher
+	// rewrote their GUIDs before we knew how to detect that. The duplicates are merged into the episode
+	// that is still in the feed, so nobody loses their progress.
+	//
+	// currentGUIDs must be the GUIDs of every episode in the feed.
+	ReconcileEpisodes(ctx context.Context, podcastID int64, currentGUIDs []string) (int, error)
+
+	// MarkEpisodesRemoved marks every episode of the given podcast whose GUID is not in currentGUIDs as
+	// removed. currentGUIDs must be the GUIDs of every episode in the feed. Returns the number of
+	// episodes that were newly marked.
+	MarkEpisodesRemoved(ctx context.Context, podcastID int64, currentGUIDs []string) (int64, error)
+
+	// PurgeRemovedEpisodes deletes all of the given podcast's episodes that have been removed from the
+	// feed. Episodes that anyone has progress on are kept, since a feed that only lists its latest
+	// episodes makes the older ones look removed. Returns the number of episodes deleted.
+	PurgeRemovedEpisodes(ctx context.Context, podcastID int64) (int64, error)
+
+	// LoadChaptersSource returns where we got the given episode's chapters from. Its Source is empty
+	// if the episode has no chapters.
+	LoadChaptersSource(ctx context.Context, episodeID int64) (*ChaptersSource, error)
+
+	// SaveChapters replaces the chapters of the given episode, and records where we got them from.
+	SaveChapters(ctx context.Context, episodeID int64, source *ChaptersSource, chapters []*Chapter) error
+
+	// LoadChapters loads the chapters of the given episode, in order.
+	LoadChapters(ctx context.Context, episodeID int64) ([]*Chapter, error)
+
+	// LoadTranscriptSource returns the URL we got the given episode's transcript from, or an empty
+	// string if the episode has no transcript.
+	LoadTranscriptSource(ctx context.Context, episodeID int64) (string, error)
+
+	// SaveTranscript replaces the transcript of the given episode, and records where we got it from.
+	SaveTranscript(ctx context.Context, episodeID int64, source string, segments []*TranscriptSegment) error
+
+	// SearchTranscripts does a full-text search of all transcripts for the given query, which can use
+	// the usual web search syntax ("quoted phrases", -excluded, etc). Returns at most limit hits,
+	// grouped by episode, newest episodes first.
+	SearchTranscripts(ctx context.Context, query string, limit int) ([]*TranscriptSearchResult, error)
+
+	// SaveEpisodeMedia records that we've downloaded the given episode media.
+	SaveEpisodeMedia(ctx context.Context, m *EpisodeMedia) error
+
+	// LoadEpisodeMedia loads the cached media of the given episode. Returns nil (and no error) if we
+	// don't have it cached.
+	LoadEpisodeMedia(ctx context.Context, episodeID int64) (*EpisodeMedia, error)
+
+	// LoadAllEpisodeMedia loads all of the episode media we have cached, most recently accessed first.
+	LoadAllEpisodeMedia(ctx context.Context) ([]*EpisodeMedia, error)
+
+	// TouchEpisodeMedia records that the given episode's cached media was accessed at the given time.
+	TouchEpisodeMedia(ctx context.Context, episodeID int64, now time.Time) error
+
+	// DeleteEpisodeMedia records that we no longer have the given episode's media cached.
+	DeleteEpisodeMedia(ctx context.Context, episodeID int64) error
+}
+
+// ProgressStore stores how far through each episode each account is, and loads episodes along with
+// an account's progress on them.
+type ProgressStore interface {
+	// SaveEpisodeProgress saves the given EpisodeProgress to the database.
+	SaveEpisodeProgress(ctx context.Context, progress *EpisodeProgress) error
+
+	// LoadEpisodesForSubscription gets the episodes to display for the given subscribed account, newest
+	// first. We'll return all episodes that the account has not finished listening to, one page at a
+	// time. Also returns the cursor of the next page, which is empty if this was the last one.
+	LoadEpisodesForSubscription(ctx context.Context, acct *Account, p *Podcast, page Page) ([]*Episode, string, error)
+
+	// LoadEpisodesNewAndInProgress gets the new and in-progress episodes for the given account. In this
+	// case, new episodes are ones that don't have any progress at all (and only from the last numDays
+	// days). And of course, in-progress ones are ones that have progress but are not yet
+	// marked done. For in-progress episode, we don't just limit them to the last numDays days, we will
+	// return them all. Episodes that have been removed from the feed are only returned if the account
+	// already has progress on them.
+	LoadEpisodesNewAndInProgress(ctx context.Context, acct *Account, numDays int) (newEpisodes []*Episode, inProgress []*Episode, err error)
+
+	// GetMostRecentPlaybackState returns the episode the given account most recently played, along
+	// with its progress.
+	GetMostRecentPlaybackState(ctx context.Context, acct *Account) (*Episode, error)
+}
+
+// CronStore stores our cron jobs and when they next need to run.
+type CronStore interface {
+	// LoadCronJobs returns all cron jobs in the database.
+	LoadCrobJobs(ctx context.Context) ([]*CronJob, error)
+
+	// LoadCronJob returns a single cron job with the given ID from the database.
+	LoadCrobJob(ctx context.Context, id int64) (*CronJob, error)
+
+	// Gets the time we need to wait until the next cron job. Maximum duration is 30 minutes.
+	GetTimeToNextCronJob(ctx context.Context, now time.Time) time.Duration
+
+	// LoadPendingCronJobs all the cron jobs that are currently scheduled to run now.
+	LoadPendingCronJobs(ctx context.Context, now time.Time) ([]*CronJob, error)
+
+	// DeleteCronJob deletes the given cron job from the database.
+	DeleteCronJob(ctx context.Context, id int64) error
+
+	// SaveCronJob saves the given cron job to the database.
+	SaveCronJob(ctx context.Context, job *CronJob) error
+}
+
+// Backend is everything we need from a place to store our data. Connect returns one that talks to
+// PostgreSQL or SQLite, and the rest of the server is given it (or the parts of it that it needs)
+// when it's set up. Tests can use the one from NewMemoryBackend instead, which doesn't need a
+// database at all.
+type Backend interface {
+	AccountStore
+	PodcastStore
+	EpisodeStore
+	ProgressStore
+	CronStore
+}
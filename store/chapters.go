@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// Chapter is a single chapter of an episode.
+type Chapter struct {
+	// StartSecs is the offset, in seconds, of the start of the chapter.
+	StartSecs float64 `json:"startSecs"`
+
+	// EndSecs is the offset, in seconds, of the end of the chapter. Null for the last chapter if we
+	// don't know how long the episode is.
+	EndSecs *float64 `json:"endSecs"`
+
+	Title string `json:"title"`
+
+	// URL is a web page related to this chapter, if there is one.
+	URL string `json:"url,omitempty"`
+
+	// ImageURL is the URL of the chapter's image. We serve these ourselves from the blob store, so
+	// this will be a /blobs/chapters/... URL.
+	ImageURL string `json:"imageUrl,omitempty"`
+
+	// TOC is false for chapters that should not be displayed in a table of contents (they're used
+	// just to change the image or URL at a certain time, for example).
+	TOC bool `json:"toc"`
+}
+
+// ChaptersSource records where we got an episode's chapters from, so that we know when we need to
+// process them again.
+type ChaptersSource struct {
+	// Source is the URL of the chapters file, or for chapters embedded in the feed, a hash of them.
+	// Empty if the episode has no chapters.
+	Source string
+
+	// ETag is the ETag of the chapters file the last time we fetched it, if the server gave us one.
+	ETag string
+
+	// Hash is the SHA-256 of the chapters file we last processed, so that we can tell whether it has
+	// changed even if the server doesn't give us an ETag.
+	Hash string
+}
+
+func (s *pgStore) LoadChaptersSource(ctx context.Context, episodeID int64) (*ChaptersSource, error) {
+	row := s.pool.QueryRow(ctx, "SELECT chapters_source, chapters_etag, chapters_hash FROM episodes WHERE id=$1", episodeID)
+	var source ChaptersSource
+	if err := row.Scan(&source.Source, &source.ETag, &source.Hash); err != nil {
+		return nil, fmt.Errorf("error scanning row: %w", err)
+	}
+	return &source, nil
+}
+
+func (s *pgStore) SaveChapters(ctx context.Context, episodeID int64, source *ChaptersSource, chapters []*Chapter) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM episode_chapters WHERE episode_id=$1", episodeID); err != nil {
+		return err
+	}
+
+	for i, c := range chapters {
+		sql := `INSERT INTO episode_chapters
+			(episode_id, chapter_index, start_secs, end_secs, title, url, image_url, toc)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+		if _, err := tx.Exec(ctx, sql, episodeID, i, c.StartSecs, c.EndSecs, c.Title, c.URL, c.ImageURL, c.TOC); err != nil {
+			return fmt.Errorf("error saving chapter: %w", err)
+		}
+	}
+
+	sql := "UPDATE episodes SET chapters_source=$1, chapters_etag=$2, chapters_hash=$3 WHERE id=$4"
+	if _, err := tx.Exec(ctx, sql, source.Source, source.ETag, source.Hash, episodeID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *pgStore) LoadChapters(ctx context.Context, episodeID int64) ([]*Chapter, error) {
+	sql := `SELECT start_secs, end_secs, title, url, image_url, toc
+		FROM episode_chapters
+		WHERE episode_id=$1
+		ORDER BY chapter_index`
+	rows, _ := s.pool.Query(ctx, sql, episodeID)
+	defer rows.Close()
+
+	var chapters []*Chapter
+	for rows.Next() {
+		var c Chapter
+		if err := rows.Scan(&c.StartSecs, &c.EndSecs, &c.Title, &c.URL, &c.ImageURL, &c.TOC); err != nil {
+			return nil, fmt.Errorf("error scanning chapter: %w", err)
+		}
+		chapters = append(chapters, &c)
+	}
+
+	return chapters, nil
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FeedURLChange is a record of a podcast's feed moving from one URL to another.
+type FeedURLChange struct {
+	OldURL    string
+	NewURL    string
+	Reason    string
+	ChangedAt time.Time
+}
+
+func (s *pgStore) UpdatePodcastFeedURL(ctx context.Context, p *Podcast, newURL, reason string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `INSERT INTO feed_url_history (podcast_id, old_url, new_url, reason, changed_at)
+		VALUES ($1, $2, $3, $4, $5)`
+	if _, err := tx.Exec(ctx, sql, p.ID, p.FeedURL, newURL, reason, time.Now()); err != nil {
+		return fmt.Errorf("error saving feed URL history: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE podcasts SET feed_url=$1 WHERE id=$2", newURL, p.ID); err != nil {
+		return fmt.Errorf("error updating feed URL: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	p.FeedURL = newURL
+	return nil
+}
+
+func (s *pgStore) LoadFeedURLHistory(ctx context.Context, podcastID int64) ([]*FeedURLChange, error) {
+	sql := `SELECT old_url, new_url, reason, changed_at
+		FROM feed_url_history
+		WHERE podcast_id=$1
+		ORDER BY changed_at DESC`
+	rows, _ := s.pool.Query(ctx, sql, podcastID)
+	defer rows.Close()
+
+	var changes []*FeedURLChange
+	for rows.Next() {
+		var change FeedURLChange
+		if err := rows.Scan(&change.OldURL, &change.NewURL, &change.Reason, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		changes = append(changes, &change)
+	}
+
+	return changes, nil
+}
+
+const (
+	// maxFeedFetches is the number of feed fetches we keep for each podcast. Older ones are deleted.
+	maxFeedFetches = 100
+)
+
+// FeedFetch is a record of a single fetch of a podcast's feed.
+type FeedFetch struct {
+	ID        int64
+	PodcastID int64
+	StartTime time.Time
+	EndTime   time.Time
+
+	// HTTPStatus is the status code of the response, or nil if we didn't get a response at all.
+	HTTPStatus *int
+
+	// Bytes is the number of bytes of the feed we read.
+	Bytes int64
+
+	// NumParsed is the number of episodes we found in the feed, NumUpdated is the number we actually
+	// saved.
+	NumParsed  int
+	NumUpdated int
+
+	// Error is the error that stopped the fetch, or nil if it was successful.
+	Error *string
+}
+
+// Duration returns how long the fetch took.
+func (f *FeedFetch) Duration() time.Duration {
+	return f.EndTime.Sub(f.StartTime)
+}
+
+func (s *pgStore) SaveFeedFetch(ctx context.Context, f *FeedFetch) error {
+	sql := `INSERT INTO feed_fetches
+		(podcast_id, start_time, end_time, http_status, bytes, num_parsed, num_updated, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id`
+	row := s.pool.QueryRow(ctx, sql, f.PodcastID, f.StartTime, f.EndTime, f.HTTPStatus, f.Bytes, f.NumParsed, f.NumUpdated, f.Error)
+	if err := row.Scan(&f.ID); err != nil {
+		return fmt.Errorf("error saving feed fetch: %w", err)
+	}
+
+	sql = `DELETE FROM feed_fetches
+		WHERE podcast_id=$1 AND id NOT IN (
+			SELECT id FROM feed_fetches WHERE podcast_id=$1 ORDER BY start_time DESC LIMIT $2)`
+	_, err := s.pool.Exec(ctx, sql, f.PodcastID, maxFeedFetches)
+	return err
+}
+
+func (s *pgStore) LoadFeedFetches(ctx context.Context, podcastID int64, limit int) ([]*FeedFetch, error) {
+	sql := `SELECT id, podcast_id, start_time, end_time, http_status, bytes, num_parsed, num_updated, error
+		FROM feed_fetches
+		WHERE podcast_id=$1
+		ORDER BY start_time DESC
+		LIMIT $2`
+	rows, _ := s.pool.Query(ctx, sql, podcastID, limit)
+	defer rows.Close()
+
+	var fetches []*FeedFetch
+	for rows.Next() {
+		var f FeedFetch
+		if err := rows.Scan(&f.ID, &f.PodcastID, &f.StartTime, &f.EndTime, &f.HTTPStatus, &f.Bytes, &f.NumParsed, &f.NumUpdated, &f.Error); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		fetches = append(fetches, &f)
+	}
+
+	return fetches, nil
+}
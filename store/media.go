@@ -0,0 +1,158 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// DefaultMediaCacheEpisodes is the MaxEpisodes of podcasts that we don't have any media cache
+// settings for.
+const DefaultMediaCacheEpisodes = 10
+
+// MediaCacheSettings controls whether we download a podcast's episode media into the blob store,
+// and how much of it we keep there.
+type MediaCacheSettings struct {
+	PodcastID int64
+
+	// Enabled is true if we should cache the podcast's media at all.
+	Enabled bool
+
+	// MaxEpisodes is the number of episodes we'll keep cached. We download the most recent ones, and
+	// evict any others, least recently used first. Must be at least one.
+	MaxEpisodes int
+
+	// MaxBytes is the maximum total size of the podcast's cached media. Zero means no limit.
+	MaxBytes int64
+}
+
+// EpisodeMedia is an episode's media that we have downloaded into the blob store.
+type EpisodeMedia struct {
+	EpisodeID int64
+
+	// PodcastID is the ID of the podcast the episode belongs to. It's not saved, just loaded from the
+	// episode.
+	PodcastID int64
+
+	// Size is the size of the file in bytes, and ContentType its MIME type.
+	Size        int64
+	ContentType string
+
+	// SHA256 is the hex-encoded SHA256 of the file, which we use as its ETag.
+	SHA256 string
+
+	// CachedAt is when we downloaded the file, and LastAccessedAt is (roughly) when a client last
+	// downloaded it from us.
+	CachedAt       time.Time
+	LastAccessedAt time.Time
+}
+
+func (s *pgStore) LoadMediaCacheSettings(ctx context.Context, podcastID int64) (*MediaCacheSettings, error) {
+	settings, err := s.loadMediaCacheSettings(ctx, "WHERE podcast_id=$1", podcastID)
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) == 0 {
+		return &MediaCacheSettings{PodcastID: podcastID, MaxEpisodes: DefaultMediaCacheEpisodes}, nil
+	}
+	return settings[0], nil
+}
+
+func (s *pgStore) LoadAllMediaCacheSettings(ctx context.Context) ([]*MediaCacheSettings, error) {
+	return s.loadMediaCacheSettings(ctx, "")
+}
+
+func (s *pgStore) loadMediaCacheSettings(ctx context.Context, where string, args ...interface{}) ([]*MediaCacheSettings, error) {
+	sql := "SELECT podcast_id, enabled, max_episodes, max_bytes FROM media_cache_settings " + where
+	rows, _ := s.pool.Query(ctx, sql, args...)
+	defer rows.Close()
+
+	var settings []*MediaCacheSettings
+	for rows.Next() {
+		var s MediaCacheSettings
+		if err := rows.Scan(&s.PodcastID, &s.Enabled, &s.MaxEpisodes, &s.MaxBytes); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		settings = append(settings, &s)
+	}
+	return settings, rows.Err()
+}
+
+func (s *pgStore) SaveMediaCacheSettings(ctx context.Context, settings *MediaCacheSettings) error {
+	sql := `INSERT INTO media_cache_settings (podcast_id, enabled, max_episodes, max_bytes)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (podcast_id) DO UPDATE SET
+		  enabled=$2, max_episodes=$3, max_bytes=$4`
+	if _, err := s.pool.Exec(ctx, sql, settings.PodcastID, settings.Enabled, settings.MaxEpisodes, settings.MaxBytes); err != nil {
+		return fmt.Errorf("error saving media cache settings: %w", err)
+	}
+	return nil
+}
+
+// episodeMediaColumns is the list of columns we select for an EpisodeMedia, in the order
+// scanEpisodeMedia expects them.
+const episodeMediaColumns = `m.episode_id, e.podcast_id, m.size, m.content_type, m.sha256, m.cached_at,
+	m.last_accessed_at`
+
+func scanEpisodeMedia(row pgx.Row) (*EpisodeMedia, error) {
+	var m EpisodeMedia
+	err := row.Scan(&m.EpisodeID, &m.PodcastID, &m.Size, &m.ContentType, &m.SHA256, &m.CachedAt, &m.LastAccessedAt)
+	return &m, err
+}
+
+// EpisodeMediaURL returns the URL we serve the given episode's cached media from.
+func EpisodeMediaURL(episodeID int64) string {
+	return fmt.Sprintf("/blobs/episodes/%d", episodeID)
+}
+
+func (s *pgStore) SaveEpisodeMedia(ctx context.Context, m *EpisodeMedia) error {
+	sql := `INSERT INTO episode_media (episode_id, size, content_type, sha256, cached_at, last_accessed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (episode_id) DO UPDATE SET
+		  size=$2, content_type=$3, sha256=$4, cached_at=$5, last_accessed_at=$6`
+	_, err := s.pool.Exec(ctx, sql, m.EpisodeID, m.Size, m.ContentType, m.SHA256, m.CachedAt, m.LastAccessedAt)
+	if err != nil {
+		return fmt.Errorf("error saving episode media: %w", err)
+	}
+	return nil
+}
+
+func (s *pgStore) LoadEpisodeMedia(ctx context.Context, episodeID int64) (*EpisodeMedia, error) {
+	media, err := s.loadEpisodeMedia(ctx, "WHERE m.episode_id=$1", episodeID)
+	if err != nil || len(media) == 0 {
+		return nil, err
+	}
+	return media[0], nil
+}
+
+func (s *pgStore) LoadAllEpisodeMedia(ctx context.Context) ([]*EpisodeMedia, error) {
+	return s.loadEpisodeMedia(ctx, "ORDER BY m.last_accessed_at DESC")
+}
+
+func (s *pgStore) loadEpisodeMedia(ctx context.Context, where string, args ...interface{}) ([]*EpisodeMedia, error) {
+	sql := "SELECT " + episodeMediaColumns + " FROM episode_media m INNER JOIN episodes e ON e.id = m.episode_id " + where
+	rows, _ := s.pool.Query(ctx, sql, args...)
+	defer rows.Close()
+
+	var media []*EpisodeMedia
+	for rows.Next() {
+		m, err := scanEpisodeMedia(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		media = append(media, m)
+	}
+	return media, rows.Err()
+}
+
+func (s *pgStore) TouchEpisodeMedia(ctx context.Context, episodeID int64, now time.Time) error {
+	_, err := s.pool.Exec(ctx, "UPDATE episode_media SET last_accessed_at=$1 WHERE episode_id=$2", now, episodeID)
+	return err
+}
+
+func (s *pgStore) DeleteEpisodeMedia(ctx context.Context, episodeID int64) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM episode_media WHERE episode_id=$1", episodeID)
+	return err
+}
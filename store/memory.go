@@ -0,0 +1,1276 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/podcreep/server/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memoryStore is a Backend that keeps everything in memory. It's meant for tests, so that handlers
+// and cron jobs can be exercised without a database. It behaves the same as the PostgreSQL backend,
+// including things like deletes cascading and episodes being merged when their GUIDs change. The
+// one exception is SearchTranscripts, which does a simple word match rather than a proper
+// full-text search.
+type memoryStore struct {
+	mu sync.Mutex
+
+	// lastID is the last ID we handed out for each kind of thing, like a BIGSERIAL column.
+	lastID map[string]int64
+
+	accounts      map[int64]*Account
+	subscriptions map[int64]map[int64]bool // account ID -> podcast IDs
+
+	podcasts           map[int64]*memoryPodcast
+	feedFetches        []*FeedFetch
+	webSubSubscription map[int64]*WebSubSubscription
+	mediaCacheSettings map[int64]*MediaCacheSettings
+
+	episodes     map[int64]*memoryEpisode
+	episodeMedia map[int64]*EpisodeMedia
+	progress     map[progressKey]*EpisodeProgress
+
+	cronJobs map[int64]*CronJob
+}
+
+// memoryPodcast is a podcast along with the things that PostgreSQL keeps in other tables.
+type memoryPodcast struct {
+	podcast        Podcast
+	persons        []*Person
+	funding        []*Funding
+	feedURLHistory []*FeedURLChange
+}
+
+// memoryEpisode is an episode along with the things that PostgreSQL keeps in other tables (or in
+// columns we don't load into the Episode struct). The episode's Transcripts, Persons, Soundbites
+// and Enclosures are stored on the Episode itself.
+type memoryEpisode struct {
+	episode          Episode
+	durationProbedAt *time.Time
+	chaptersSource   ChaptersSource
+	chapters         []*Chapter
+	transcriptSource string
+	transcript       []*TranscriptSegment
+}
+
+type progressKey struct {
+	accountID int64
+	episodeID int64
+}
+
+// NewMemoryBackend creates a new, empty Backend that keeps everything in memory.
+func NewMemoryBackend() Backend {
+	return &memoryStore{
+		lastID:             make(map[string]int64),
+		accounts:           make(map[int64]*Account),
+		subscriptions:      make(map[int64]map[int64]bool),
+		podcasts:           make(map[int64]*memoryPodcast),
+		webSubSubscription: make(map[int64]*WebSubSubscription),
+		mediaCacheSettings: make(map[int64]*MediaCacheSettings),
+		episodes:           make(map[int64]*memoryEpisode),
+		episodeMedia:       make(map[int64]*EpisodeMedia),
+		progress:           make(map[progressKey]*EpisodeProgress),
+		cronJobs:           make(map[int64]*CronJob),
+	}
+}
+
+func (s *memoryStore) nextID(kind string) int64 {
+	s.lastID[kind]++
+	return s.lastID[kind]
+}
+
+// errNoRows returns the same error the PostgreSQL backend returns when something doesn't exist.
+func errNoRows() error {
+	return fmt.Errorf("error scanning row: %w", pgx.ErrNoRows)
+}
+
+func (s *memoryStore) SaveAccount(ctx context.Context, username, password string) (*Account, error) {
+	// We don't need the hash to be slow to crack in memory, just to work the same.
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %w", err)
+	}
+
+	cookie, err := util.CreateCookie()
+	if err != nil {
+		return nil, fmt.Errorf("error creating cookie: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acct := &Account{
+		ID:           s.nextID("accounts"),
+		Cookie:       cookie,
+		Username:     username,
+		PasswordHash: hash,
+	}
+	s.accounts[acct.ID] = acct
+	c := *acct
+	return &c, nil
+}
+
+// findAccount returns a copy of the account with the lowest ID that matches the given function.
+func (s *memoryStore) findAccount(match func(acct *Account) bool) *Account {
+	var found *Account
+	for _, acct := range s.accounts {
+		if match(acct) && (found == nil || acct.ID < found.ID) {
+			found = acct
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	c := *found
+	return &c
+}
+
+func (s *memoryStore) VerifyUsernameExists(ctx context.Context, username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acct := s.findAccount(func(acct *Account) bool { return acct.Username == username })
+	return acct != nil, nil
+}
+
+func (s *memoryStore) LoadAccountByUsername(ctx context.Context, username, password string) (*Account, error) {
+	s.mu.Lock()
+	acct := s.findAccount(func(acct *Account) bool { return acct.Username == username })
+	s.mu.Unlock()
+	if acct == nil {
+		return nil, errNoRows()
+	}
+	return checkPassword(acct, password), nil
+}
+
+func (s *memoryStore) LoadAccountByCookie(ctx context.Context, cookie string) (*Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acct := s.findAccount(func(acct *Account) bool { return acct.Cookie == cookie })
+	if acct == nil {
+		return nil, errNoRows()
+	}
+	return acct, nil
+}
+
+func (s *memoryStore) SaveSubscription(ctx context.Context, acct *Account, podcastID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accounts[acct.ID] == nil || s.podcasts[podcastID] == nil {
+		return fmt.Errorf("no such account or podcast: %d, %d", acct.ID, podcastID)
+	}
+	if s.subscriptions[acct.ID][podcastID] {
+		return fmt.Errorf("account %d is already subscribed to podcast %d", acct.ID, podcastID)
+	}
+	if s.subscriptions[acct.ID] == nil {
+		s.subscriptions[acct.ID] = make(map[int64]bool)
+	}
+	s.subscriptions[acct.ID][podcastID] = true
+	return nil
+}
+
+func (s *memoryStore) DeleteSubscription(ctx context.Context, acct *Account, podcastID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.subscriptions[acct.ID], podcastID)
+	return nil
+}
+
+func (s *memoryStore) GetSubscriptions(ctx context.Context, acct *Account) ([]*Podcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var podcasts []*Podcast
+	for _, p := range s.sortedPodcasts() {
+		if s.subscriptions[acct.ID][p.podcast.ID] {
+			podcasts = append(podcasts, copyPodcast(&p.podcast))
+		}
+	}
+	return podcasts, nil
+}
+
+func (s *memoryStore) LoadSubscriptionIDs(ctx context.Context, acct *Account) (map[int64]struct{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make(map[int64]struct{})
+	for id := range s.subscriptions[acct.ID] {
+		ids[id] = struct{}{}
+	}
+	return ids, nil
+}
+
+func (s *memoryStore) IsSubscribed(ctx context.Context, acct *Account, podcastID int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.subscriptions[acct.ID][podcastID]
+}
+
+// copyPodcast returns a copy of the given podcast, without any of the things that are not stored
+// in the podcasts table.
+func copyPodcast(p *Podcast) *Podcast {
+	c := *p
+	if p.Palette != nil {
+		palette := *p.Palette
+		c.Palette = &palette
+	}
+	c.Persons = nil
+	c.Funding = nil
+	c.Episodes = nil
+	return &c
+}
+
+// sortedPodcasts returns all of the podcasts, in ID order.
+func (s *memoryStore) sortedPodcasts() []*memoryPodcast {
+	var podcasts []*memoryPodcast
+	for _, p := range s.podcasts {
+		podcasts = append(podcasts, p)
+	}
+	sort.Slice(podcasts, func(i, j int) bool {
+		return podcasts[i].podcast.ID < podcasts[j].podcast.ID
+	})
+	return podcasts
+}
+
+func (s *memoryStore) SavePodcast(ctx context.Context, p *Podcast) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.ID == 0 {
+		p.ID = s.nextID("podcasts")
+		saved := copyPodcast(p)
+		saved.LastFetchTime = time.Time{}
+		saved.LastAttemptTime = time.Time{}
+		s.podcasts[p.ID] = &memoryPodcast{podcast: *saved}
+	} else if existing := s.podcasts[p.ID]; existing != nil {
+		existing.podcast = *copyPodcast(p)
+	}
+	return p.ID, nil
+}
+
+func (s *memoryStore) LoadPodcast(ctx context.Context, podcastID int64) (*Podcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p := s.podcasts[podcastID]
+	if p == nil {
+		return nil, errNoRows()
+	}
+	return copyPodcast(&p.podcast), nil
+}
+
+func (s *memoryStore) LoadPodcastByDiscoverId(ctx context.Context, discoverID string) (*Podcast, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.sortedPodcasts() {
+		if p.podcast.DiscoverID == discoverID {
+			return copyPodcast(&p.podcast), nil
+		}
+	}
+	return nil, errNoRows()
+}
+
+func (s *memoryStore) LoadPodcasts(ctx context.Context, page Page) ([]*Podcast, string, error) {
+	after, err := page.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var podcasts []*Podcast
+	for _, p := range s.sortedPodcasts() {
+		if after != nil && p.podcast.ID <= after.ID {
+			continue
+		}
+		podcasts = append(podcasts, copyPodcast(&p.podcast))
+	}
+	podcasts, next := podcastsPage(page, podcasts)
+	return podcasts, next, nil
+}
+
+func (s *memoryStore) DeletePodcast(ctx context.Context, podcast *Podcast) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Everything that references the podcast is deleted along with it, just like ON DELETE CASCADE.
+	for id, ep := range s.episodes {
+		if ep.episode.PodcastID == podcast.ID {
+			s.deleteEpisode(id)
+		}
+	}
+	for _, podcasts := range s.subscriptions {
+		delete(podcasts, podcast.ID)
+	}
+	var fetches []*FeedFetch
+	for _, f := range s.feedFetches {
+		if f.PodcastID != podcast.ID {
+			fetches = append(fetches, f)
+		}
+	}
+	s.feedFetches = fetches
+	delete(s.webSubSubscription, podcast.ID)
+	delete(s.mediaCacheSettings, podcast.ID)
+	delete(s.podcasts, podcast.ID)
+	return nil
+}
+
+func (s *memoryStore) SavePodcastMetadata(ctx context.Context, p *Podcast) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.podcasts[p.ID]
+	if existing == nil {
+		if len(p.Persons) == 0 && len(p.Funding) == 0 {
+			return nil
+		}
+		return fmt.Errorf("error saving metadata: no such podcast: %d", p.ID)
+	}
+	existing.persons = copyPersons(p.Persons)
+	existing.funding = nil
+	for _, funding := range p.Funding {
+		c := *funding
+		existing.funding = append(existing.funding, &c)
+	}
+	return nil
+}
+
+func (s *memoryStore) LoadPodcastMetadata(ctx context.Context, p *Podcast) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.Persons = nil
+	p.Funding = nil
+	if existing := s.podcasts[p.ID]; existing != nil {
+		p.Persons = copyPersons(existing.persons)
+		for _, funding := range existing.funding {
+			c := *funding
+			p.Funding = append(p.Funding, &c)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdatePodcastFeedURL(ctx context.Context, p *Podcast, newURL, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := s.podcasts[p.ID]
+	if existing == nil {
+		return fmt.Errorf("error saving feed URL history: no such podcast: %d", p.ID)
+	}
+	existing.feedURLHistory = append(existing.feedURLHistory, &FeedURLChange{
+		OldURL:    p.FeedURL,
+		NewURL:    newURL,
+		Reason:    reason,
+		ChangedAt: time.Now(),
+	})
+	existing.podcast.FeedURL = newURL
+	p.FeedURL = newURL
+	return nil
+}
+
+func (s *memoryStore) LoadFeedURLHistory(ctx context.Context, podcastID int64) ([]*FeedURLChange, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var changes []*FeedURLChange
+	if existing := s.podcasts[podcastID]; existing != nil {
+		for i := len(existing.feedURLHistory) - 1; i >= 0; i-- {
+			c := *existing.feedURLHistory[i]
+			changes = append(changes, &c)
+		}
+	}
+	return changes, nil
+}
+
+func (s *memoryStore) SaveFeedFetch(ctx context.Context, f *FeedFetch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.podcasts[f.PodcastID] == nil {
+		return fmt.Errorf("error saving feed fetch: no such podcast: %d", f.PodcastID)
+	}
+	f.ID = s.nextID("feed_fetches")
+	c := *f
+	s.feedFetches = append(s.feedFetches, &c)
+
+	// Only keep the most recent maxFeedFetches of the podcast.
+	sort.SliceStable(s.feedFetches, func(i, j int) bool {
+		return s.feedFetches[i].StartTime.After(s.feedFetches[j].StartTime)
+	})
+	var fetches []*FeedFetch
+	num := 0
+	for _, fetch := range s.feedFetches {
+		if fetch.PodcastID == f.PodcastID {
+			num++
+			if num > maxFeedFetches {
+				continue
+			}
+		}
+		fetches = append(fetches, fetch)
+	}
+	s.feedFetches = fetches
+	return nil
+}
+
+func (s *memoryStore) LoadFeedFetches(ctx context.Context, podcastID int64, limit int) ([]*FeedFetch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// feedFetches is always sorted most recent first.
+	var fetches []*FeedFetch
+	for _, f := range s.feedFetches {
+		if len(fetches) >= limit {
+			break
+		}
+		if f.PodcastID == podcastID {
+			c := *f
+			fetches = append(fetches, &c)
+		}
+	}
+	return fetches, nil
+}
+
+func (s *memoryStore) SaveWebSubSubscription(ctx context.Context, sub *WebSubSubscription) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.podcasts[sub.PodcastID] == nil {
+		return fmt.Errorf("error saving websub subscription: no such podcast: %d", sub.PodcastID)
+	}
+	c := *sub
+	s.webSubSubscription[sub.PodcastID] = &c
+	return nil
+}
+
+func (s *memoryStore) LoadWebSubSubscription(ctx context.Context, podcastID int64) (*WebSubSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sub := s.webSubSubscription[podcastID]
+	if sub == nil {
+		return nil, nil
+	}
+	c := *sub
+	return &c, nil
+}
+
+func (s *memoryStore) LoadWebSubSubscriptions(ctx context.Context) ([]*WebSubSubscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subs []*WebSubSubscription
+	for _, sub := range s.webSubSubscription {
+		c := *sub
+		subs = append(subs, &c)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].PodcastID < subs[j].PodcastID
+	})
+	return subs, nil
+}
+
+func (s *memoryStore) DeleteWebSubSubscription(ctx context.Context, podcastID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.webSubSubscription, podcastID)
+	return nil
+}
+
+func (s *memoryStore) LoadMediaCacheSettings(ctx context.Context, podcastID int64) (*MediaCacheSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := s.mediaCacheSettings[podcastID]
+	if settings == nil {
+		return &MediaCacheSettings{PodcastID: podcastID, MaxEpisodes: DefaultMediaCacheEpisodes}, nil
+	}
+	c := *settings
+	return &c, nil
+}
+
+func (s *memoryStore) LoadAllMediaCacheSettings(ctx context.Context) ([]*MediaCacheSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []*MediaCacheSettings
+	for _, settings := range s.mediaCacheSettings {
+		c := *settings
+		all = append(all, &c)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].PodcastID < all[j].PodcastID
+	})
+	return all, nil
+}
+
+func (s *memoryStore) SaveMediaCacheSettings(ctx context.Context, settings *MediaCacheSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.podcasts[settings.PodcastID] == nil {
+		return fmt.Errorf("error saving media cache settings: no such podcast: %d", settings.PodcastID)
+	}
+	c := *settings
+	s.mediaCacheSettings[settings.PodcastID] = &c
+	return nil
+}
+
+// copyEpisode returns a copy of the given episode, with just the fields that are loaded along with
+// the episode itself (that is, not the metadata or progress).
+func copyEpisode(ep *Episode) *Episode {
+	c := *ep
+	c.Transcripts = nil
+	c.Persons = nil
+	c.Soundbites = nil
+	c.Enclosures = nil
+	c.CachedMediaURL = ""
+	c.DescriptionPolicy = 0
+	c.Position = nil
+	c.IsComplete = nil
+	c.LastListenTime = nil
+	return &c
+}
+
+func copyPersons(persons []*Person) []*Person {
+	var copies []*Person
+	for _, person := range persons {
+		c := *person
+		copies = append(copies, &c)
+	}
+	return copies
+}
+
+// sortedEpisodes returns the episodes that match the given function, newest first.
+func (s *memoryStore) sortedEpisodes(match func(ep *memoryEpisode) bool) []*memoryEpisode {
+	var episodes []*memoryEpisode
+	for _, ep := range s.episodes {
+		if match(ep) {
+			episodes = append(episodes, ep)
+		}
+	}
+	sort.Slice(episodes, func(i, j int) bool {
+		a, b := &episodes[i].episode, &episodes[j].episode
+		if !a.PubDate.Equal(b.PubDate) {
+			return a.PubDate.After(b.PubDate)
+		}
+		return a.ID > b.ID
+	})
+	return episodes
+}
+
+// findEpisodeByGUID returns the episode of the given podcast with the given GUID, or nil.
+func (s *memoryStore) findEpisodeByGUID(podcastID int64, guid string) *memoryEpisode {
+	for _, ep := range s.episodes {
+		if ep.episode.PodcastID == podcastID && ep.episode.GUID == guid {
+			return ep
+		}
+	}
+	return nil
+}
+
+// isSameEpisode returns true if the two episodes look like they're the same, apart from the GUID.
+// It's the same check that findRenamedEpisode and ReconcileEpisodes do.
+func isSameEpisode(a, b *Episode) bool {
+	return (a.MediaURL != "" && a.MediaURL == b.MediaURL) || (a.Title == b.Title && a.PubDate.Equal(b.PubDate))
+}
+
+// deleteEpisode deletes the given episode and everything that references it.
+func (s *memoryStore) deleteEpisode(episodeID int64) {
+	for key := range s.progress {
+		if key.episodeID == episodeID {
+			delete(s.progress, key)
+		}
+	}
+	delete(s.episodeMedia, episodeID)
+	delete(s.episodes, episodeID)
+}
+
+// mergeEpisodes is the same as the mergeEpisodes of the PostgreSQL backend.
+func (s *memoryStore) mergeEpisodes(fromID, toID int64) {
+	log.Printf(" - merging episode %d into %d", fromID, toID)
+
+	for key, from := range s.progress {
+		if key.episodeID != fromID {
+			continue
+		}
+		toKey := progressKey{accountID: key.accountID, episodeID: toID}
+		if to := s.progress[toKey]; to == nil || from.LastUpdated.After(to.LastUpdated) {
+			from.EpisodeID = toID
+			s.progress[toKey] = from
+		}
+		delete(s.progress, key)
+	}
+	s.deleteEpisode(fromID)
+}
+
+func (s *memoryStore) SaveEpisode(ctx context.Context, p *Podcast, ep *Episode, currentGUIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.podcasts[p.ID] == nil {
+		return fmt.Errorf("no such podcast: %d", p.ID)
+	}
+
+	existing := s.findEpisodeByGUID(p.ID, ep.GUID)
+	if existing == nil && currentGUIDs != nil {
+		// Check whether it's actually an existing episode whose GUID has been changed. If the old GUID
+		// is still in the feed, it's a different episode that just looks the same.
+		current := make(map[string]bool)
+		for _, guid := range currentGUIDs {
+			current[guid] = true
+		}
+		var renamed []*memoryEpisode
+		for _, other := range s.episodes {
+			if other.episode.PodcastID == p.ID && !current[other.episode.GUID] && isSameEpisode(&other.episode, ep) {
+				renamed = append(renamed, other)
+			}
+		}
+		if len(renamed) == 1 {
+			log.Printf(" - episode %d has a new GUID: %s", renamed[0].episode.ID, ep.GUID)
+			existing = renamed[0]
+			existing.episode.GUID = ep.GUID
+		}
+	}
+
+	saved := *ep
+	saved.PodcastID = p.ID
+	saved.RemovedAt = nil
+	saved.Transcripts = nil
+	for _, t := range ep.Transcripts {
+		c := *t
+		saved.Transcripts = append(saved.Transcripts, &c)
+	}
+	saved.Persons = copyPersons(ep.Persons)
+	saved.Soundbites = nil
+	for _, sb := range ep.Soundbites {
+		c := *sb
+		saved.Soundbites = append(saved.Soundbites, &c)
+	}
+	sort.SliceStable(saved.Soundbites, func(i, j int) bool {
+		return saved.Soundbites[i].StartSecs < saved.Soundbites[j].StartSecs
+	})
+	saved.Enclosures = nil
+	for _, e := range ep.Enclosures {
+		c := *e
+		saved.Enclosures = append(saved.Enclosures, &c)
+	}
+
+	if existing == nil {
+		saved.ID = s.nextID("episodes")
+		existing = &memoryEpisode{}
+		s.episodes[saved.ID] = existing
+	} else {
+		saved.ID = existing.episode.ID
+		if saved.DurationSecs == nil {
+			saved.DurationSecs = existing.episode.DurationSecs
+		}
+	}
+	existing.episode = saved
+
+	if ep.ID != 0 && ep.ID != saved.ID && s.episodes[ep.ID] != nil {
+		// The episode we were given has been superseded by the one with this GUID, so merge it in.
+		s.mergeEpisodes(ep.ID, saved.ID)
+	}
+	ep.ID = saved.ID
+	ep.PodcastID = p.ID
+	return nil
+}
+
+func (s *memoryStore) LoadEpisode(ctx context.Context, p *Podcast, episodeID int64) (*Episode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ep := s.episodes[episodeID]
+	if ep == nil {
+		return nil, errNoRows()
+	}
+	return copyEpisode(&ep.episode), nil
+}
+
+func (s *memoryStore) LoadEpisodes(ctx context.Context, podcastID int64, limit int) ([]*Episode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var episodes []*Episode
+	for _, ep := range s.sortedEpisodes(func(ep *memoryEpisode) bool { return ep.episode.PodcastID == podcastID }) {
+		if limit > 0 && len(episodes) >= limit {
+			break
+		}
+		episodes = append(episodes, copyEpisode(&ep.episode))
+	}
+	return episodes, nil
+}
+
+func (s *memoryStore) LoadEpisodeMetadata(ctx context.Context, episodes []*Episode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ep := range episodes {
+		ep.Transcripts = nil
+		ep.Persons = nil
+		ep.Soundbites = nil
+		ep.Enclosures = nil
+		ep.CachedMediaURL = ""
+
+		saved := s.episodes[ep.ID]
+		if saved == nil {
+			continue
+		}
+		for _, t := range saved.episode.Transcripts {
+			c := *t
+			ep.Transcripts = append(ep.Transcripts, &c)
+		}
+		ep.Persons = copyPersons(saved.episode.Persons)
+		for _, sb := range saved.episode.Soundbites {
+			c := *sb
+			ep.Soundbites = append(ep.Soundbites, &c)
+		}
+		for _, e := range saved.episode.Enclosures {
+			c := *e
+			ep.Enclosures = append(ep.Enclosures, &c)
+		}
+		if s.episodeMedia[ep.ID] != nil {
+			ep.CachedMediaURL = EpisodeMediaURL(ep.ID)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) LoadEpisodeGUIDs(ctx context.Context, podcastID int64) (map[string]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	guids := make(map[string]bool)
+	for _, ep := range s.episodes {
+		if ep.episode.PodcastID == podcastID {
+			guids[ep.episode.GUID] = true
+		}
+	}
+	return guids, nil
+}
+
+func (s *memoryStore) LoadEpisodesWithoutDuration(ctx context.Context, probedBefore time.Time, limit int) ([]*Episode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	match := func(ep *memoryEpisode) bool {
+		return ep.episode.DurationSecs == nil && ep.episode.MediaURL != "" && ep.episode.RemovedAt == nil &&
+			(ep.durationProbedAt == nil || ep.durationProbedAt.Before(probedBefore))
+	}
+	var episodes []*Episode
+	for _, ep := range s.sortedEpisodes(match) {
+		if len(episodes) >= limit {
+			break
+		}
+		episodes = append(episodes, copyEpisode(&ep.episode))
+	}
+	return episodes, nil
+}
+
+func (s *memoryStore) SaveEpisodeDuration(ctx context.Context, ep *Episode, probedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if saved := s.episodes[ep.ID]; saved != nil {
+		saved.episode.DurationSecs = ep.DurationSecs
+		saved.durationProbedAt = &probedAt
+	}
+	return nil
+}
+
+func (s *memoryStore) LoadEpisodesByDescriptionPolicy(ctx context.Context, version, limit int) ([]*Episode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var episodes []*Episode
+	for _, ep := range s.episodes {
+		if ep.episode.DescriptionPolicy < version {
+			episodes = append(episodes, &Episode{
+				ID:              ep.episode.ID,
+				Description:     ep.episode.Description,
+				DescriptionHTML: ep.episode.DescriptionHTML,
+			})
+		}
+	}
+	sort.Slice(episodes, func(i, j int) bool {
+		return episodes[i].ID < episodes[j].ID
+	})
+	if len(episodes) > limit {
+		episodes = episodes[:limit]
+	}
+	return episodes, nil
+}
+
+func (s *memoryStore) UpdateEpisodeDescription(ctx context.Context, ep *Episode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if saved := s.episodes[ep.ID]; saved != nil {
+		saved.episode.Description = ep.Description
+		saved.episode.DescriptionPolicy = ep.DescriptionPolicy
+	}
+	return nil
+}
+
+func (s *memoryStore) ReconcileEpisodes(ctx context.Context, podcastID int64, currentGUIDs []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make(map[string]bool)
+	for _, guid := range currentGUIDs {
+		current[guid] = true
+	}
+
+	// Only merge an old episode if it matches exactly one current episode, otherwise we can't be
+	// sure which one it is.
+	matches := make(map[int64][]int64)
+	for _, old := range s.episodes {
+		if old.episode.PodcastID != podcastID || current[old.episode.GUID] {
+			continue
+		}
+		for _, cur := range s.episodes {
+			if cur.episode.PodcastID == podcastID && cur.episode.ID != old.episode.ID &&
+				current[cur.episode.GUID] && isSameEpisode(&cur.episode, &old.episode) {
+				matches[old.episode.ID] = append(matches[old.episode.ID], cur.episode.ID)
+			}
+		}
+	}
+
+	numMerged := 0
+	for oldID, curIDs := range matches {
+		if len(curIDs) != 1 {
+			continue
+		}
+		s.mergeEpisodes(oldID, curIDs[0])
+		numMerged++
+	}
+	return numMerged, nil
+}
+
+func (s *memoryStore) MarkEpisodesRemoved(ctx context.Context, podcastID int64, currentGUIDs []string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current := make(map[string]bool)
+	for _, guid := range currentGUIDs {
+		current[guid] = true
+	}
+
+	now := time.Now()
+	var num int64
+	for _, ep := range s.episodes {
+		if ep.episode.PodcastID == podcastID && ep.episode.RemovedAt == nil && !current[ep.episode.GUID] {
+			ep.episode.RemovedAt = &now
+			num++
+		}
+	}
+	return num, nil
+}
+
+func (s *memoryStore) PurgeRemovedEpisodes(ctx context.Context, podcastID int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hasProgress := make(map[int64]bool)
+	for key := range s.progress {
+		hasProgress[key.episodeID] = true
+	}
+
+	var num int64
+	for id, ep := range s.episodes {
+		if ep.episode.PodcastID == podcastID && ep.episode.RemovedAt != nil && !hasProgress[id] {
+			s.deleteEpisode(id)
+			num++
+		}
+	}
+	return num, nil
+}
+
+func (s *memoryStore) LoadChaptersSource(ctx context.Context, episodeID int64) (*ChaptersSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ep := s.episodes[episodeID]
+	if ep == nil {
+		return nil, errNoRows()
+	}
+	source := ep.chaptersSource
+	return &source, nil
+}
+
+func (s *memoryStore) SaveChapters(ctx context.Context, episodeID int64, source *ChaptersSource, chapters []*Chapter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ep := s.episodes[episodeID]
+	if ep == nil {
+		if len(chapters) == 0 {
+			return nil
+		}
+		return fmt.Errorf("error saving chapter: no such episode: %d", episodeID)
+	}
+	ep.chaptersSource = *source
+	ep.chapters = nil
+	for _, chapter := range chapters {
+		c := *chapter
+		ep.chapters = append(ep.chapters, &c)
+	}
+	return nil
+}
+
+func (s *memoryStore) LoadChapters(ctx context.Context, episodeID int64) ([]*Chapter, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var chapters []*Chapter
+	if ep := s.episodes[episodeID]; ep != nil {
+		for _, chapter := range ep.chapters {
+			c := *chapter
+			chapters = append(chapters, &c)
+		}
+	}
+	return chapters, nil
+}
+
+func (s *memoryStore) LoadTranscriptSource(ctx context.Context, episodeID int64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ep := s.episodes[episodeID]
+	if ep == nil {
+		return "", errNoRows()
+	}
+	return ep.transcriptSource, nil
+}
+
+func (s *memoryStore) SaveTranscript(ctx context.Context, episodeID int64, source string, segments []*TranscriptSegment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ep := s.episodes[episodeID]
+	if ep == nil {
+		if len(segments) == 0 {
+			return nil
+		}
+		return fmt.Errorf("error saving transcript segment: no such episode: %d", episodeID)
+	}
+	ep.transcriptSource = source
+	ep.transcript = nil
+	for _, seg := range segments {
+		c := *seg
+		ep.transcript = append(ep.transcript, &c)
+	}
+	return nil
+}
+
+// SearchTranscripts finds segments that contain all of the words in the query (ignoring case),
+// and none of the words prefixed with "-". Quoted phrases are matched as a whole. Unlike the
+// PostgreSQL backend, there's no stemming, and the snippet is the whole segment.
+func (s *memoryStore) SearchTranscripts(ctx context.Context, query string, limit int) ([]*TranscriptSearchResult, error) {
+	include, exclude := parseSearchQuery(query)
+	if len(include) == 0 {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []*TranscriptSearchResult
+	numHits := 0
+	for _, ep := range s.sortedEpisodes(func(ep *memoryEpisode) bool { return len(ep.transcript) > 0 }) {
+		var result *TranscriptSearchResult
+		segments := append([]*TranscriptSegment(nil), ep.transcript...)
+		sort.SliceStable(segments, func(i, j int) bool {
+			return segments[i].StartSecs < segments[j].StartSecs
+		})
+		for _, seg := range segments {
+			if numHits >= limit {
+				return results, nil
+			}
+			body := strings.ToLower(seg.Body)
+			if !containsAll(body, include) || containsAny(body, exclude) {
+				continue
+			}
+
+			if result == nil {
+				result = &TranscriptSearchResult{Episode: copyEpisode(&ep.episode)}
+				results = append(results, result)
+			}
+			result.Hits = append(result.Hits, &TranscriptHit{
+				StartSecs: seg.StartSecs,
+				EndSecs:   seg.EndSecs,
+				Snippet:   highlight(seg.Body, include),
+			})
+			numHits++
+		}
+	}
+	return results, nil
+}
+
+func (s *memoryStore) SaveEpisodeMedia(ctx context.Context, m *EpisodeMedia) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.episodes[m.EpisodeID] == nil {
+		return fmt.Errorf("error saving episode media: no such episode: %d", m.EpisodeID)
+	}
+	c := *m
+	s.episodeMedia[m.EpisodeID] = &c
+	return nil
+}
+
+// loadEpisodeMedia returns a copy of the given media, with the PodcastID filled in.
+func (s *memoryStore) loadEpisodeMedia(m *EpisodeMedia) *EpisodeMedia {
+	c := *m
+	c.PodcastID = s.episodes[m.EpisodeID].episode.PodcastID
+	return &c
+}
+
+func (s *memoryStore) LoadEpisodeMedia(ctx context.Context, episodeID int64) (*EpisodeMedia, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := s.episodeMedia[episodeID]
+	if m == nil {
+		return nil, nil
+	}
+	return s.loadEpisodeMedia(m), nil
+}
+
+func (s *memoryStore) LoadAllEpisodeMedia(ctx context.Context) ([]*EpisodeMedia, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var media []*EpisodeMedia
+	for _, m := range s.episodeMedia {
+		media = append(media, s.loadEpisodeMedia(m))
+	}
+	sort.Slice(media, func(i, j int) bool {
+		return media[i].LastAccessedAt.After(media[j].LastAccessedAt)
+	})
+	return media, nil
+}
+
+func (s *memoryStore) TouchEpisodeMedia(ctx context.Context, episodeID int64, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m := s.episodeMedia[episodeID]; m != nil {
+		m.LastAccessedAt = now
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteEpisodeMedia(ctx context.Context, episodeID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.episodeMedia, episodeID)
+	return nil
+}
+
+func (s *memoryStore) SaveEpisodeProgress(ctx context.Context, progress *EpisodeProgress) error {
+	now := time.Now()
+	if progress.LastUpdated.After(now) {
+		progress.LastUpdated = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accounts[progress.AccountID] == nil || s.episodes[progress.EpisodeID] == nil {
+		return fmt.Errorf("no such account or episode: %d, %d", progress.AccountID, progress.EpisodeID)
+	}
+	key := progressKey{accountID: progress.AccountID, episodeID: progress.EpisodeID}
+	existing := s.progress[key]
+	if existing == nil {
+		existing = &EpisodeProgress{AccountID: progress.AccountID, EpisodeID: progress.EpisodeID}
+		s.progress[key] = existing
+	}
+	existing.PositionSecs = progress.PositionSecs
+	existing.LastUpdated = progress.LastUpdated
+	return nil
+}
+
+// episodeWithProgress returns a copy of the given episode, with the given account's progress.
+func (s *memoryStore) episodeWithProgress(ep *memoryEpisode, acct *Account) *Episode {
+	c := copyEpisode(&ep.episode)
+	if progress := s.progress[progressKey{accountID: acct.ID, episodeID: ep.episode.ID}]; progress != nil {
+		position := progress.PositionSecs
+		complete := progress.EpisodeComplete
+		lastUpdated := progress.LastUpdated
+		c.Position = &position
+		c.IsComplete = &complete
+		c.LastListenTime = &lastUpdated
+	}
+	return c
+}
+
+func (s *memoryStore) LoadEpisodesForSubscription(ctx context.Context, acct *Account, p *Podcast, page Page) ([]*Episode, string, error) {
+	after, err := page.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Episodes are sorted newest first, so the ones after the cursor are the ones that are older
+	// than it (or the same age, with a lower ID).
+	match := func(ep *memoryEpisode) bool {
+		if ep.episode.PodcastID != p.ID {
+			return false
+		}
+		if after == nil {
+			return true
+		}
+		return ep.episode.PubDate.Before(after.PubDate) ||
+			(ep.episode.PubDate.Equal(after.PubDate) && ep.episode.ID < after.ID)
+	}
+
+	var episodes []*Episode
+	for _, ep := range s.sortedEpisodes(match) {
+		episodes = append(episodes, s.episodeWithProgress(ep, acct))
+	}
+	episodes, next := episodesPage(page, episodes)
+	return episodes, next, nil
+}
+
+func (s *memoryStore) LoadEpisodesNewAndInProgress(ctx context.Context, acct *Account, numDays int) (newEpisodes []*Episode, inProgress []*Episode, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Hour * 24 * time.Duration(numDays))
+	subscribed := func(ep *memoryEpisode) bool { return s.subscriptions[acct.ID][ep.episode.PodcastID] }
+	for _, memEp := range s.sortedEpisodes(subscribed) {
+		ep := s.episodeWithProgress(memEp, acct)
+		if ep.Position == nil {
+			if ep.PubDate.After(cutoff) && ep.RemovedAt == nil {
+				newEpisodes = append(newEpisodes, ep)
+			}
+		} else {
+			inProgress = append(inProgress, ep)
+		}
+	}
+	return newEpisodes, inProgress, nil
+}
+
+func (s *memoryStore) GetMostRecentPlaybackState(ctx context.Context, acct *Account) (*Episode, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var latest *EpisodeProgress
+	for key, progress := range s.progress {
+		if key.accountID != acct.ID || !s.subscriptions[acct.ID][s.episodes[key.episodeID].episode.PodcastID] {
+			continue
+		}
+		if latest == nil || progress.LastUpdated.After(latest.LastUpdated) {
+			latest = progress
+		}
+	}
+	if latest == nil {
+		return nil, pgx.ErrNoRows
+	}
+	return s.episodeWithProgress(s.episodes[latest.EpisodeID], acct), nil
+}
+
+func (s *memoryStore) LoadCrobJobs(ctx context.Context) ([]*CronJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedCronJobs(func(job *CronJob) bool { return true }), nil
+}
+
+// sortedCronJobs returns copies of the cron jobs that match the given function, in ID order.
+func (s *memoryStore) sortedCronJobs(match func(job *CronJob) bool) []*CronJob {
+	var jobs []*CronJob
+	for _, job := range s.cronJobs {
+		if match(job) {
+			c := *job
+			jobs = append(jobs, &c)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs
+}
+
+func (s *memoryStore) LoadCrobJob(ctx context.Context, id int64) (*CronJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job := s.cronJobs[id]
+	if job == nil {
+		return nil, fmt.Errorf("no such cron job: %d", id)
+	}
+	c := *job
+	return &c, nil
+}
+
+func (s *memoryStore) GetTimeToNextCronJob(ctx context.Context, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var nextRunTime *time.Time
+	for _, job := range s.cronJobs {
+		if job.NextRun != nil && (nextRunTime == nil || job.NextRun.Before(*nextRunTime)) {
+			nextRunTime = job.NextRun
+		}
+	}
+	if nextRunTime == nil {
+		return 30 * time.Minute
+	}
+
+	duration := nextRunTime.Sub(now)
+	if duration < time.Second {
+		duration = time.Second
+	}
+	return duration
+}
+
+func (s *memoryStore) LoadPendingCronJobs(ctx context.Context, now time.Time) ([]*CronJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedCronJobs(func(job *CronJob) bool { return job.NextRun != nil && job.NextRun.Before(now) }), nil
+}
+
+func (s *memoryStore) DeleteCronJob(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.cronJobs, id)
+	return nil
+}
+
+func (s *memoryStore) SaveCronJob(ctx context.Context, job *CronJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := *job
+	if job.ID == 0 {
+		// Like the PostgreSQL backend, we don't update the job's ID.
+		c.ID = s.nextID("cron")
+	} else if s.cronJobs[job.ID] == nil {
+		return nil
+	}
+	s.cronJobs[c.ID] = &c
+	return nil
+}
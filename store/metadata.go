@@ -0,0 +1,253 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Transcript is a <podcast:transcript> of an episode.
+type Transcript struct {
+	URL      string `json:"url"`
+	Type     string `json:"type"`
+	Language string `json:"language,omitempty"`
+	Rel      string `json:"rel,omitempty"`
+}
+
+// Person is a <podcast:person>, someone involved with a podcast or a single episode.
+type Person struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Group    string `json:"group"`
+	ImageURL string `json:"img,omitempty"`
+	Href     string `json:"href,omitempty"`
+}
+
+// Funding is a <podcast:funding> link, where listeners can go to support the podcast.
+type Funding struct {
+	URL     string `json:"url"`
+	Message string `json:"message"`
+}
+
+// Soundbite is a <podcast:soundbite>, a short section of an episode that can be used as a preview.
+type Soundbite struct {
+	StartSecs    float64 `json:"startSecs"`
+	DurationSecs float64 `json:"durationSecs"`
+	Title        string  `json:"title,omitempty"`
+}
+
+// Enclosure is a <podcast:alternateEnclosure>, a different version of an episode's media.
+type Enclosure struct {
+	URL       string   `json:"url"`
+	Type      string   `json:"type"`
+	Length    *int64   `json:"length"`
+	Bitrate   *float64 `json:"bitrate"`
+	Height    *int32   `json:"height,omitempty"`
+	Language  string   `json:"lang,omitempty"`
+	Title     string   `json:"title,omitempty"`
+	Rel       string   `json:"rel,omitempty"`
+	Codecs    string   `json:"codecs,omitempty"`
+	IsDefault bool     `json:"default"`
+}
+
+// saveEpisodeMetadata replaces the transcripts, persons, soundbites and enclosures of the given
+// episode with the ones on the Episode struct.
+func saveEpisodeMetadata(ctx context.Context, tx pgx.Tx, ep *Episode) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM episode_transcripts WHERE episode_id=$1", ep.ID); err != nil {
+		return err
+	}
+	for _, t := range ep.Transcripts {
+		sql := "INSERT INTO episode_transcripts (episode_id, url, type, language, rel) VALUES ($1, $2, $3, $4, $5)"
+		if _, err := tx.Exec(ctx, sql, ep.ID, t.URL, t.Type, t.Language, t.Rel); err != nil {
+			return fmt.Errorf("error saving transcript: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM episode_soundbites WHERE episode_id=$1", ep.ID); err != nil {
+		return err
+	}
+	for _, sb := range ep.Soundbites {
+		sql := "INSERT INTO episode_soundbites (episode_id, start_secs, duration_secs, title) VALUES ($1, $2, $3, $4)"
+		if _, err := tx.Exec(ctx, sql, ep.ID, sb.StartSecs, sb.DurationSecs, sb.Title); err != nil {
+			return fmt.Errorf("error saving soundbite: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM episode_enclosures WHERE episode_id=$1", ep.ID); err != nil {
+		return err
+	}
+	for i, e := range ep.Enclosures {
+		sql := `INSERT INTO episode_enclosures
+			  (episode_id, position, url, type, length, bitrate, height, language, title, rel, codecs, is_default)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+		if _, err := tx.Exec(ctx, sql, ep.ID, i, e.URL, e.Type, e.Length, e.Bitrate, e.Height, e.Language, e.Title, e.Rel, e.Codecs, e.IsDefault); err != nil {
+			return fmt.Errorf("error saving enclosure: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM persons WHERE episode_id=$1", ep.ID); err != nil {
+		return err
+	}
+	for _, person := range ep.Persons {
+		if err := savePerson(ctx, tx, ep.PodcastID, &ep.ID, person); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func savePerson(ctx context.Context, tx pgx.Tx, podcastID int64, episodeID *int64, person *Person) error {
+	sql := `INSERT INTO persons (podcast_id, episode_id, name, role, person_group, image_url, href)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := tx.Exec(ctx, sql, podcastID, episodeID, person.Name, person.Role, person.Group, person.ImageURL, person.Href)
+	if err != nil {
+		return fmt.Errorf("error saving person: %w", err)
+	}
+	return nil
+}
+
+func (s *pgStore) SavePodcastMetadata(ctx context.Context, p *Podcast) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM persons WHERE podcast_id=$1 AND episode_id IS NULL", p.ID); err != nil {
+		return err
+	}
+	for _, person := range p.Persons {
+		if err := savePerson(ctx, tx, p.ID, nil, person); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM podcast_funding WHERE podcast_id=$1", p.ID); err != nil {
+		return err
+	}
+	for _, funding := range p.Funding {
+		sql := "INSERT INTO podcast_funding (podcast_id, url, message) VALUES ($1, $2, $3)"
+		if _, err := tx.Exec(ctx, sql, p.ID, funding.URL, funding.Message); err != nil {
+			return fmt.Errorf("error saving funding: %w", err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *pgStore) LoadPodcastMetadata(ctx context.Context, p *Podcast) error {
+	sql := "SELECT name, role, person_group, image_url, href FROM persons WHERE podcast_id=$1 AND episode_id IS NULL"
+	rows, _ := s.pool.Query(ctx, sql, p.ID)
+	defer rows.Close()
+
+	p.Persons = nil
+	for rows.Next() {
+		var person Person
+		if err := rows.Scan(&person.Name, &person.Role, &person.Group, &person.ImageURL, &person.Href); err != nil {
+			return fmt.Errorf("error scanning person: %w", err)
+		}
+		p.Persons = append(p.Persons, &person)
+	}
+	rows.Close()
+
+	sql = "SELECT url, message FROM podcast_funding WHERE podcast_id=$1"
+	rows, _ = s.pool.Query(ctx, sql, p.ID)
+	defer rows.Close()
+
+	p.Funding = nil
+	for rows.Next() {
+		var funding Funding
+		if err := rows.Scan(&funding.URL, &funding.Message); err != nil {
+			return fmt.Errorf("error scanning funding: %w", err)
+		}
+		p.Funding = append(p.Funding, &funding)
+	}
+
+	return nil
+}
+
+func (s *pgStore) LoadEpisodeMetadata(ctx context.Context, episodes []*Episode) error {
+	if len(episodes) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Episode)
+	var ids []int64
+	for _, ep := range episodes {
+		ep.Transcripts = nil
+		ep.Persons = nil
+		ep.Soundbites = nil
+		ep.Enclosures = nil
+		ep.CachedMediaURL = ""
+		byID[ep.ID] = ep
+		ids = append(ids, ep.ID)
+	}
+
+	sql := "SELECT episode_id, url, type, language, rel FROM episode_transcripts WHERE episode_id = ANY($1)"
+	rows, _ := s.pool.Query(ctx, sql, ids)
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var t Transcript
+		if err := rows.Scan(&id, &t.URL, &t.Type, &t.Language, &t.Rel); err != nil {
+			return fmt.Errorf("error scanning transcript: %w", err)
+		}
+		byID[id].Transcripts = append(byID[id].Transcripts, &t)
+	}
+	rows.Close()
+
+	sql = "SELECT episode_id, start_secs, duration_secs, title FROM episode_soundbites WHERE episode_id = ANY($1) ORDER BY start_secs"
+	rows, _ = s.pool.Query(ctx, sql, ids)
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var sb Soundbite
+		if err := rows.Scan(&id, &sb.StartSecs, &sb.DurationSecs, &sb.Title); err != nil {
+			return fmt.Errorf("error scanning soundbite: %w", err)
+		}
+		byID[id].Soundbites = append(byID[id].Soundbites, &sb)
+	}
+	rows.Close()
+
+	sql = `SELECT episode_id, url, type, length, bitrate, height, language, title, rel, codecs, is_default
+		FROM episode_enclosures WHERE episode_id = ANY($1) ORDER BY position`
+	rows, _ = s.pool.Query(ctx, sql, ids)
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var e Enclosure
+		if err := rows.Scan(&id, &e.URL, &e.Type, &e.Length, &e.Bitrate, &e.Height, &e.Language, &e.Title, &e.Rel, &e.Codecs, &e.IsDefault); err != nil {
+			return fmt.Errorf("error scanning enclosure: %w", err)
+		}
+		byID[id].Enclosures = append(byID[id].Enclosures, &e)
+	}
+	rows.Close()
+
+	sql = "SELECT episode_id FROM episode_media WHERE episode_id = ANY($1)"
+	rows, _ = s.pool.Query(ctx, sql, ids)
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("error scanning episode media: %w", err)
+		}
+		byID[id].CachedMediaURL = EpisodeMediaURL(id)
+	}
+	rows.Close()
+
+	sql = "SELECT episode_id, name, role, person_group, image_url, href FROM persons WHERE episode_id = ANY($1)"
+	rows, _ = s.pool.Query(ctx, sql, ids)
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var person Person
+		if err := rows.Scan(&id, &person.Name, &person.Role, &person.Group, &person.ImageURL, &person.Href); err != nil {
+			return fmt.Errorf("error scanning person: %w", err)
+		}
+		byID[id].Persons = append(byID[id].Persons, &person)
+	}
+
+	return nil
+}
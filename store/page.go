@@ -0,0 +1,94 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when the cursor of a Page isn't one that we handed out.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page is the page of a list that we want to load. We page with a cursor (the position of the last
+// item of the previous page) rather than an offset, so that new episodes being added while someone
+// pages through the list doesn't cause them to see things twice, or miss them.
+type Page struct {
+	// Cursor is the next cursor we returned along with the previous page, or empty to load the
+	// first page.
+	Cursor string
+
+	// Limit is the maximum number of items to load. Zero (or less) means load everything.
+	Limit int
+}
+
+// cursor is the position in a list that a page starts after. Podcasts are ordered by ID, so they
+// only use the ID. Episodes are ordered newest first, by pub_date and then ID.
+type cursor struct {
+	PubDate time.Time
+	ID      int64
+}
+
+// String encodes the cursor for handing out to clients. It's meant to be opaque to them.
+func (c *cursor) String() string {
+	str := c.PubDate.UTC().Format(time.RFC3339Nano) + "/" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(str))
+}
+
+// cursor decodes the page's cursor. Returns nil if the page doesn't have one, i.e. it's the first
+// page.
+func (p Page) cursor() (*cursor, error) {
+	if p.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(p.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parts := strings.Split(string(data), "/")
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	pubDate, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCursor, err)
+	}
+	return &cursor{PubDate: pubDate, ID: id}, nil
+}
+
+// fetchLimit is the number of rows we need to load for this page: one more than the limit, so that
+// we can tell whether there's another page after it. Zero means there's no limit.
+func (p Page) fetchLimit() int {
+	if p.Limit <= 0 {
+		return 0
+	}
+	return p.Limit + 1
+}
+
+// podcastsPage trims the given podcasts, which were loaded with the page's fetchLimit, down to the
+// page's limit, and returns the cursor of the next page (empty if this is the last one).
+func podcastsPage(page Page, podcasts []*Podcast) ([]*Podcast, string) {
+	if page.Limit <= 0 || len(podcasts) <= page.Limit {
+		return podcasts, ""
+	}
+	podcasts = podcasts[:page.Limit]
+	last := podcasts[len(podcasts)-1]
+	return podcasts, (&cursor{ID: last.ID}).String()
+}
+
+// episodesPage is the same as podcastsPage, but for episodes.
+func episodesPage(page Page, episodes []*Episode) ([]*Episode, string) {
+	if page.Limit <= 0 || len(episodes) <= page.Limit {
+		return episodes, ""
+	}
+	episodes = episodes[:page.Limit]
+	last := episodes[len(episodes)-1]
+	return episodes, (&cursor{PubDate: last.PubDate, ID: last.ID}).String()
+}
@@ -29,6 +29,10 @@ type Podcast struct {
 	// If true, the image is external and we should link to it directly rather than as a blob.
 	IsImageExternal bool `json:"isImageExternal"`
 
+	// Palette is the set of colours we extracted from the podcast's image, for clients to theme
+	// things with. Null if we haven't downloaded the image yet.
+	Palette *Palette `json:"palette,omitempty"`
+
 	// The path on disk to the file where we have the image for this podcast saved. This will be
 	// null before we've fetched the image.
 	ImagePath *string `json:"-"`
@@ -40,10 +44,49 @@ type Podcast struct {
 	// server to only give us new data if it has been changed since this time.
 	LastFetchTime time.Time `json:"lastFetchTime"`
 
+	// LastAttemptTime is the time we last tried to fetch the podcast, whether or not it worked. The
+	// cron job uses it to decide which podcast to fetch next, so a broken feed goes to the back of
+	// the queue without changing LastFetchTime.
+	LastAttemptTime time.Time `json:"-"`
+
+	// ETag and LastModified are the values of the ETag and Last-Modified headers the last time we
+	// fetched the feed. We send them back in If-None-Match and If-Modified-Since headers next time.
+	ETag         string `json:"-"`
+	LastModified string `json:"-"`
+
+	// HubURL is the WebSub hub the feed advertises, if any, and SelfURL is the feed's own idea of
+	// its URL, which is the topic we subscribe to at the hub.
+	HubURL  string `json:"-"`
+	SelfURL string `json:"-"`
+
+	// GUID is the <podcast:guid> of the podcast, a globally-unique identifier for the podcast that
+	// stays the same even if the feed moves. Empty if the feed doesn't have one.
+	GUID string `json:"guid"`
+
+	// Persons is the list of people (hosts, producers, etc) involved with the podcast.
+	Persons []*Person `json:"persons,omitempty"`
+
+	// Funding is the list of ways listeners can support the podcast.
+	Funding []*Funding `json:"funding,omitempty"`
+
 	// Episodes is the list of episodes that belong to this podcast.
 	Episodes []*Episode `json:"episodes"`
 }
 
+// Palette is a small set of colours extracted from a podcast's image, as "#rrggbb" strings.
+type Palette struct {
+	// Dominant is the most common colour in the image.
+	Dominant string `json:"dominant"`
+
+	// Vibrant and Muted are the most prominent saturated and unsaturated colours. If the image
+	// doesn't have any suitable colours, they're the same as Dominant.
+	Vibrant string `json:"vibrant"`
+	Muted   string `json:"muted"`
+
+	// Text is a colour (black or white) for text drawn on top of Dominant.
+	Text string `json:"text"`
+}
+
 // Episode is a single episode in a podcast.
 type Episode struct {
 	ID               int64     `json:"id"`
@@ -56,6 +99,56 @@ type Episode struct {
 	PubDate          time.Time `json:"pubDate"`
 	MediaURL         string    `json:"mediaUrl"`
 
+	// MediaLength and MediaType are the size, in bytes, and the MIME type of the episode's media, as
+	// given in the feed. MediaLength is null if the feed doesn't say (or says 0).
+	MediaLength *int64 `json:"mediaLength"`
+	MediaType   string `json:"mediaType"`
+
+	// DurationSecs is the length of the episode, in seconds. If the feed doesn't say, the probe cron
+	// job tries to work it out from the media file. Null if neither of those worked.
+	DurationSecs *int32 `json:"durationSecs"`
+
+	// Season and EpisodeNumber are the season and episode numbers from the feed, if it has them.
+	Season        *int32 `json:"season"`
+	EpisodeNumber *int32 `json:"episodeNumber"`
+
+	// EpisodeType is one of "full", "trailer" or "bonus".
+	EpisodeType string `json:"episodeType"`
+
+	// Explicit is true if the feed has marked this episode as containing explicit content.
+	Explicit bool `json:"explicit"`
+
+	// ImageURL is the URL of the episode-specific image. Null if the episode just uses the podcast's
+	// image.
+	ImageURL *string `json:"imageUrl"`
+
+	// ChaptersURL and ChaptersType are the URL and MIME type of the episode's <podcast:chapters>
+	// file, if it has one.
+	ChaptersURL  *string `json:"chaptersUrl"`
+	ChaptersType *string `json:"chaptersType"`
+
+	// Transcripts, Persons and Soundbites come from the <podcast:*> namespace. They are not loaded
+	// along with the episode itself, see LoadEpisodeMetadata.
+	Transcripts []*Transcript `json:"transcripts,omitempty"`
+	Persons     []*Person     `json:"persons,omitempty"`
+	Soundbites  []*Soundbite  `json:"soundbites,omitempty"`
+
+	// Enclosures are the episode's <podcast:alternateEnclosure>s, other versions of the media. Also
+	// loaded by LoadEpisodeMetadata.
+	Enclosures []*Enclosure `json:"enclosures,omitempty"`
+
+	// CachedMediaURL is the URL of our own copy of the episode's media, if we have it cached in the
+	// blob store. Also loaded by LoadEpisodeMetadata.
+	CachedMediaURL string `json:"cachedMediaUrl,omitempty"`
+
+	// RemovedAt is the time we noticed the episode was no longer in the podcast's feed. Null if it's
+	// still there.
+	RemovedAt *time.Time `json:"removedAt,omitempty"`
+
+	// DescriptionPolicy is the version of the HTML sanitisation policy that Description was sanitised
+	// with. Only used by the rss package, so that it knows which descriptions need re-sanitising.
+	DescriptionPolicy int `json:"-"`
+
 	// Position is the offset, in seconds, that the user is at for the episode. This will be null for
 	// episodes that don't have any progress (either the user is not subscribed, or they haven't
 	// started watching yet).
@@ -87,86 +180,155 @@ type EpisodeProgress struct {
 	LastUpdated time.Time
 }
 
-// SavePodcast saves the given podcast to the store.
-func SavePodcast(ctx context.Context, p *Podcast) (int64, error) {
+// episodeColumns is the list of columns we select for an Episode, in the order populateEpisode
+// expects them. The episodes table must be aliased to "e".
+const episodeColumns = `e.id, e.podcast_id, e.guid, e.title, e.description, e.description_html,
+	e.short_description, e.pub_date, e.media_url, e.duration_secs, e.season, e.episode_number,
+	e.episode_type, e.explicit, e.image_url, e.chapters_url, e.chapters_type, e.removed_at,
+	e.media_length, e.media_type`
+
+// podcastColumns is the list of columns we select for a Podcast, in the order scanPodcast expects
+// them.
+const podcastColumns = `podcasts.id, podcasts.discover_id, podcasts.title, podcasts.description,
+	podcasts.image_url, podcasts.image_path, podcasts.feed_url, podcasts.last_fetch_time,
+	podcasts.podcast_guid, podcasts.etag, podcasts.last_modified, podcasts.hub_url, podcasts.self_url,
+	podcasts.palette_dominant, podcasts.palette_vibrant, podcasts.palette_muted, podcasts.palette_text,
+	podcasts.last_attempt_time`
+
+func scanPodcast(row pgx.Row) (*Podcast, error) {
+	var p Podcast
+	var palette Palette
+	err := row.Scan(&p.ID, &p.DiscoverID, &p.Title, &p.Description, &p.ImageURL, &p.ImagePath, &p.FeedURL, &p.LastFetchTime, &p.GUID, &p.ETag, &p.LastModified, &p.HubURL, &p.SelfURL,
+		&palette.Dominant, &palette.Vibrant, &palette.Muted, &palette.Text, &p.LastAttemptTime)
+	if palette.Dominant != "" {
+		p.Palette = &palette
+	}
+	return &p, err
+}
+
+// paletteColumns returns the values of the palette columns for the given podcast.
+func paletteColumns(p *Podcast) []interface{} {
+	if p.Palette == nil {
+		return []interface{}{"", "", "", ""}
+	}
+	return []interface{}{p.Palette.Dominant, p.Palette.Vibrant, p.Palette.Muted, p.Palette.Text}
+}
+
+func (s *pgStore) SavePodcast(ctx context.Context, p *Podcast) (int64, error) {
 	if p.ID == 0 {
-		sql := "INSERT INTO podcasts (discover_id, title, description, image_url, image_path, feed_url, last_fetch_time) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
-		row := pool.QueryRow(ctx, sql, p.DiscoverID, p.Title, p.Description, p.ImageURL, p.ImagePath, p.FeedURL, time.Time{})
+		sql := "INSERT INTO podcasts (discover_id, title, description, image_url, image_path, feed_url, last_fetch_time, podcast_guid, etag, last_modified, hub_url, self_url, palette_dominant, palette_vibrant, palette_muted, palette_text, last_attempt_time) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) RETURNING id"
+		args := []interface{}{p.DiscoverID, p.Title, p.Description, p.ImageURL, p.ImagePath, p.FeedURL, time.Time{}, p.GUID, p.ETag, p.LastModified, p.HubURL, p.SelfURL}
+		args = append(args, paletteColumns(p)...)
+		row := s.pool.QueryRow(ctx, sql, append(args, time.Time{})...)
 		err := row.Scan(&p.ID)
 		return p.ID, err
 	} else {
-		sql := "UPDATE podcasts SET discover_id=$1, title=$2, description=$3, image_url=$4, image_path=$5, feed_url=$6, last_fetch_time=$7 WHERE id=$8"
-		_, err := pool.Exec(ctx, sql, p.DiscoverID, p.Title, p.Description, p.ImageURL, p.ImagePath, p.FeedURL, p.LastFetchTime, p.ID)
+		sql := "UPDATE podcasts SET discover_id=$1, title=$2, description=$3, image_url=$4, image_path=$5, feed_url=$6, last_fetch_time=$7, podcast_guid=$8, etag=$9, last_modified=$10, hub_url=$11, self_url=$12, palette_dominant=$13, palette_vibrant=$14, palette_muted=$15, palette_text=$16, last_attempt_time=$17 WHERE id=$18"
+		args := []interface{}{p.DiscoverID, p.Title, p.Description, p.ImageURL, p.ImagePath, p.FeedURL, p.LastFetchTime, p.GUID, p.ETag, p.LastModified, p.HubURL, p.SelfURL}
+		args = append(args, paletteColumns(p)...)
+		_, err := s.pool.Exec(ctx, sql, append(args, p.LastAttemptTime, p.ID)...)
 		return p.ID, err
 	}
 }
 
-// SaveEpisode saves the given episode to the data store.
-func SaveEpisode(ctx context.Context, p *Podcast, ep *Episode) error {
+func (s *pgStore) SaveEpisode(ctx context.Context, p *Podcast, ep *Episode, currentGUIDs []string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := renameEpisodeIfNeeded(ctx, tx, p.ID, ep, currentGUIDs); err != nil {
+		return fmt.Errorf("error checking for renamed episode: %w", err)
+	}
+
 	var sql = `INSERT INTO episodes
-		       (guid, podcast_id, title, description, description_html, short_description, pub_date, media_url)
-					 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		       (guid, podcast_id, title, description, description_html, short_description, pub_date, media_url,
+		        duration_secs, season, episode_number, episode_type, explicit, image_url, chapters_url, chapters_type,
+		        media_length, media_type, description_policy)
+					 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
 					 ON CONFLICT (podcast_id, guid) DO UPDATE SET
-					   title=$3, description=$4, description_html=$5, short_description=$6, pub_date=$7, media_url=$8
+					   title=$3, description=$4, description_html=$5, short_description=$6, pub_date=$7, media_url=$8,
+					   duration_secs=COALESCE($9, episodes.duration_secs), season=$10, episode_number=$11, episode_type=$12, explicit=$13, image_url=$14,
+					   chapters_url=$15, chapters_type=$16, media_length=$17, media_type=$18, description_policy=$19,
+					   removed_at=NULL
 					 RETURNING id`
-	row := pool.QueryRow(ctx, sql, ep.GUID, p.ID, ep.Title, ep.Description, ep.DescriptionHTML, ep.ShortDescription, ep.PubDate, ep.MediaURL)
+	row := tx.QueryRow(ctx, sql, ep.GUID, p.ID, ep.Title, ep.Description, ep.DescriptionHTML, ep.ShortDescription, ep.PubDate, ep.MediaURL,
+		ep.DurationSecs, ep.Season, ep.EpisodeNumber, ep.EpisodeType, ep.Explicit, ep.ImageURL, ep.ChaptersURL, ep.ChaptersType,
+		ep.MediaLength, ep.MediaType, ep.DescriptionPolicy)
 	var id int64
 	if err := row.Scan(&id); err != nil {
 		return err
 	}
 
 	if ep.ID != 0 && ep.ID != id {
-		// TODO: delete the episode, something has gone wrong
-		return fmt.Errorf("found existing episode with same GUID but different ID")
+		// The episode we were given has been superseded by the one with this GUID, so merge it in.
+		if err := mergeEpisodes(ctx, tx, ep.ID, id); err != nil {
+			return err
+		}
 	}
+	ep.ID = id
+	ep.PodcastID = p.ID
 
-	return nil
+	if err := saveEpisodeMetadata(ctx, tx, ep); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
-// LoadPodcast returns the podcast with the given ID.
-func LoadPodcast(ctx context.Context, podcastID int64) (*Podcast, error) {
-	podcast := &Podcast{}
-	sql := "SELECT id, discover_id, title, description, image_url, image_path, feed_url, last_fetch_time FROM podcasts WHERE id=$1"
-	row := pool.QueryRow(ctx, sql, podcastID)
-	if err := row.Scan(&podcast.ID, &podcast.DiscoverID, &podcast.Title, &podcast.Description, &podcast.ImageURL, &podcast.ImagePath, &podcast.FeedURL, &podcast.LastFetchTime); err != nil {
+func (s *pgStore) LoadPodcast(ctx context.Context, podcastID int64) (*Podcast, error) {
+	sql := "SELECT " + podcastColumns + " FROM podcasts WHERE id=$1"
+	row := s.pool.QueryRow(ctx, sql, podcastID)
+	podcast, err := scanPodcast(row)
+	if err != nil {
 		return nil, fmt.Errorf("error scanning row: %w", err)
 	}
 	return podcast, nil
 }
 
-// LoadPodcastByDiscoverId attempts to load a podcast with the given discover ID.
-func LoadPodcastByDiscoverId(ctx context.Context, discoverID string) (*Podcast, error) {
-	podcast := &Podcast{}
-	stmt := "SELECT id, discover_id, title, description, image_url, image_path, feed_url, last_fetch_time FROM podcasts WHERE discover_id=$1"
-	row := pool.QueryRow(ctx, stmt, discoverID)
-	if err := row.Scan(&podcast.ID, &podcast.DiscoverID, &podcast.Title, &podcast.Description, &podcast.ImageURL, &podcast.ImagePath, &podcast.FeedURL, &podcast.LastFetchTime); err != nil {
+func (s *pgStore) LoadPodcastByDiscoverId(ctx context.Context, discoverID string) (*Podcast, error) {
+	stmt := "SELECT " + podcastColumns + " FROM podcasts WHERE discover_id=$1"
+	row := s.pool.QueryRow(ctx, stmt, discoverID)
+	podcast, err := scanPodcast(row)
+	if err != nil {
 		return nil, fmt.Errorf("error scanning row: %w", err)
 	}
 	return podcast, nil
 }
 
-// LoadEpisode gets the episode with the given ID for the given podcast.
-func LoadEpisode(ctx context.Context, p *Podcast, episodeID int64) (*Episode, error) {
-	sql := `SELECT
-			id, podcast_id, guid, title, description, description_html, short_description, pub_date, media_url
-		FROM episodes
-		WHERE id = $1`
-	row := pool.QueryRow(ctx, sql, episodeID)
-	var ep Episode
-	if err := row.Scan(&ep.ID, &ep.PodcastID, &ep.GUID, &ep.Title, &ep.Description, &ep.DescriptionHTML, &ep.ShortDescription, &ep.PubDate, &ep.MediaURL); err != nil {
+func (s *pgStore) LoadEpisode(ctx context.Context, p *Podcast, episodeID int64) (*Episode, error) {
+	sql := "SELECT " + episodeColumns + ", NULL, NULL, NULL FROM episodes e WHERE e.id = $1"
+	row := s.pool.QueryRow(ctx, sql, episodeID)
+	ep, err := populateEpisode(row)
+	if err != nil {
 		return nil, fmt.Errorf("error scanning row: %w", err)
 	}
 
-	return &ep, nil
+	return ep, nil
 }
 
-func populateEpisode(currRow pgx.Row) (*Episode, error) {
+// populateEpisode scans an Episode from the given row, which must have the episodeColumns followed
+// by the three progress columns. Any extra columns are scanned into the given extra destinations.
+func populateEpisode(currRow pgx.Row, extra ...interface{}) (*Episode, error) {
 	var ep Episode
-	err := currRow.Scan(&ep.ID, &ep.PodcastID, &ep.GUID, &ep.Title, &ep.Description, &ep.DescriptionHTML, &ep.ShortDescription, &ep.PubDate, &ep.MediaURL, &ep.Position, &ep.IsComplete, &ep.LastListenTime)
+	dest := []interface{}{&ep.ID, &ep.PodcastID, &ep.GUID, &ep.Title, &ep.Description, &ep.DescriptionHTML, &ep.ShortDescription, &ep.PubDate, &ep.MediaURL,
+		&ep.DurationSecs, &ep.Season, &ep.EpisodeNumber, &ep.EpisodeType, &ep.Explicit, &ep.ImageURL,
+		&ep.ChaptersURL, &ep.ChaptersType, &ep.RemovedAt, &ep.MediaLength, &ep.MediaType,
+		&ep.Position, &ep.IsComplete, &ep.LastListenTime}
+	err := currRow.Scan(append(dest, extra...)...)
 	return &ep, err
 }
 
-func populateEpisodes(rows pgx.Rows) ([]*Episode, error) {
+// rowIterator is the part of pgx.Rows that we use to read the rows of a query. *sql.Rows has it
+// too, so that the SQLite backend can share our scanning code.
+type rowIterator interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+func populateEpisodes(rows rowIterator) ([]*Episode, error) {
 	var episodes []*Episode
 	for rows.Next() {
 		ep, err := populateEpisode(rows)
@@ -180,54 +342,83 @@ func populateEpisodes(rows pgx.Rows) ([]*Episode, error) {
 	return episodes, nil
 }
 
-// LoadEpisodes loads all episodes for the given podcast, up to the given limit. If limit is < 0
-// then loads all episodes.
-func LoadEpisodes(ctx context.Context, podcastID int64, limit int) ([]*Episode, error) {
-	sql := `SELECT
-	    id, podcast_id, guid, title, description, description_html, short_description, pub_date, media_url, NULL, NULL, NULL
-		FROM episodes
-		WHERE podcast_id = $1
-		ORDER BY pub_date DESC`
+func (s *pgStore) LoadEpisodes(ctx context.Context, podcastID int64, limit int) ([]*Episode, error) {
+	sql := `SELECT ` + episodeColumns + `, NULL, NULL, NULL
+		FROM episodes e
+		WHERE e.podcast_id = $1
+		ORDER BY e.pub_date DESC`
 	if limit > 0 {
 		sql += " LIMIT $2"
 	}
-	rows, _ := pool.Query(ctx, sql, podcastID, limit)
+	rows, _ := s.pool.Query(ctx, sql, podcastID, limit)
 	defer rows.Close()
 
 	return populateEpisodes(rows)
 }
 
-// LoadEpisodesForSubscription gets the episodes to display for the given subscribed account. We'll
-// return all episodes that the account has not finished listening to.
-func LoadEpisodesForSubscription(ctx context.Context, acct *Account, p *Podcast) ([]*Episode, error) {
-	sql := `SELECT
-			id, podcast_id, guid, title, description, description_html, short_description, pub_date, media_url, position_secs, episode_complete, episode_progress.last_updated
-		FROM episodes
-		LEFT OUTER JOIN episode_progress ON episodes.id = episode_progress.episode_id
-		WHERE podcast_id = $1
-		ORDER BY pub_date DESC`
-	rows, _ := pool.Query(ctx, sql, p.ID)
+func (s *pgStore) LoadEpisodesWithoutDuration(ctx context.Context, probedBefore time.Time, limit int) ([]*Episode, error) {
+	sql := `SELECT ` + episodeColumns + `, NULL, NULL, NULL
+		FROM episodes e
+		WHERE e.duration_secs IS NULL
+		  AND e.media_url <> ''
+		  AND e.removed_at IS NULL
+		  AND (e.duration_probed_at IS NULL OR e.duration_probed_at < $1)
+		ORDER BY e.pub_date DESC
+		LIMIT $2`
+	rows, _ := s.pool.Query(ctx, sql, probedBefore, limit)
 	defer rows.Close()
 
 	return populateEpisodes(rows)
 }
 
-// LoadEpisodesNewAndInProgress gets the new and in-progress episodes for the given account. In this
-// case, new episodes are ones that don't have any progress at all (and only from the last numDays
-// days). And of course, in-progress ones are ones that have progress but are not yet
-// marked done. For in-progress episode, we don't just limit them to the last numDays days, we will
-// return them all.
-func LoadEpisodesNewAndInProgress(ctx context.Context, acct *Account, numDays int) (newEpisodes []*Episode, inProgress []*Episode, err error) {
+func (s *pgStore) SaveEpisodeDuration(ctx context.Context, ep *Episode, probedAt time.Time) error {
+	sql := "UPDATE episodes SET duration_secs=$1, duration_probed_at=$2 WHERE id=$3"
+	_, err := s.pool.Exec(ctx, sql, ep.DurationSecs, probedAt, ep.ID)
+	return err
+}
+
+func (s *pgStore) LoadEpisodesForSubscription(ctx context.Context, acct *Account, p *Podcast, page Page) ([]*Episode, string, error) {
+	after, err := page.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sql := `SELECT ` + episodeColumns + `, position_secs, episode_complete, episode_progress.last_updated
+		FROM episodes e
+		LEFT OUTER JOIN episode_progress ON e.id = episode_progress.episode_id AND episode_progress.account_id = $2
+		WHERE e.podcast_id = $1`
+	args := []interface{}{p.ID, acct.ID}
+	if after != nil {
+		sql += " AND (e.pub_date, e.id) < ($3, $4)"
+		args = append(args, after.PubDate, after.ID)
+	}
+	sql += " ORDER BY e.pub_date DESC, e.id DESC"
+	if limit := page.fetchLimit(); limit > 0 {
+		sql += fmt.Sprintf(" LIMIT $%d", len(args)+1)
+		args = append(args, limit)
+	}
+	rows, _ := s.pool.Query(ctx, sql, args...)
+	defer rows.Close()
+
+	episodes, err := populateEpisodes(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	episodes, next := episodesPage(page, episodes)
+	return episodes, next, nil
+}
+
+func (s *pgStore) LoadEpisodesNewAndInProgress(ctx context.Context, acct *Account, numDays int) (newEpisodes []*Episode, inProgress []*Episode, err error) {
 	sql := `
-		SELECT e.id, e.podcast_id, guid, title, description, description_html, short_description,
-		       pub_date, media_url, position_secs, episode_complete, ep.last_updated
+		SELECT ` + episodeColumns + `, position_secs, episode_complete, ep.last_updated
 		FROM episodes e
 		INNER JOIN subscriptions s ON s.podcast_id = e.podcast_id
 		LEFT JOIN episode_progress ep ON ep.episode_id = e.id AND ep.account_id = s.account_id
 		WHERE (pub_date > $1 OR ep.position_secs IS NOT NULL)
+		  AND (e.removed_at IS NULL OR ep.position_secs IS NOT NULL)
 		  AND s.account_id = $2
 		ORDER BY pub_date DESC`
-	rows, _ := pool.Query(ctx, sql, time.Now().Add(-time.Hour*24*time.Duration(numDays)), acct.ID)
+	rows, _ := s.pool.Query(ctx, sql, time.Now().Add(-time.Hour*24*time.Duration(numDays)), acct.ID)
 	defer rows.Close()
 
 	var episodes []*Episode
@@ -247,40 +438,55 @@ func LoadEpisodesNewAndInProgress(ctx context.Context, acct *Account, numDays in
 	return episodes, inProgress, nil
 }
 
-func populatePodcasts(rows pgx.Rows) ([]*Podcast, error) {
+func populatePodcasts(rows rowIterator) ([]*Podcast, error) {
 	var podcasts []*Podcast
 	for rows.Next() {
-		var podcast Podcast
-		if err := rows.Scan(&podcast.ID, &podcast.DiscoverID, &podcast.Title, &podcast.Description, &podcast.ImageURL, &podcast.ImagePath, &podcast.FeedURL, &podcast.LastFetchTime); err != nil {
+		podcast, err := scanPodcast(rows)
+		if err != nil {
 			return nil, fmt.Errorf("error scanning podcast2: %w", err)
 		}
 
-		podcasts = append(podcasts, &podcast)
+		podcasts = append(podcasts, podcast)
 	}
 
 	return podcasts, nil
 }
 
-// LoadPodcasts loads all podcasts from the data store.
-// TODO: support paging, filtering, sorting(?), etc.
-func LoadPodcasts(ctx context.Context) ([]*Podcast, error) {
-	sql := "SELECT id, discover_id, title, description, image_url, image_path, feed_url, last_fetch_time FROM podcasts"
-	rows, _ := pool.Query(ctx, sql)
+func (s *pgStore) LoadPodcasts(ctx context.Context, page Page) ([]*Podcast, string, error) {
+	after, err := page.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	sql := "SELECT " + podcastColumns + " FROM podcasts"
+	var args []interface{}
+	if after != nil {
+		sql += " WHERE podcasts.id > $1"
+		args = append(args, after.ID)
+	}
+	sql += " ORDER BY podcasts.id"
+	if limit := page.fetchLimit(); limit > 0 {
+		sql += fmt.Sprintf(" LIMIT $%d", len(args)+1)
+		args = append(args, limit)
+	}
+	rows, _ := s.pool.Query(ctx, sql, args...)
 	defer rows.Close()
 
-	return populatePodcasts(rows)
+	podcasts, err := populatePodcasts(rows)
+	if err != nil {
+		return nil, "", err
+	}
+	podcasts, next := podcastsPage(page, podcasts)
+	return podcasts, next, nil
 }
 
-// DeletePodcast deletes the podcast with the given ID. This should remove the podcast as well as
-// all episodes, subscriptions and so on.
-func DeletePodcast(ctx context.Context, podcast *Podcast) error {
+func (s *pgStore) DeletePodcast(ctx context.Context, podcast *Podcast) error {
 	sql := "DELETE FROM podcasts WHERE id=$1"
-	_, err := pool.Exec(ctx, sql, podcast.ID)
+	_, err := s.pool.Exec(ctx, sql, podcast.ID)
 	return err
 }
 
-// SaveEpisodeProgress saves the given EpisodeProgress to the database.
-func SaveEpisodeProgress(ctx context.Context, progress *EpisodeProgress) error {
+func (s *pgStore) SaveEpisodeProgress(ctx context.Context, progress *EpisodeProgress) error {
 	now := time.Now()
 	if progress.LastUpdated.After(now) {
 		progress.LastUpdated = time.Now()
@@ -291,20 +497,45 @@ func SaveEpisodeProgress(ctx context.Context, progress *EpisodeProgress) error {
 		ON CONFLICT (account_id, episode_id) DO UPDATE SET
 		position_secs=$3,
 		last_updated=$4`
-	_, err := pool.Exec(ctx, sql, progress.AccountID, progress.EpisodeID, progress.PositionSecs, progress.LastUpdated)
+	_, err := s.pool.Exec(ctx, sql, progress.AccountID, progress.EpisodeID, progress.PositionSecs, progress.LastUpdated)
 	return err
 }
 
-func GetMostRecentPlaybackState(ctx context.Context, acct *Account) (*Episode, error) {
+func (s *pgStore) GetMostRecentPlaybackState(ctx context.Context, acct *Account) (*Episode, error) {
 	sql := `
-		SELECT e.id, e.podcast_id, guid, title, description, description_html, short_description,
-		       pub_date, media_url, position_secs, episode_complete, ep.last_updated
+		SELECT ` + episodeColumns + `, position_secs, episode_complete, ep.last_updated
 		FROM episodes e
 		INNER JOIN subscriptions s ON s.podcast_id = e.podcast_id
 		INNER JOIN episode_progress ep ON ep.episode_id = e.id AND ep.account_id = s.account_id
 		WHERE s.account_id = $1
 		ORDER BY ep.last_updated DESC
 		LIMIT 1`
-	row := pool.QueryRow(ctx, sql, acct.ID)
+	row := s.pool.QueryRow(ctx, sql, acct.ID)
 	return populateEpisode(row)
 }
+
+func (s *pgStore) LoadEpisodesByDescriptionPolicy(ctx context.Context, version, limit int) ([]*Episode, error) {
+	sql := `SELECT id, description, description_html
+		FROM episodes
+		WHERE description_policy < $1
+		ORDER BY id
+		LIMIT $2`
+	rows, _ := s.pool.Query(ctx, sql, version, limit)
+	defer rows.Close()
+
+	var episodes []*Episode
+	for rows.Next() {
+		var ep Episode
+		if err := rows.Scan(&ep.ID, &ep.Description, &ep.DescriptionHTML); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		episodes = append(episodes, &ep)
+	}
+	return episodes, rows.Err()
+}
+
+func (s *pgStore) UpdateEpisodeDescription(ctx context.Context, ep *Episode) error {
+	sql := "UPDATE episodes SET description=$1, description_policy=$2 WHERE id=$3"
+	_, err := s.pool.Exec(ctx, sql, ep.Description, ep.DescriptionPolicy, ep.ID)
+	return err
+}
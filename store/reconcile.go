@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// findRenamedEpisode looks for an existing episode that is probably the same as the given one, but
+// which was saved under a different GUID. This happens when a publisher rewrites the GUIDs in their
+// feed (for example, when moving to a different host). We match on the media URL, or the title and
+// publish date, and only if exactly one episode matches. Episodes whose GUID is still in
+// currentGUIDs are different episodes that just happen to look the same (e.g. a re-run), so they
+// never match. Returns 0 if there's no such episode.
+func findRenamedEpisode(ctx context.Context, tx pgx.Tx, podcastID int64, ep *Episode, currentGUIDs []string) (int64, error) {
+	sql := `SELECT id FROM episodes
+		WHERE podcast_id=$1 AND guid<>$2 AND NOT (guid = ANY($6))
+		  AND ((media_url<>'' AND media_url=$3) OR (title=$4 AND pub_date=$5))
+		LIMIT 2`
+	rows, _ := tx.Query(ctx, sql, podcastID, ep.GUID, ep.MediaURL, ep.Title, ep.PubDate, currentGUIDs)
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return 0, fmt.Errorf("error scanning row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(ids) != 1 {
+		return 0, nil
+	}
+	return ids[0], nil
+}
+
+// renameEpisodeIfNeeded checks whether the given episode is new, and if so whether it's actually an
+// existing episode whose GUID has been changed. If it is, we change the GUID of the existing
+// episode so that it keeps its ID (and therefore everyone's progress). If currentGUIDs is nil, we
+// can't tell which episodes have been renamed, so we don't rename any.
+func renameEpisodeIfNeeded(ctx context.Context, tx pgx.Tx, podcastID int64, ep *Episode, currentGUIDs []string) error {
+	if currentGUIDs == nil {
+		return nil
+	}
+
+	var id int64
+	row := tx.QueryRow(ctx, "SELECT id FROM episodes WHERE podcast_id=$1 AND guid=$2", podcastID, ep.GUID)
+	if err := row.Scan(&id); err == nil {
+		// We already have this one, nothing to do.
+		return nil
+	} else if err != pgx.ErrNoRows {
+		return err
+	}
+
+	existingID, err := findRenamedEpisode(ctx, tx, podcastID, ep, currentGUIDs)
+	if err != nil || existingID == 0 {
+		return err
+	}
+
+	log.Printf(" - episode %d has a new GUID: %s", existingID, ep.GUID)
+	_, err = tx.Exec(ctx, "UPDATE episodes SET guid=$1 WHERE id=$2", ep.GUID, existingID)
+	return err
+}
+
+// mergeEpisodes merges the episode fromID into the episode toID. The progress of all accounts is
+// moved over (if an account has progress on both, the most recently updated wins) and then fromID
+// is deleted.
+func mergeEpisodes(ctx context.Context, tx pgx.Tx, fromID, toID int64) error {
+	log.Printf(" - merging episode %d into %d", fromID, toID)
+
+	sql := `DELETE FROM episode_progress f USING episode_progress t
+		WHERE f.episode_id=$1 AND t.episode_id=$2 AND f.account_id=t.account_id AND f.last_updated <= t.last_updated`
+	if _, err := tx.Exec(ctx, sql, fromID, toID); err != nil {
+		return fmt.Errorf("error merging progress: %w", err)
+	}
+
+	sql = `DELETE FROM episode_progress t USING episode_progress f
+		WHERE t.episode_id=$2 AND f.episode_id=$1 AND f.account_id=t.account_id`
+	if _, err := tx.Exec(ctx, sql, fromID, toID); err != nil {
+		return fmt.Errorf("error merging progress: %w", err)
+	}
+
+	sql = "UPDATE episode_progress SET episode_id=$2 WHERE episode_id=$1"
+	if _, err := tx.Exec(ctx, sql, fromID, toID); err != nil {
+		return fmt.Errorf("error merging progress: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM episodes WHERE id=$1", fromID); err != nil {
+		return fmt.Errorf("error deleting merged episode: %w", err)
+	}
+	return nil
+}
+
+func (s *pgStore) ReconcileEpisodes(ctx context.Context, podcastID int64, currentGUIDs []string) (int, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	sql := `SELECT old.id, cur.id
+		FROM episodes old
+		INNER JOIN episodes cur ON cur.podcast_id = old.podcast_id AND cur.id <> old.id
+		  AND ((cur.media_url<>'' AND cur.media_url=old.media_url) OR (cur.title=old.title AND cur.pub_date=old.pub_date))
+		WHERE old.podcast_id=$1
+		  AND NOT (old.guid = ANY($2))
+		  AND cur.guid = ANY($2)`
+	rows, _ := tx.Query(ctx, sql, podcastID, currentGUIDs)
+	defer rows.Close()
+
+	// Only merge an old episode if it matches exactly one current episode, otherwise we can't be
+	// sure which one it is.
+	matches := make(map[int64][]int64)
+	for rows.Next() {
+		var oldID, curID int64
+		if err := rows.Scan(&oldID, &curID); err != nil {
+			return 0, fmt.Errorf("error scanning row: %w", err)
+		}
+		matches[oldID] = append(matches[oldID], curID)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	rows.Close()
+
+	numMerged := 0
+	for oldID, curIDs := range matches {
+		if len(curIDs) != 1 {
+			continue
+		}
+		if err := mergeEpisodes(ctx, tx, oldID, curIDs[0]); err != nil {
+			return 0, err
+		}
+		numMerged++
+	}
+
+	return numMerged, tx.Commit(ctx)
+}
+
+func (s *pgStore) MarkEpisodesRemoved(ctx context.Context, podcastID int64, currentGUIDs []string) (int64, error) {
+	sql := `UPDATE episodes SET removed_at=NOW()
+		WHERE podcast_id=$1 AND removed_at IS NULL AND NOT (guid = ANY($2))`
+	res, err := s.pool.Exec(ctx, sql, podcastID, currentGUIDs)
+	if err != nil {
+		return 0, fmt.Errorf("error marking episodes removed: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
+
+func (s *pgStore) PurgeRemovedEpisodes(ctx context.Context, podcastID int64) (int64, error) {
+	sql := `DELETE FROM episodes e
+		WHERE podcast_id=$1 AND removed_at IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM episode_progress p WHERE p.episode_id = e.id)`
+	res, err := s.pool.Exec(ctx, sql, podcastID)
+	if err != nil {
+		return 0, fmt.Errorf("error purging removed episodes: %w", err)
+	}
+	return res.RowsAffected(), nil
+}
+
+func (s *pgStore) LoadEpisodeGUIDs(ctx context.Context, podcastID int64) (map[string]bool, error) {
+	rows, _ := s.pool.Query(ctx, "SELECT guid FROM episodes WHERE podcast_id=$1", podcastID)
+	defer rows.Close()
+
+	guids := make(map[string]bool)
+	for rows.Next() {
+		var guid string
+		if err := rows.Scan(&guid); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		guids[guid] = true
+	}
+	return guids, rows.Err()
+}
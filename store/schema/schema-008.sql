@@ -0,0 +1,9 @@
+-- Episode metadata from the iTunes namespace. Everything is optional, since most of it is optional
+-- in the feeds as well.
+ALTER TABLE episodes
+  ADD COLUMN duration_secs INT,
+  ADD COLUMN season INT,
+  ADD COLUMN episode_number INT,
+  ADD COLUMN episode_type TEXT NOT NULL DEFAULT 'full',
+  ADD COLUMN explicit BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN image_url TEXT;
@@ -0,0 +1,76 @@
+-- Support for the Podcasting 2.0 <podcast:*> namespace.
+ALTER TABLE podcasts
+  ADD COLUMN podcast_guid TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE episodes
+  ADD COLUMN chapters_url TEXT,
+  ADD COLUMN chapters_type TEXT;
+
+
+CREATE TABLE episode_transcripts (
+  episode_id BIGINT NOT NULL,
+  url TEXT NOT NULL,
+  type TEXT NOT NULL,
+  language TEXT NOT NULL,
+  rel TEXT NOT NULL,
+
+  CONSTRAINT FK_episode_transcript_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_episode_transcript ON episode_transcripts (episode_id);
+
+
+CREATE TABLE episode_soundbites (
+  episode_id BIGINT NOT NULL,
+  start_secs DOUBLE PRECISION NOT NULL,
+  duration_secs DOUBLE PRECISION NOT NULL,
+  title TEXT NOT NULL,
+
+  CONSTRAINT FK_episode_soundbite_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_episode_soundbite ON episode_soundbites (episode_id);
+
+
+-- Persons can belong to either a whole podcast (episode_id is NULL) or a single episode.
+CREATE TABLE persons (
+  podcast_id BIGINT NOT NULL,
+  episode_id BIGINT,
+  name TEXT NOT NULL,
+  role TEXT NOT NULL,
+  person_group TEXT NOT NULL,
+  image_url TEXT NOT NULL,
+  href TEXT NOT NULL,
+
+  CONSTRAINT FK_person_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE,
+  CONSTRAINT FK_person_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_person_podcast ON persons (podcast_id, episode_id);
+CREATE INDEX IX_person_episode ON persons (episode_id);
+
+
+CREATE TABLE podcast_funding (
+  podcast_id BIGINT NOT NULL,
+  url TEXT NOT NULL,
+  message TEXT NOT NULL,
+
+  CONSTRAINT FK_podcast_funding_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_podcast_funding ON podcast_funding (podcast_id);
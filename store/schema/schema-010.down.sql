@@ -0,0 +1,4 @@
+-- Reverses schema-010.sql.
+DROP TABLE episode_chapters;
+ALTER TABLE episodes
+  DROP COLUMN chapters_source;
@@ -0,0 +1,23 @@
+-- Chapters of an episode, normalised from either a <podcast:chapters> JSON file or embedded
+-- <psc:chapters>. chapters_source records where we got them from, so we only re-fetch when that
+-- changes.
+ALTER TABLE episodes
+  ADD COLUMN chapters_source TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE episode_chapters (
+  episode_id BIGINT NOT NULL,
+  chapter_index INT NOT NULL,
+  start_secs DOUBLE PRECISION NOT NULL,
+  end_secs DOUBLE PRECISION,
+  title TEXT NOT NULL,
+  url TEXT NOT NULL,
+  image_url TEXT NOT NULL,
+  toc BOOLEAN NOT NULL,
+
+  CONSTRAINT FK_episode_chapter_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX UIX_episode_chapter ON episode_chapters (episode_id, chapter_index);
@@ -0,0 +1,4 @@
+-- Reverses schema-011.sql.
+DROP TABLE transcript_segments;
+ALTER TABLE episodes
+  DROP COLUMN transcript_source;
@@ -0,0 +1,23 @@
+-- Transcripts of episodes, stored as timestamped segments with a full-text index over them.
+-- transcript_source records the URL we got the transcript from, so we only re-fetch when that
+-- changes.
+ALTER TABLE episodes
+  ADD COLUMN transcript_source TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE transcript_segments (
+  episode_id BIGINT NOT NULL,
+  segment_index INT NOT NULL,
+  start_secs DOUBLE PRECISION NOT NULL,
+  end_secs DOUBLE PRECISION,
+  speaker TEXT NOT NULL,
+  body TEXT NOT NULL,
+  body_tsv TSVECTOR GENERATED ALWAYS AS (to_tsvector('english', body)) STORED,
+
+  CONSTRAINT FK_transcript_segment_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX UIX_transcript_segment ON transcript_segments (episode_id, segment_index);
+CREATE INDEX IX_transcript_segment_tsv ON transcript_segments USING GIN (body_tsv);
@@ -0,0 +1,2 @@
+-- Reverses schema-012.sql.
+DROP TABLE feed_url_history;
@@ -0,0 +1,17 @@
+-- A record of all the times a podcast's feed has moved, either by a permanent redirect or an
+-- <itunes:new-feed-url>.
+CREATE TABLE feed_url_history (
+  id BIGSERIAL NOT NULL PRIMARY KEY,
+  podcast_id BIGINT NOT NULL,
+  old_url TEXT NOT NULL,
+  new_url TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  changed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+  CONSTRAINT FK_feed_url_history_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_feed_url_history ON feed_url_history (podcast_id, changed_at);
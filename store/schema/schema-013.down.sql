@@ -0,0 +1,4 @@
+-- Reverses schema-013.sql.
+ALTER TABLE podcasts
+  DROP COLUMN etag,
+  DROP COLUMN last_modified;
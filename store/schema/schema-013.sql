@@ -0,0 +1,5 @@
+-- The ETag and Last-Modified headers from the last time we fetched the feed, so that we can make
+-- conditional requests next time.
+ALTER TABLE podcasts
+  ADD COLUMN etag TEXT NOT NULL DEFAULT '',
+  ADD COLUMN last_modified TEXT NOT NULL DEFAULT '';
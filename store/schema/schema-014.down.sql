@@ -0,0 +1,2 @@
+-- Reverses schema-014.sql.
+DROP TABLE feed_fetches;
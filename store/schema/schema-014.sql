@@ -0,0 +1,19 @@
+-- A record of each time we fetched a podcast's feed, for diagnosing feeds that stop updating.
+CREATE TABLE feed_fetches (
+  id BIGSERIAL NOT NULL PRIMARY KEY,
+  podcast_id BIGINT NOT NULL,
+  start_time TIMESTAMP WITH TIME ZONE NOT NULL,
+  end_time TIMESTAMP WITH TIME ZONE NOT NULL,
+  http_status INT,
+  bytes BIGINT NOT NULL,
+  num_parsed INT NOT NULL,
+  num_updated INT NOT NULL,
+  error TEXT,
+
+  CONSTRAINT FK_feed_fetch_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_feed_fetch ON feed_fetches (podcast_id, start_time);
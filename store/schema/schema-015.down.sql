@@ -0,0 +1,3 @@
+-- Reverses schema-015.sql.
+ALTER TABLE episodes
+  DROP COLUMN removed_at;
@@ -0,0 +1,4 @@
+-- The time we noticed an episode was no longer in its podcast's feed. Null for episodes that are
+-- still in the feed.
+ALTER TABLE episodes
+  ADD COLUMN removed_at TIMESTAMP WITH TIME ZONE;
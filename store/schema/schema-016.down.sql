@@ -0,0 +1,5 @@
+-- Reverses schema-016.sql.
+DROP TABLE websub_subscriptions;
+ALTER TABLE podcasts
+  DROP COLUMN hub_url,
+  DROP COLUMN self_url;
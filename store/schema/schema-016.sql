@@ -0,0 +1,21 @@
+-- The WebSub hub a podcast's feed advertises (<atom:link rel="hub">), and the feed's own URL
+-- (<atom:link rel="self">), which is the topic we subscribe to.
+ALTER TABLE podcasts
+  ADD COLUMN hub_url TEXT NOT NULL DEFAULT '',
+  ADD COLUMN self_url TEXT NOT NULL DEFAULT '';
+
+-- Our subscriptions to WebSub hubs, at most one per podcast.
+CREATE TABLE websub_subscriptions (
+  podcast_id BIGINT NOT NULL PRIMARY KEY,
+  hub_url TEXT NOT NULL,
+  topic_url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  verified BOOL NOT NULL,
+  requested_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  lease_expires_at TIMESTAMP WITH TIME ZONE,
+
+  CONSTRAINT FK_websub_subscription_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
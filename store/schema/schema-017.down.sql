@@ -0,0 +1,5 @@
+-- Reverses schema-017.sql.
+DROP TABLE episode_enclosures;
+ALTER TABLE episodes
+  DROP COLUMN media_length,
+  DROP COLUMN media_type;
@@ -0,0 +1,28 @@
+-- The size and MIME type of an episode's <enclosure>.
+ALTER TABLE episodes
+  ADD COLUMN media_length BIGINT,
+  ADD COLUMN media_type TEXT NOT NULL DEFAULT '';
+
+-- The <podcast:alternateEnclosure> elements of an episode: other versions of the episode's media,
+-- with different bitrates, codecs, video, etc.
+CREATE TABLE episode_enclosures (
+  episode_id BIGINT NOT NULL,
+  position INT NOT NULL,
+  url TEXT NOT NULL,
+  type TEXT NOT NULL,
+  length BIGINT,
+  bitrate DOUBLE PRECISION,
+  height INT,
+  language TEXT NOT NULL,
+  title TEXT NOT NULL,
+  rel TEXT NOT NULL,
+  codecs TEXT NOT NULL,
+  is_default BOOL NOT NULL,
+
+  CONSTRAINT FK_episode_enclosure_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_episode_enclosure ON episode_enclosures (episode_id, position);
@@ -0,0 +1,3 @@
+-- Reverses schema-018.sql.
+ALTER TABLE episodes
+  DROP COLUMN description_policy;
@@ -0,0 +1,4 @@
+-- The version of the HTML sanitisation policy an episode's description was last sanitised with, so
+-- that we can re-sanitise old episodes when the policy changes.
+ALTER TABLE episodes
+  ADD COLUMN description_policy INT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Reverses schema-019.sql.
+ALTER TABLE episodes
+  DROP COLUMN duration_probed_at;
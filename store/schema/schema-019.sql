@@ -0,0 +1,4 @@
+-- The time we last tried to work out an episode's duration from its media file, because the feed
+-- didn't give us one. Null if we haven't tried.
+ALTER TABLE episodes
+  ADD COLUMN duration_probed_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,3 @@
+-- Reverses schema-020.sql. This doesn't delete the cached files from the blob store.
+DROP TABLE episode_media;
+DROP TABLE media_cache_settings;
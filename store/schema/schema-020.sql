@@ -0,0 +1,30 @@
+-- Per-podcast settings for caching episode media in the blob store. Podcasts without a row here
+-- don't have their media cached.
+CREATE TABLE media_cache_settings (
+  podcast_id BIGINT NOT NULL PRIMARY KEY,
+  enabled BOOL NOT NULL,
+  max_episodes INT NOT NULL,
+  max_bytes BIGINT NOT NULL,
+
+  CONSTRAINT FK_media_cache_settings_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+-- The episodes whose media we have downloaded into the blob store.
+CREATE TABLE episode_media (
+  episode_id BIGINT NOT NULL PRIMARY KEY,
+  size BIGINT NOT NULL,
+  content_type TEXT NOT NULL,
+  sha256 TEXT NOT NULL,
+  cached_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  last_accessed_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+  CONSTRAINT FK_episode_media_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_episode_media_last_accessed ON episode_media (last_accessed_at);
@@ -0,0 +1,6 @@
+-- Reverses schema-021.sql.
+ALTER TABLE podcasts
+  DROP COLUMN palette_dominant,
+  DROP COLUMN palette_vibrant,
+  DROP COLUMN palette_muted,
+  DROP COLUMN palette_text;
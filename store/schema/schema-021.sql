@@ -0,0 +1,7 @@
+-- The palette we extracted from the podcast's icon, as "#rrggbb" colours. Empty if we haven't
+-- extracted one yet.
+ALTER TABLE podcasts
+  ADD COLUMN palette_dominant TEXT NOT NULL DEFAULT '',
+  ADD COLUMN palette_vibrant TEXT NOT NULL DEFAULT '',
+  ADD COLUMN palette_muted TEXT NOT NULL DEFAULT '',
+  ADD COLUMN palette_text TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Reverses schema-022.sql.
+ALTER TABLE podcasts
+  DROP COLUMN last_attempt_time;
@@ -0,0 +1,4 @@
+-- Reverses schema-023.sql.
+ALTER TABLE episodes
+  DROP COLUMN chapters_etag,
+  DROP COLUMN chapters_hash;
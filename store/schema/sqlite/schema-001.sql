@@ -0,0 +1,331 @@
+-- The SQLite schema starts out with everything the PostgreSQL schema had at version 21, in one go.
+-- Times are stored as text in UTC, so that comparing them as strings works.
+CREATE TABLE schema_version (
+  version INTEGER NOT NULL
+);
+INSERT INTO schema_version (version) VALUES (0);
+
+
+CREATE TABLE accounts (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  cookie TEXT NOT NULL,
+  username TEXT NOT NULL,
+  password_hash BLOB NOT NULL
+);
+
+
+CREATE TABLE podcasts (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  discover_id TEXT NOT NULL DEFAULT '',
+  title TEXT NOT NULL,
+  description TEXT NOT NULL,
+  image_url TEXT NOT NULL,
+  image_path TEXT,
+  feed_url TEXT NOT NULL,
+  last_fetch_time TIMESTAMP NOT NULL,
+  podcast_guid TEXT NOT NULL DEFAULT '',
+  etag TEXT NOT NULL DEFAULT '',
+  last_modified TEXT NOT NULL DEFAULT '',
+  hub_url TEXT NOT NULL DEFAULT '',
+  self_url TEXT NOT NULL DEFAULT '',
+  palette_dominant TEXT NOT NULL DEFAULT '',
+  palette_vibrant TEXT NOT NULL DEFAULT '',
+  palette_muted TEXT NOT NULL DEFAULT '',
+  palette_text TEXT NOT NULL DEFAULT ''
+);
+
+
+CREATE TABLE episodes (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  podcast_id INTEGER NOT NULL,
+  guid TEXT NOT NULL,
+  title TEXT NOT NULL,
+  description TEXT NOT NULL,
+  description_html BOOLEAN NOT NULL,
+  description_policy INTEGER NOT NULL DEFAULT 0,
+  short_description TEXT NOT NULL,
+  pub_date TIMESTAMP NOT NULL,
+  media_url TEXT NOT NULL,
+  media_length INTEGER,
+  media_type TEXT NOT NULL DEFAULT '',
+  duration_secs INTEGER,
+  duration_probed_at TIMESTAMP,
+  season INTEGER,
+  episode_number INTEGER,
+  episode_type TEXT NOT NULL DEFAULT 'full',
+  explicit BOOLEAN NOT NULL DEFAULT FALSE,
+  image_url TEXT,
+  chapters_url TEXT,
+  chapters_type TEXT,
+  chapters_source TEXT NOT NULL DEFAULT '',
+  transcript_source TEXT NOT NULL DEFAULT '',
+  removed_at TIMESTAMP,
+
+  CONSTRAINT FK_episode_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX UIX_episode_guid ON episodes (podcast_id, guid);
+CREATE INDEX IX_episode_pubdate ON episodes (podcast_id, pub_date);
+
+
+CREATE TABLE subscriptions (
+  podcast_id INTEGER NOT NULL,
+  account_id INTEGER NOT NULL,
+
+  CONSTRAINT FK_subscription_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE,
+  CONSTRAINT FK_subscription_account
+    FOREIGN KEY (account_id)
+    REFERENCES accounts (id)
+    ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX UIX_subscription ON subscriptions (podcast_id, account_id);
+
+
+CREATE TABLE episode_progress (
+  account_id INTEGER NOT NULL,
+  episode_id INTEGER NOT NULL,
+  position_secs INTEGER NOT NULL,
+  episode_complete BOOLEAN NOT NULL,
+  last_updated TIMESTAMP NOT NULL,
+
+  CONSTRAINT FK_episode_progress_account
+    FOREIGN KEY (account_id)
+    REFERENCES accounts (id)
+    ON DELETE CASCADE,
+  CONSTRAINT FK_episode_progress_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX UIX_episode_progress ON episode_progress (account_id, episode_id);
+CREATE INDEX IX_episode_progress_episode ON episode_progress (episode_id);
+
+
+CREATE TABLE cron (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  job_name TEXT NOT NULL,
+  enabled BOOLEAN NOT NULL,
+  schedule TEXT NOT NULL,
+  next_run TIMESTAMP
+);
+
+
+CREATE TABLE episode_transcripts (
+  episode_id INTEGER NOT NULL,
+  url TEXT NOT NULL,
+  type TEXT NOT NULL,
+  language TEXT NOT NULL,
+  rel TEXT NOT NULL,
+
+  CONSTRAINT FK_episode_transcript_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_episode_transcript ON episode_transcripts (episode_id);
+
+
+CREATE TABLE episode_soundbites (
+  episode_id INTEGER NOT NULL,
+  start_secs REAL NOT NULL,
+  duration_secs REAL NOT NULL,
+  title TEXT NOT NULL,
+
+  CONSTRAINT FK_episode_soundbite_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_episode_soundbite ON episode_soundbites (episode_id);
+
+
+-- Persons can belong to either a whole podcast (episode_id is NULL) or a single episode.
+CREATE TABLE persons (
+  podcast_id INTEGER NOT NULL,
+  episode_id INTEGER,
+  name TEXT NOT NULL,
+  role TEXT NOT NULL,
+  person_group TEXT NOT NULL,
+  image_url TEXT NOT NULL,
+  href TEXT NOT NULL,
+
+  CONSTRAINT FK_person_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE,
+  CONSTRAINT FK_person_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_person_podcast ON persons (podcast_id, episode_id);
+CREATE INDEX IX_person_episode ON persons (episode_id);
+
+
+CREATE TABLE podcast_funding (
+  podcast_id INTEGER NOT NULL,
+  url TEXT NOT NULL,
+  message TEXT NOT NULL,
+
+  CONSTRAINT FK_podcast_funding_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_podcast_funding ON podcast_funding (podcast_id);
+
+
+CREATE TABLE episode_chapters (
+  episode_id INTEGER NOT NULL,
+  chapter_index INTEGER NOT NULL,
+  start_secs REAL NOT NULL,
+  end_secs REAL,
+  title TEXT NOT NULL,
+  url TEXT NOT NULL,
+  image_url TEXT NOT NULL,
+  toc BOOLEAN NOT NULL,
+
+  CONSTRAINT FK_episode_chapter_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX UIX_episode_chapter ON episode_chapters (episode_id, chapter_index);
+
+
+-- There's no full-text index here, SearchTranscripts just does a LIKE over the segments. That's
+-- fine for the number of transcripts one household will have.
+CREATE TABLE transcript_segments (
+  episode_id INTEGER NOT NULL,
+  segment_index INTEGER NOT NULL,
+  start_secs REAL NOT NULL,
+  end_secs REAL,
+  speaker TEXT NOT NULL,
+  body TEXT NOT NULL,
+
+  CONSTRAINT FK_transcript_segment_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX UIX_transcript_segment ON transcript_segments (episode_id, segment_index);
+
+
+CREATE TABLE feed_url_history (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  podcast_id INTEGER NOT NULL,
+  old_url TEXT NOT NULL,
+  new_url TEXT NOT NULL,
+  reason TEXT NOT NULL,
+  changed_at TIMESTAMP NOT NULL,
+
+  CONSTRAINT FK_feed_url_history_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_feed_url_history ON feed_url_history (podcast_id, changed_at);
+
+
+CREATE TABLE feed_fetches (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  podcast_id INTEGER NOT NULL,
+  start_time TIMESTAMP NOT NULL,
+  end_time TIMESTAMP NOT NULL,
+  http_status INTEGER,
+  bytes INTEGER NOT NULL,
+  num_parsed INTEGER NOT NULL,
+  num_updated INTEGER NOT NULL,
+  error TEXT,
+
+  CONSTRAINT FK_feed_fetch_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_feed_fetch ON feed_fetches (podcast_id, start_time);
+
+
+CREATE TABLE websub_subscriptions (
+  podcast_id INTEGER NOT NULL PRIMARY KEY,
+  hub_url TEXT NOT NULL,
+  topic_url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  verified BOOLEAN NOT NULL,
+  requested_at TIMESTAMP NOT NULL,
+  lease_expires_at TIMESTAMP,
+
+  CONSTRAINT FK_websub_subscription_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+
+CREATE TABLE episode_enclosures (
+  episode_id INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  url TEXT NOT NULL,
+  type TEXT NOT NULL,
+  length INTEGER,
+  bitrate REAL,
+  height INTEGER,
+  language TEXT NOT NULL,
+  title TEXT NOT NULL,
+  rel TEXT NOT NULL,
+  codecs TEXT NOT NULL,
+  is_default BOOLEAN NOT NULL,
+
+  CONSTRAINT FK_episode_enclosure_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_episode_enclosure ON episode_enclosures (episode_id, position);
+
+
+CREATE TABLE media_cache_settings (
+  podcast_id INTEGER NOT NULL PRIMARY KEY,
+  enabled BOOLEAN NOT NULL,
+  max_episodes INTEGER NOT NULL,
+  max_bytes INTEGER NOT NULL,
+
+  CONSTRAINT FK_media_cache_settings_podcast
+    FOREIGN KEY (podcast_id)
+    REFERENCES podcasts (id)
+    ON DELETE CASCADE
+);
+
+
+CREATE TABLE episode_media (
+  episode_id INTEGER NOT NULL PRIMARY KEY,
+  size INTEGER NOT NULL,
+  content_type TEXT NOT NULL,
+  sha256 TEXT NOT NULL,
+  cached_at TIMESTAMP NOT NULL,
+  last_accessed_at TIMESTAMP NOT NULL,
+
+  CONSTRAINT FK_episode_media_episode
+    FOREIGN KEY (episode_id)
+    REFERENCES episodes (id)
+    ON DELETE CASCADE
+);
+
+CREATE INDEX IX_episode_media_last_accessed ON episode_media (last_accessed_at);
@@ -0,0 +1,5 @@
+-- The time we last tried to fetch the podcast, whether or not it worked. The cron job orders the
+-- podcasts by this, while last_fetch_time is only updated when a fetch succeeds.
+ALTER TABLE podcasts
+  ADD COLUMN last_attempt_time TIMESTAMP NOT NULL DEFAULT '';
+UPDATE podcasts SET last_attempt_time = last_fetch_time;
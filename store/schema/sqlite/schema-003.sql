@@ -0,0 +1,6 @@
+-- The ETag and SHA-256 of the chapters file we got an episode's chapters from, so that we can tell
+-- when the publisher updates the file without changing its URL.
+ALTER TABLE episodes
+  ADD COLUMN chapters_etag TEXT NOT NULL DEFAULT '';
+ALTER TABLE episodes
+  ADD COLUMN chapters_hash TEXT NOT NULL DEFAULT '';
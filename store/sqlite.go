@@ -0,0 +1,1287 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/podcreep/server/util"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// sqliteStore is the Backend that keeps everything in a SQLite database, for people running the
+// server for just themselves (or their household) who don't want to run PostgreSQL as well.
+//
+// All times are stored in UTC, as the SQLite driver stores them as text in whatever time zone they
+// come with, and we need them to compare properly.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// connectSQLite opens (or creates) the SQLite database at the given path.
+func connectSQLite(path string) (Backend, error) {
+	dsn := path + "?_foreign_keys=on&_busy_timeout=10000&_txlock=immediate"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open database: %s %w", path, err)
+	}
+
+	// SQLite only lets one connection write at a time anyway. With just the one connection, we never
+	// have to worry about our own connections getting in each other's way.
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("unable to open database: %s %w", path, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// utc returns the given time in UTC, or nil if it's nil.
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// jsonList returns the given slice as a JSON array. SQLite doesn't have arrays, so where PostgreSQL
+// uses "= ANY($1)", we use "IN (SELECT value FROM json_each(?))".
+func jsonList(values interface{}) string {
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+func (s *sqliteStore) migrations() ([]*Migration, error) {
+	return loadMigrations("schema/sqlite")
+}
+
+func (s *sqliteStore) schemaVersion(ctx context.Context) int {
+	row := s.db.QueryRowContext(ctx, "SELECT version FROM schema_version")
+	var version int
+	if err := row.Scan(&version); err != nil {
+		// Just like PostgreSQL, we assume the table doesn't exist yet.
+		return 0
+	}
+	return version
+}
+
+func (s *sqliteStore) migrateSchema(ctx context.Context, plan func(current int) ([]*migrationStep, error), dryRun bool) error {
+	// There's no advisory lock in SQLite, but we only have the one connection, and each migration
+	// runs in an immediate transaction, which locks the database against everyone else.
+	steps, err := plan(s.schemaVersion(ctx))
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if dryRun {
+			logDryRun(step)
+			continue
+		}
+		if err := s.runMigration(ctx, step); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Schema up-to-date at version %d", s.schemaVersion(ctx))
+	return nil
+}
+
+func (s *sqliteStore) runMigration(ctx context.Context, step *migrationStep) error {
+	log.Printf("Running script for version %d (to version %d)", step.version, step.newVersion)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, step.script); err != nil {
+		return fmt.Errorf("error running script for version %d: %w", step.version, err)
+	}
+	if step.newVersion > 0 {
+		if _, err := tx.ExecContext(ctx, "UPDATE schema_version SET version=?", step.newVersion); err != nil {
+			return fmt.Errorf("error updating schema version: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) SaveAccount(ctx context.Context, username, password string) (*Account, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %w", err)
+	}
+
+	cookie, err := util.CreateCookie()
+	if err != nil {
+		return nil, fmt.Errorf("error creating cookie: %w", err)
+	}
+
+	query := "INSERT INTO accounts (cookie, username, password_hash) VALUES (?, ?, ?)"
+	res, err := s.db.ExecContext(ctx, query, cookie, username, hash)
+	if err != nil {
+		return nil, fmt.Errorf("error saving account: %w", err)
+	}
+	id, _ := res.LastInsertId()
+
+	acct := &Account{
+		ID:           id,
+		Cookie:       cookie,
+		Username:     username,
+		PasswordHash: hash,
+	}
+	return acct, nil
+}
+
+func (s *sqliteStore) VerifyUsernameExists(ctx context.Context, username string) (bool, error) {
+	return s.exists(ctx, "SELECT 1 FROM accounts WHERE username=?", username)
+}
+
+// exists returns true if the given query returns at least one row.
+func (s *sqliteStore) exists(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+func (s *sqliteStore) LoadAccountByUsername(ctx context.Context, username, password string) (*Account, error) {
+	query := "SELECT id, username, cookie, password_hash FROM accounts WHERE username=?"
+	acct, err := getAccountFromRow(s.db.QueryRowContext(ctx, query, username))
+	if err != nil {
+		return nil, err
+	}
+	return checkPassword(acct, password), nil
+}
+
+func (s *sqliteStore) LoadAccountByCookie(ctx context.Context, cookie string) (*Account, error) {
+	query := "SELECT id, username, cookie, password_hash FROM accounts WHERE cookie=?"
+	return getAccountFromRow(s.db.QueryRowContext(ctx, query, cookie))
+}
+
+func (s *sqliteStore) SaveSubscription(ctx context.Context, acct *Account, podcastID int64) error {
+	query := "INSERT INTO subscriptions (podcast_id, account_id) VALUES (?, ?)"
+	_, err := s.db.ExecContext(ctx, query, podcastID, acct.ID)
+	return err
+}
+
+func (s *sqliteStore) DeleteSubscription(ctx context.Context, acct *Account, podcastID int64) error {
+	query := "DELETE FROM subscriptions WHERE account_id=? AND podcast_id=?"
+	_, err := s.db.ExecContext(ctx, query, acct.ID, podcastID)
+	return err
+}
+
+func (s *sqliteStore) GetSubscriptions(ctx context.Context, acct *Account) ([]*Podcast, error) {
+	query := `SELECT ` + podcastColumns + `
+		FROM podcasts
+		  INNER JOIN subscriptions ON podcasts.id = subscriptions.podcast_id
+		WHERE subscriptions.account_id = ?`
+	return s.loadPodcasts(ctx, query, acct.ID)
+}
+
+func (s *sqliteStore) loadPodcasts(ctx context.Context, query string, args ...interface{}) ([]*Podcast, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return populatePodcasts(rows)
+}
+
+func (s *sqliteStore) LoadSubscriptionIDs(ctx context.Context, acct *Account) (map[int64]struct{}, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT podcast_id FROM subscriptions WHERE account_id = ?", acct.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]struct{})
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning podcast: %w", err)
+		}
+		ids[id] = struct{}{}
+	}
+	return ids, rows.Err()
+}
+
+func (s *sqliteStore) IsSubscribed(ctx context.Context, acct *Account, podcastID int64) bool {
+	subscribed, _ := s.exists(ctx, "SELECT 1 FROM subscriptions WHERE account_id=? AND podcast_id=?", acct.ID, podcastID)
+	return subscribed
+}
+
+func (s *sqliteStore) SavePodcast(ctx context.Context, p *Podcast) (int64, error) {
+	if p.ID == 0 {
+		query := "INSERT INTO podcasts (discover_id, title, description, image_url, image_path, feed_url, last_fetch_time, podcast_guid, etag, last_modified, hub_url, self_url, palette_dominant, palette_vibrant, palette_muted, palette_text, last_attempt_time) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+		args := []interface{}{p.DiscoverID, p.Title, p.Description, p.ImageURL, p.ImagePath, p.FeedURL, time.Time{}, p.GUID, p.ETag, p.LastModified, p.HubURL, p.SelfURL}
+		args = append(args, paletteColumns(p)...)
+		res, err := s.db.ExecContext(ctx, query, append(args, time.Time{})...)
+		if err != nil {
+			return 0, err
+		}
+		p.ID, err = res.LastInsertId()
+		return p.ID, err
+	} else {
+		query := "UPDATE podcasts SET discover_id=?, title=?, description=?, image_url=?, image_path=?, feed_url=?, last_fetch_time=?, podcast_guid=?, etag=?, last_modified=?, hub_url=?, self_url=?, palette_dominant=?, palette_vibrant=?, palette_muted=?, palette_text=?, last_attempt_time=? WHERE id=?"
+		args := []interface{}{p.DiscoverID, p.Title, p.Description, p.ImageURL, p.ImagePath, p.FeedURL, p.LastFetchTime.UTC(), p.GUID, p.ETag, p.LastModified, p.HubURL, p.SelfURL}
+		args = append(args, paletteColumns(p)...)
+		_, err := s.db.ExecContext(ctx, query, append(args, p.LastAttemptTime.UTC(), p.ID)...)
+		return p.ID, err
+	}
+}
+
+func (s *sqliteStore) LoadPodcast(ctx context.Context, podcastID int64) (*Podcast, error) {
+	query := "SELECT " + podcastColumns + " FROM podcasts WHERE id=?"
+	podcast, err := scanPodcast(s.db.QueryRowContext(ctx, query, podcastID))
+	if err != nil {
+		return nil, fmt.Errorf("error scanning row: %w", err)
+	}
+	return podcast, nil
+}
+
+func (s *sqliteStore) LoadPodcastByDiscoverId(ctx context.Context, discoverID string) (*Podcast, error) {
+	query := "SELECT " + podcastColumns + " FROM podcasts WHERE discover_id=?"
+	podcast, err := scanPodcast(s.db.QueryRowContext(ctx, query, discoverID))
+	if err != nil {
+		return nil, fmt.Errorf("error scanning row: %w", err)
+	}
+	return podcast, nil
+}
+
+func (s *sqliteStore) LoadPodcasts(ctx context.Context, page Page) ([]*Podcast, string, error) {
+	after, err := page.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := "SELECT " + podcastColumns + " FROM podcasts"
+	var args []interface{}
+	if after != nil {
+		query += " WHERE podcasts.id > ?"
+		args = append(args, after.ID)
+	}
+	query += " ORDER BY podcasts.id"
+	if limit := page.fetchLimit(); limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	podcasts, err := s.loadPodcasts(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	podcasts, next := podcastsPage(page, podcasts)
+	return podcasts, next, nil
+}
+
+func (s *sqliteStore) DeletePodcast(ctx context.Context, podcast *Podcast) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM podcasts WHERE id=?", podcast.ID)
+	return err
+}
+
+func (s *sqliteStore) savePerson(ctx context.Context, tx *sql.Tx, podcastID int64, episodeID *int64, person *Person) error {
+	query := `INSERT INTO persons (podcast_id, episode_id, name, role, person_group, image_url, href)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := tx.ExecContext(ctx, query, podcastID, episodeID, person.Name, person.Role, person.Group, person.ImageURL, person.Href)
+	if err != nil {
+		return fmt.Errorf("error saving person: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) SavePodcastMetadata(ctx context.Context, p *Podcast) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM persons WHERE podcast_id=? AND episode_id IS NULL", p.ID); err != nil {
+		return err
+	}
+	for _, person := range p.Persons {
+		if err := s.savePerson(ctx, tx, p.ID, nil, person); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM podcast_funding WHERE podcast_id=?", p.ID); err != nil {
+		return err
+	}
+	for _, funding := range p.Funding {
+		query := "INSERT INTO podcast_funding (podcast_id, url, message) VALUES (?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, p.ID, funding.URL, funding.Message); err != nil {
+			return fmt.Errorf("error saving funding: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// forEachRow runs the given query, and calls fn for each row. The rows are always closed by the
+// time it returns, which matters because we only have the one connection.
+func (s *sqliteStore) forEachRow(ctx context.Context, fn func(rows *sql.Rows) error, query string, args ...interface{}) error {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) LoadPodcastMetadata(ctx context.Context, p *Podcast) error {
+	p.Persons = nil
+	query := "SELECT name, role, person_group, image_url, href FROM persons WHERE podcast_id=? AND episode_id IS NULL"
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var person Person
+		if err := rows.Scan(&person.Name, &person.Role, &person.Group, &person.ImageURL, &person.Href); err != nil {
+			return fmt.Errorf("error scanning person: %w", err)
+		}
+		p.Persons = append(p.Persons, &person)
+		return nil
+	}, query, p.ID)
+	if err != nil {
+		return err
+	}
+
+	p.Funding = nil
+	return s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var funding Funding
+		if err := rows.Scan(&funding.URL, &funding.Message); err != nil {
+			return fmt.Errorf("error scanning funding: %w", err)
+		}
+		p.Funding = append(p.Funding, &funding)
+		return nil
+	}, "SELECT url, message FROM podcast_funding WHERE podcast_id=?", p.ID)
+}
+
+func (s *sqliteStore) UpdatePodcastFeedURL(ctx context.Context, p *Podcast, newURL, reason string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO feed_url_history (podcast_id, old_url, new_url, reason, changed_at)
+		VALUES (?, ?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, query, p.ID, p.FeedURL, newURL, reason, time.Now().UTC()); err != nil {
+		return fmt.Errorf("error saving feed URL history: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE podcasts SET feed_url=? WHERE id=?", newURL, p.ID); err != nil {
+		return fmt.Errorf("error updating feed URL: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	p.FeedURL = newURL
+	return nil
+}
+
+func (s *sqliteStore) LoadFeedURLHistory(ctx context.Context, podcastID int64) ([]*FeedURLChange, error) {
+	query := `SELECT old_url, new_url, reason, changed_at
+		FROM feed_url_history
+		WHERE podcast_id=?
+		ORDER BY changed_at DESC`
+	var changes []*FeedURLChange
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var change FeedURLChange
+		if err := rows.Scan(&change.OldURL, &change.NewURL, &change.Reason, &change.ChangedAt); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		changes = append(changes, &change)
+		return nil
+	}, query, podcastID)
+	return changes, err
+}
+
+func (s *sqliteStore) SaveFeedFetch(ctx context.Context, f *FeedFetch) error {
+	query := `INSERT INTO feed_fetches
+		(podcast_id, start_time, end_time, http_status, bytes, num_parsed, num_updated, error)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	res, err := s.db.ExecContext(ctx, query, f.PodcastID, f.StartTime.UTC(), f.EndTime.UTC(), f.HTTPStatus, f.Bytes, f.NumParsed, f.NumUpdated, f.Error)
+	if err != nil {
+		return fmt.Errorf("error saving feed fetch: %w", err)
+	}
+	f.ID, _ = res.LastInsertId()
+
+	query = `DELETE FROM feed_fetches
+		WHERE podcast_id=?1 AND id NOT IN (
+			SELECT id FROM feed_fetches WHERE podcast_id=?1 ORDER BY start_time DESC LIMIT ?2)`
+	_, err = s.db.ExecContext(ctx, query, f.PodcastID, maxFeedFetches)
+	return err
+}
+
+func (s *sqliteStore) LoadFeedFetches(ctx context.Context, podcastID int64, limit int) ([]*FeedFetch, error) {
+	query := `SELECT id, podcast_id, start_time, end_time, http_status, bytes, num_parsed, num_updated, error
+		FROM feed_fetches
+		WHERE podcast_id=?
+		ORDER BY start_time DESC
+		LIMIT ?`
+	var fetches []*FeedFetch
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var f FeedFetch
+		if err := rows.Scan(&f.ID, &f.PodcastID, &f.StartTime, &f.EndTime, &f.HTTPStatus, &f.Bytes, &f.NumParsed, &f.NumUpdated, &f.Error); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		fetches = append(fetches, &f)
+		return nil
+	}, query, podcastID, limit)
+	return fetches, err
+}
+
+func (s *sqliteStore) SaveWebSubSubscription(ctx context.Context, sub *WebSubSubscription) error {
+	query := `INSERT INTO websub_subscriptions
+		  (podcast_id, hub_url, topic_url, secret, verified, requested_at, lease_expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (podcast_id) DO UPDATE SET
+		  hub_url=excluded.hub_url, topic_url=excluded.topic_url, secret=excluded.secret,
+		  verified=excluded.verified, requested_at=excluded.requested_at,
+		  lease_expires_at=excluded.lease_expires_at`
+	_, err := s.db.ExecContext(ctx, query, sub.PodcastID, sub.HubURL, sub.TopicURL, sub.Secret, sub.Verified, sub.RequestedAt.UTC(), utc(sub.LeaseExpiresAt))
+	if err != nil {
+		return fmt.Errorf("error saving websub subscription: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) LoadWebSubSubscription(ctx context.Context, podcastID int64) (*WebSubSubscription, error) {
+	subs, err := s.loadWebSubSubscriptions(ctx, "WHERE podcast_id=?", podcastID)
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return subs[0], nil
+}
+
+func (s *sqliteStore) LoadWebSubSubscriptions(ctx context.Context) ([]*WebSubSubscription, error) {
+	return s.loadWebSubSubscriptions(ctx, "")
+}
+
+func (s *sqliteStore) loadWebSubSubscriptions(ctx context.Context, where string, args ...interface{}) ([]*WebSubSubscription, error) {
+	query := `SELECT podcast_id, hub_url, topic_url, secret, verified, requested_at, lease_expires_at
+		FROM websub_subscriptions ` + where
+	var subs []*WebSubSubscription
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var sub WebSubSubscription
+		if err := rows.Scan(&sub.PodcastID, &sub.HubURL, &sub.TopicURL, &sub.Secret, &sub.Verified, &sub.RequestedAt, &sub.LeaseExpiresAt); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		subs = append(subs, &sub)
+		return nil
+	}, query, args...)
+	return subs, err
+}
+
+func (s *sqliteStore) DeleteWebSubSubscription(ctx context.Context, podcastID int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM websub_subscriptions WHERE podcast_id=?", podcastID)
+	return err
+}
+
+func (s *sqliteStore) LoadMediaCacheSettings(ctx context.Context, podcastID int64) (*MediaCacheSettings, error) {
+	settings, err := s.loadMediaCacheSettings(ctx, "WHERE podcast_id=?", podcastID)
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) == 0 {
+		return &MediaCacheSettings{PodcastID: podcastID, MaxEpisodes: DefaultMediaCacheEpisodes}, nil
+	}
+	return settings[0], nil
+}
+
+func (s *sqliteStore) LoadAllMediaCacheSettings(ctx context.Context) ([]*MediaCacheSettings, error) {
+	return s.loadMediaCacheSettings(ctx, "")
+}
+
+func (s *sqliteStore) loadMediaCacheSettings(ctx context.Context, where string, args ...interface{}) ([]*MediaCacheSettings, error) {
+	query := "SELECT podcast_id, enabled, max_episodes, max_bytes FROM media_cache_settings " + where
+	var all []*MediaCacheSettings
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var settings MediaCacheSettings
+		if err := rows.Scan(&settings.PodcastID, &settings.Enabled, &settings.MaxEpisodes, &settings.MaxBytes); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		all = append(all, &settings)
+		return nil
+	}, query, args...)
+	return all, err
+}
+
+func (s *sqliteStore) SaveMediaCacheSettings(ctx context.Context, settings *MediaCacheSettings) error {
+	query := `INSERT INTO media_cache_settings (podcast_id, enabled, max_episodes, max_bytes)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (podcast_id) DO UPDATE SET
+		  enabled=excluded.enabled, max_episodes=excluded.max_episodes, max_bytes=excluded.max_bytes`
+	if _, err := s.db.ExecContext(ctx, query, settings.PodcastID, settings.Enabled, settings.MaxEpisodes, settings.MaxBytes); err != nil {
+		return fmt.Errorf("error saving media cache settings: %w", err)
+	}
+	return nil
+}
+
+// renameEpisodeIfNeeded is the same as the PostgreSQL renameEpisodeIfNeeded (and
+// findRenamedEpisode).
+func (s *sqliteStore) renameEpisodeIfNeeded(ctx context.Context, tx *sql.Tx, podcastID int64, ep *Episode, currentGUIDs []string) error {
+	if currentGUIDs == nil {
+		return nil
+	}
+
+	var id int64
+	row := tx.QueryRowContext(ctx, "SELECT id FROM episodes WHERE podcast_id=? AND guid=?", podcastID, ep.GUID)
+	if err := row.Scan(&id); err == nil {
+		// We already have this one, nothing to do.
+		return nil
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	query := `SELECT id FROM episodes
+		WHERE podcast_id=? AND guid<>? AND guid NOT IN (SELECT value FROM json_each(?))
+		  AND ((media_url<>'' AND media_url=?) OR (title=? AND pub_date=?))
+		LIMIT 2`
+	rows, err := tx.QueryContext(ctx, query, podcastID, ep.GUID, jsonList(currentGUIDs), ep.MediaURL, ep.Title, ep.PubDate.UTC())
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(ids) != 1 {
+		return nil
+	}
+
+	log.Printf(" - episode %d has a new GUID: %s", ids[0], ep.GUID)
+	_, err = tx.ExecContext(ctx, "UPDATE episodes SET guid=? WHERE id=?", ep.GUID, ids[0])
+	return err
+}
+
+// mergeEpisodes is the same as the PostgreSQL mergeEpisodes. SQLite doesn't have DELETE ... USING,
+// so we use EXISTS instead.
+func (s *sqliteStore) mergeEpisodes(ctx context.Context, tx *sql.Tx, fromID, toID int64) error {
+	log.Printf(" - merging episode %d into %d", fromID, toID)
+
+	query := `DELETE FROM episode_progress
+		WHERE episode_id=?1 AND EXISTS (
+		  SELECT 1 FROM episode_progress t
+		  WHERE t.episode_id=?2 AND t.account_id=episode_progress.account_id
+		    AND episode_progress.last_updated <= t.last_updated)`
+	if _, err := tx.ExecContext(ctx, query, fromID, toID); err != nil {
+		return fmt.Errorf("error merging progress: %w", err)
+	}
+
+	query = `DELETE FROM episode_progress
+		WHERE episode_id=?2 AND account_id IN (SELECT account_id FROM episode_progress WHERE episode_id=?1)`
+	if _, err := tx.ExecContext(ctx, query, fromID, toID); err != nil {
+		return fmt.Errorf("error merging progress: %w", err)
+	}
+
+	query = "UPDATE episode_progress SET episode_id=?2 WHERE episode_id=?1"
+	if _, err := tx.ExecContext(ctx, query, fromID, toID); err != nil {
+		return fmt.Errorf("error merging progress: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM episodes WHERE id=?", fromID); err != nil {
+		return fmt.Errorf("error deleting merged episode: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) SaveEpisode(ctx context.Context, p *Podcast, ep *Episode, currentGUIDs []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.renameEpisodeIfNeeded(ctx, tx, p.ID, ep, currentGUIDs); err != nil {
+		return fmt.Errorf("error checking for renamed episode: %w", err)
+	}
+
+	// SQLite's upsert is the same as PostgreSQL's, except the new values are in "excluded" rather
+	// than being able to refer to the parameters again.
+	var query = `INSERT INTO episodes
+		       (guid, podcast_id, title, description, description_html, short_description, pub_date, media_url,
+		        duration_secs, season, episode_number, episode_type, explicit, image_url, chapters_url, chapters_type,
+		        media_length, media_type, description_policy)
+					 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+					 ON CONFLICT (podcast_id, guid) DO UPDATE SET
+					   title=excluded.title, description=excluded.description, description_html=excluded.description_html,
+					   short_description=excluded.short_description, pub_date=excluded.pub_date, media_url=excluded.media_url,
+					   duration_secs=COALESCE(excluded.duration_secs, episodes.duration_secs), season=excluded.season,
+					   episode_number=excluded.episode_number, episode_type=excluded.episode_type, explicit=excluded.explicit,
+					   image_url=excluded.image_url, chapters_url=excluded.chapters_url, chapters_type=excluded.chapters_type,
+					   media_length=excluded.media_length, media_type=excluded.media_type,
+					   description_policy=excluded.description_policy, removed_at=NULL
+					 RETURNING id`
+	row := tx.QueryRowContext(ctx, query, ep.GUID, p.ID, ep.Title, ep.Description, ep.DescriptionHTML, ep.ShortDescription, ep.PubDate.UTC(), ep.MediaURL,
+		ep.DurationSecs, ep.Season, ep.EpisodeNumber, ep.EpisodeType, ep.Explicit, ep.ImageURL, ep.ChaptersURL, ep.ChaptersType,
+		ep.MediaLength, ep.MediaType, ep.DescriptionPolicy)
+	var id int64
+	if err := row.Scan(&id); err != nil {
+		return err
+	}
+
+	if ep.ID != 0 && ep.ID != id {
+		// The episode we were given has been superseded by the one with this GUID, so merge it in.
+		if err := s.mergeEpisodes(ctx, tx, ep.ID, id); err != nil {
+			return err
+		}
+	}
+	ep.ID = id
+	ep.PodcastID = p.ID
+
+	if err := s.saveEpisodeMetadata(ctx, tx, ep); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) saveEpisodeMetadata(ctx context.Context, tx *sql.Tx, ep *Episode) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM episode_transcripts WHERE episode_id=?", ep.ID); err != nil {
+		return err
+	}
+	for _, t := range ep.Transcripts {
+		query := "INSERT INTO episode_transcripts (episode_id, url, type, language, rel) VALUES (?, ?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, ep.ID, t.URL, t.Type, t.Language, t.Rel); err != nil {
+			return fmt.Errorf("error saving transcript: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM episode_soundbites WHERE episode_id=?", ep.ID); err != nil {
+		return err
+	}
+	for _, sb := range ep.Soundbites {
+		query := "INSERT INTO episode_soundbites (episode_id, start_secs, duration_secs, title) VALUES (?, ?, ?, ?)"
+		if _, err := tx.ExecContext(ctx, query, ep.ID, sb.StartSecs, sb.DurationSecs, sb.Title); err != nil {
+			return fmt.Errorf("error saving soundbite: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM episode_enclosures WHERE episode_id=?", ep.ID); err != nil {
+		return err
+	}
+	for i, e := range ep.Enclosures {
+		query := `INSERT INTO episode_enclosures
+			  (episode_id, position, url, type, length, bitrate, height, language, title, rel, codecs, is_default)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, query, ep.ID, i, e.URL, e.Type, e.Length, e.Bitrate, e.Height, e.Language, e.Title, e.Rel, e.Codecs, e.IsDefault); err != nil {
+			return fmt.Errorf("error saving enclosure: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM persons WHERE episode_id=?", ep.ID); err != nil {
+		return err
+	}
+	for _, person := range ep.Persons {
+		if err := s.savePerson(ctx, tx, ep.PodcastID, &ep.ID, person); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadEpisodes runs the given query, which must select the episodeColumns followed by the three
+// progress columns.
+func (s *sqliteStore) loadEpisodes(ctx context.Context, query string, args ...interface{}) ([]*Episode, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return populateEpisodes(rows)
+}
+
+func (s *sqliteStore) LoadEpisode(ctx context.Context, p *Podcast, episodeID int64) (*Episode, error) {
+	query := "SELECT " + episodeColumns + ", NULL, NULL, NULL FROM episodes e WHERE e.id = ?"
+	ep, err := populateEpisode(s.db.QueryRowContext(ctx, query, episodeID))
+	if err != nil {
+		return nil, fmt.Errorf("error scanning row: %w", err)
+	}
+	return ep, nil
+}
+
+func (s *sqliteStore) LoadEpisodes(ctx context.Context, podcastID int64, limit int) ([]*Episode, error) {
+	query := `SELECT ` + episodeColumns + `, NULL, NULL, NULL
+		FROM episodes e
+		WHERE e.podcast_id = ?
+		ORDER BY e.pub_date DESC`
+	if limit > 0 {
+		return s.loadEpisodes(ctx, query+" LIMIT ?", podcastID, limit)
+	}
+	return s.loadEpisodes(ctx, query, podcastID)
+}
+
+func (s *sqliteStore) LoadEpisodeMetadata(ctx context.Context, episodes []*Episode) error {
+	if len(episodes) == 0 {
+		return nil
+	}
+
+	byID := make(map[int64]*Episode)
+	var ids []int64
+	for _, ep := range episodes {
+		ep.Transcripts = nil
+		ep.Persons = nil
+		ep.Soundbites = nil
+		ep.Enclosures = nil
+		ep.CachedMediaURL = ""
+		byID[ep.ID] = ep
+		ids = append(ids, ep.ID)
+	}
+	idList := jsonList(ids)
+
+	query := "SELECT episode_id, url, type, language, rel FROM episode_transcripts WHERE episode_id IN (SELECT value FROM json_each(?))"
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var id int64
+		var t Transcript
+		if err := rows.Scan(&id, &t.URL, &t.Type, &t.Language, &t.Rel); err != nil {
+			return fmt.Errorf("error scanning transcript: %w", err)
+		}
+		byID[id].Transcripts = append(byID[id].Transcripts, &t)
+		return nil
+	}, query, idList)
+	if err != nil {
+		return err
+	}
+
+	query = "SELECT episode_id, start_secs, duration_secs, title FROM episode_soundbites WHERE episode_id IN (SELECT value FROM json_each(?)) ORDER BY start_secs"
+	err = s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var id int64
+		var sb Soundbite
+		if err := rows.Scan(&id, &sb.StartSecs, &sb.DurationSecs, &sb.Title); err != nil {
+			return fmt.Errorf("error scanning soundbite: %w", err)
+		}
+		byID[id].Soundbites = append(byID[id].Soundbites, &sb)
+		return nil
+	}, query, idList)
+	if err != nil {
+		return err
+	}
+
+	query = `SELECT episode_id, url, type, length, bitrate, height, language, title, rel, codecs, is_default
+		FROM episode_enclosures WHERE episode_id IN (SELECT value FROM json_each(?)) ORDER BY position`
+	err = s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var id int64
+		var e Enclosure
+		if err := rows.Scan(&id, &e.URL, &e.Type, &e.Length, &e.Bitrate, &e.Height, &e.Language, &e.Title, &e.Rel, &e.Codecs, &e.IsDefault); err != nil {
+			return fmt.Errorf("error scanning enclosure: %w", err)
+		}
+		byID[id].Enclosures = append(byID[id].Enclosures, &e)
+		return nil
+	}, query, idList)
+	if err != nil {
+		return err
+	}
+
+	query = "SELECT episode_id FROM episode_media WHERE episode_id IN (SELECT value FROM json_each(?))"
+	err = s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("error scanning episode media: %w", err)
+		}
+		byID[id].CachedMediaURL = EpisodeMediaURL(id)
+		return nil
+	}, query, idList)
+	if err != nil {
+		return err
+	}
+
+	query = "SELECT episode_id, name, role, person_group, image_url, href FROM persons WHERE episode_id IN (SELECT value FROM json_each(?))"
+	return s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var id int64
+		var person Person
+		if err := rows.Scan(&id, &person.Name, &person.Role, &person.Group, &person.ImageURL, &person.Href); err != nil {
+			return fmt.Errorf("error scanning person: %w", err)
+		}
+		byID[id].Persons = append(byID[id].Persons, &person)
+		return nil
+	}, query, idList)
+}
+
+func (s *sqliteStore) LoadEpisodeGUIDs(ctx context.Context, podcastID int64) (map[string]bool, error) {
+	guids := make(map[string]bool)
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var guid string
+		if err := rows.Scan(&guid); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		guids[guid] = true
+		return nil
+	}, "SELECT guid FROM episodes WHERE podcast_id=?", podcastID)
+	return guids, err
+}
+
+func (s *sqliteStore) LoadEpisodesWithoutDuration(ctx context.Context, probedBefore time.Time, limit int) ([]*Episode, error) {
+	query := `SELECT ` + episodeColumns + `, NULL, NULL, NULL
+		FROM episodes e
+		WHERE e.duration_secs IS NULL
+		  AND e.media_url <> ''
+		  AND e.removed_at IS NULL
+		  AND (e.duration_probed_at IS NULL OR e.duration_probed_at < ?)
+		ORDER BY e.pub_date DESC
+		LIMIT ?`
+	return s.loadEpisodes(ctx, query, probedBefore.UTC(), limit)
+}
+
+func (s *sqliteStore) SaveEpisodeDuration(ctx context.Context, ep *Episode, probedAt time.Time) error {
+	query := "UPDATE episodes SET duration_secs=?, duration_probed_at=? WHERE id=?"
+	_, err := s.db.ExecContext(ctx, query, ep.DurationSecs, probedAt.UTC(), ep.ID)
+	return err
+}
+
+func (s *sqliteStore) LoadEpisodesByDescriptionPolicy(ctx context.Context, version, limit int) ([]*Episode, error) {
+	query := `SELECT id, description, description_html
+		FROM episodes
+		WHERE description_policy < ?
+		ORDER BY id
+		LIMIT ?`
+	var episodes []*Episode
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var ep Episode
+		if err := rows.Scan(&ep.ID, &ep.Description, &ep.DescriptionHTML); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		episodes = append(episodes, &ep)
+		return nil
+	}, query, version, limit)
+	return episodes, err
+}
+
+func (s *sqliteStore) UpdateEpisodeDescription(ctx context.Context, ep *Episode) error {
+	query := "UPDATE episodes SET description=?, description_policy=? WHERE id=?"
+	_, err := s.db.ExecContext(ctx, query, ep.Description, ep.DescriptionPolicy, ep.ID)
+	return err
+}
+
+func (s *sqliteStore) ReconcileEpisodes(ctx context.Context, podcastID int64, currentGUIDs []string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT old.id, cur.id
+		FROM episodes old
+		INNER JOIN episodes cur ON cur.podcast_id = old.podcast_id AND cur.id <> old.id
+		  AND ((cur.media_url<>'' AND cur.media_url=old.media_url) OR (cur.title=old.title AND cur.pub_date=old.pub_date))
+		WHERE old.podcast_id=?1
+		  AND old.guid NOT IN (SELECT value FROM json_each(?2))
+		  AND cur.guid IN (SELECT value FROM json_each(?2))`
+	rows, err := tx.QueryContext(ctx, query, podcastID, jsonList(currentGUIDs))
+	if err != nil {
+		return 0, err
+	}
+
+	// Only merge an old episode if it matches exactly one current episode, otherwise we can't be
+	// sure which one it is.
+	matches := make(map[int64][]int64)
+	for rows.Next() {
+		var oldID, curID int64
+		if err := rows.Scan(&oldID, &curID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("error scanning row: %w", err)
+		}
+		matches[oldID] = append(matches[oldID], curID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	numMerged := 0
+	for oldID, curIDs := range matches {
+		if len(curIDs) != 1 {
+			continue
+		}
+		if err := s.mergeEpisodes(ctx, tx, oldID, curIDs[0]); err != nil {
+			return 0, err
+		}
+		numMerged++
+	}
+
+	return numMerged, tx.Commit()
+}
+
+func (s *sqliteStore) MarkEpisodesRemoved(ctx context.Context, podcastID int64, currentGUIDs []string) (int64, error) {
+	query := `UPDATE episodes SET removed_at=?
+		WHERE podcast_id=? AND removed_at IS NULL AND guid NOT IN (SELECT value FROM json_each(?))`
+	res, err := s.db.ExecContext(ctx, query, time.Now().UTC(), podcastID, jsonList(currentGUIDs))
+	if err != nil {
+		return 0, fmt.Errorf("error marking episodes removed: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqliteStore) PurgeRemovedEpisodes(ctx context.Context, podcastID int64) (int64, error) {
+	query := `DELETE FROM episodes
+		WHERE podcast_id=? AND removed_at IS NOT NULL
+		  AND NOT EXISTS (SELECT 1 FROM episode_progress p WHERE p.episode_id = episodes.id)`
+	res, err := s.db.ExecContext(ctx, query, podcastID)
+	if err != nil {
+		return 0, fmt.Errorf("error purging removed episodes: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (s *sqliteStore) LoadChaptersSource(ctx context.Context, episodeID int64) (*ChaptersSource, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT chapters_source, chapters_etag, chapters_hash FROM episodes WHERE id=?", episodeID)
+	var source ChaptersSource
+	if err := row.Scan(&source.Source, &source.ETag, &source.Hash); err != nil {
+		return nil, fmt.Errorf("error scanning row: %w", err)
+	}
+	return &source, nil
+}
+
+func (s *sqliteStore) SaveChapters(ctx context.Context, episodeID int64, source *ChaptersSource, chapters []*Chapter) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM episode_chapters WHERE episode_id=?", episodeID); err != nil {
+		return err
+	}
+
+	for i, c := range chapters {
+		query := `INSERT INTO episode_chapters
+			(episode_id, chapter_index, start_secs, end_secs, title, url, image_url, toc)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, query, episodeID, i, c.StartSecs, c.EndSecs, c.Title, c.URL, c.ImageURL, c.TOC); err != nil {
+			return fmt.Errorf("error saving chapter: %w", err)
+		}
+	}
+
+	query := "UPDATE episodes SET chapters_source=?, chapters_etag=?, chapters_hash=? WHERE id=?"
+	if _, err := tx.ExecContext(ctx, query, source.Source, source.ETag, source.Hash, episodeID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStore) LoadChapters(ctx context.Context, episodeID int64) ([]*Chapter, error) {
+	query := `SELECT start_secs, end_secs, title, url, image_url, toc
+		FROM episode_chapters
+		WHERE episode_id=?
+		ORDER BY chapter_index`
+	var chapters []*Chapter
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var c Chapter
+		if err := rows.Scan(&c.StartSecs, &c.EndSecs, &c.Title, &c.URL, &c.ImageURL, &c.TOC); err != nil {
+			return fmt.Errorf("error scanning chapter: %w", err)
+		}
+		chapters = append(chapters, &c)
+		return nil
+	}, query, episodeID)
+	return chapters, err
+}
+
+func (s *sqliteStore) LoadTranscriptSource(ctx context.Context, episodeID int64) (string, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT transcript_source FROM episodes WHERE id=?", episodeID)
+	var source string
+	if err := row.Scan(&source); err != nil {
+		return "", fmt.Errorf("error scanning row: %w", err)
+	}
+	return source, nil
+}
+
+func (s *sqliteStore) SaveTranscript(ctx context.Context, episodeID int64, source string, segments []*TranscriptSegment) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM transcript_segments WHERE episode_id=?", episodeID); err != nil {
+		return err
+	}
+
+	for i, seg := range segments {
+		query := `INSERT INTO transcript_segments
+			(episode_id, segment_index, start_secs, end_secs, speaker, body)
+			VALUES (?, ?, ?, ?, ?, ?)`
+		if _, err := tx.ExecContext(ctx, query, episodeID, i, seg.StartSecs, seg.EndSecs, seg.Speaker, seg.Body); err != nil {
+			return fmt.Errorf("error saving transcript segment: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE episodes SET transcript_source=? WHERE id=?", source, episodeID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// likePattern returns a LIKE pattern that matches strings containing the given term.
+func likePattern(term string) string {
+	term = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+	return "%" + term + "%"
+}
+
+// SearchTranscripts has no full-text index to use, so it does the same simple word match as the
+// in-memory backend: every word (or quoted phrase) of the query has to be in the segment, and none
+// of the excluded ones. LIKE ignores case for us.
+func (s *sqliteStore) SearchTranscripts(ctx context.Context, query string, limit int) ([]*TranscriptSearchResult, error) {
+	include, exclude := parseSearchQuery(query)
+	if len(include) == 0 {
+		return nil, nil
+	}
+
+	var conditions []string
+	var args []interface{}
+	for _, term := range include {
+		conditions = append(conditions, `ts.body LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(term))
+	}
+	for _, term := range exclude {
+		conditions = append(conditions, `ts.body NOT LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(term))
+	}
+
+	stmt := `SELECT ` + episodeColumns + `, NULL, NULL, NULL, ts.start_secs, ts.end_secs, ts.body
+		FROM transcript_segments ts
+		INNER JOIN episodes e ON e.id = ts.episode_id
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY e.pub_date DESC, e.id, ts.start_secs
+		LIMIT ?`
+	var results []*TranscriptSearchResult
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		var hit TranscriptHit
+		var body string
+		ep, err := populateEpisode(rows, &hit.StartSecs, &hit.EndSecs, &body)
+		if err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		hit.Snippet = highlight(body, include)
+
+		// The rows are ordered by episode, so all of the hits for an episode are together.
+		if len(results) == 0 || results[len(results)-1].Episode.ID != ep.ID {
+			results = append(results, &TranscriptSearchResult{Episode: ep})
+		}
+		result := results[len(results)-1]
+		result.Hits = append(result.Hits, &hit)
+		return nil
+	}, stmt, append(args, limit)...)
+	return results, err
+}
+
+func (s *sqliteStore) SaveEpisodeMedia(ctx context.Context, m *EpisodeMedia) error {
+	query := `INSERT INTO episode_media (episode_id, size, content_type, sha256, cached_at, last_accessed_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (episode_id) DO UPDATE SET
+		  size=excluded.size, content_type=excluded.content_type, sha256=excluded.sha256,
+		  cached_at=excluded.cached_at, last_accessed_at=excluded.last_accessed_at`
+	_, err := s.db.ExecContext(ctx, query, m.EpisodeID, m.Size, m.ContentType, m.SHA256, m.CachedAt.UTC(), m.LastAccessedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("error saving episode media: %w", err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) LoadEpisodeMedia(ctx context.Context, episodeID int64) (*EpisodeMedia, error) {
+	media, err := s.loadEpisodeMedia(ctx, "WHERE m.episode_id=?", episodeID)
+	if err != nil || len(media) == 0 {
+		return nil, err
+	}
+	return media[0], nil
+}
+
+func (s *sqliteStore) LoadAllEpisodeMedia(ctx context.Context) ([]*EpisodeMedia, error) {
+	return s.loadEpisodeMedia(ctx, "ORDER BY m.last_accessed_at DESC")
+}
+
+func (s *sqliteStore) loadEpisodeMedia(ctx context.Context, where string, args ...interface{}) ([]*EpisodeMedia, error) {
+	query := "SELECT " + episodeMediaColumns + " FROM episode_media m INNER JOIN episodes e ON e.id = m.episode_id " + where
+	var media []*EpisodeMedia
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		m, err := scanEpisodeMedia(rows)
+		if err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		media = append(media, m)
+		return nil
+	}, query, args...)
+	return media, err
+}
+
+func (s *sqliteStore) TouchEpisodeMedia(ctx context.Context, episodeID int64, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE episode_media SET last_accessed_at=? WHERE episode_id=?", now.UTC(), episodeID)
+	return err
+}
+
+func (s *sqliteStore) DeleteEpisodeMedia(ctx context.Context, episodeID int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM episode_media WHERE episode_id=?", episodeID)
+	return err
+}
+
+func (s *sqliteStore) SaveEpisodeProgress(ctx context.Context, progress *EpisodeProgress) error {
+	now := time.Now()
+	if progress.LastUpdated.After(now) {
+		progress.LastUpdated = time.Now()
+	}
+	query := `INSERT INTO episode_progress
+		(account_id, episode_id, position_secs, episode_complete, last_updated)
+		VALUES (?, ?, ?, FALSE, ?)
+		ON CONFLICT (account_id, episode_id) DO UPDATE SET
+		position_secs=excluded.position_secs,
+		last_updated=excluded.last_updated`
+	_, err := s.db.ExecContext(ctx, query, progress.AccountID, progress.EpisodeID, progress.PositionSecs, progress.LastUpdated.UTC())
+	return err
+}
+
+func (s *sqliteStore) LoadEpisodesForSubscription(ctx context.Context, acct *Account, p *Podcast, page Page) ([]*Episode, string, error) {
+	after, err := page.cursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	query := `SELECT ` + episodeColumns + `, position_secs, episode_complete, episode_progress.last_updated
+		FROM episodes e
+		LEFT OUTER JOIN episode_progress ON e.id = episode_progress.episode_id AND episode_progress.account_id = ?
+		WHERE e.podcast_id = ?`
+	args := []interface{}{acct.ID, p.ID}
+	if after != nil {
+		query += " AND (e.pub_date, e.id) < (?, ?)"
+		args = append(args, after.PubDate.UTC(), after.ID)
+	}
+	query += " ORDER BY e.pub_date DESC, e.id DESC"
+	if limit := page.fetchLimit(); limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	episodes, err := s.loadEpisodes(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	episodes, next := episodesPage(page, episodes)
+	return episodes, next, nil
+}
+
+func (s *sqliteStore) LoadEpisodesNewAndInProgress(ctx context.Context, acct *Account, numDays int) (newEpisodes []*Episode, inProgress []*Episode, err error) {
+	query := `
+		SELECT ` + episodeColumns + `, position_secs, episode_complete, ep.last_updated
+		FROM episodes e
+		INNER JOIN subscriptions s ON s.podcast_id = e.podcast_id
+		LEFT JOIN episode_progress ep ON ep.episode_id = e.id AND ep.account_id = s.account_id
+		WHERE (pub_date > ? OR ep.position_secs IS NOT NULL)
+		  AND (e.removed_at IS NULL OR ep.position_secs IS NOT NULL)
+		  AND s.account_id = ?
+		ORDER BY pub_date DESC`
+	episodes, err := s.loadEpisodes(ctx, query, time.Now().Add(-time.Hour*24*time.Duration(numDays)).UTC(), acct.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, ep := range episodes {
+		if ep.Position == nil {
+			newEpisodes = append(newEpisodes, ep)
+		} else {
+			inProgress = append(inProgress, ep)
+		}
+	}
+	return newEpisodes, inProgress, nil
+}
+
+func (s *sqliteStore) GetMostRecentPlaybackState(ctx context.Context, acct *Account) (*Episode, error) {
+	query := `
+		SELECT ` + episodeColumns + `, position_secs, episode_complete, ep.last_updated
+		FROM episodes e
+		INNER JOIN subscriptions s ON s.podcast_id = e.podcast_id
+		INNER JOIN episode_progress ep ON ep.episode_id = e.id AND ep.account_id = s.account_id
+		WHERE s.account_id = ?
+		ORDER BY ep.last_updated DESC
+		LIMIT 1`
+	return populateEpisode(s.db.QueryRowContext(ctx, query, acct.ID))
+}
+
+func (s *sqliteStore) loadCronJobs(ctx context.Context, query string, args ...interface{}) ([]*CronJob, error) {
+	var jobs []*CronJob
+	err := s.forEachRow(ctx, func(rows *sql.Rows) error {
+		job := CronJob{}
+		if err := rows.Scan(&job.ID, &job.Name, &job.Schedule, &job.Enabled, &job.NextRun); err != nil {
+			return fmt.Errorf("error scanning row: %w", err)
+		}
+		jobs = append(jobs, &job)
+		return nil
+	}, query, args...)
+	return jobs, err
+}
+
+func (s *sqliteStore) LoadCrobJobs(ctx context.Context) ([]*CronJob, error) {
+	return s.loadCronJobs(ctx, "SELECT id, job_name, schedule, enabled, next_run FROM cron ORDER BY id ASC")
+}
+
+func (s *sqliteStore) LoadCrobJob(ctx context.Context, id int64) (*CronJob, error) {
+	jobs, err := s.loadCronJobs(ctx, "SELECT id, job_name, schedule, enabled, next_run FROM cron WHERE id=?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no such cron job: %d", id)
+	}
+	return jobs[0], nil
+}
+
+func (s *sqliteStore) GetTimeToNextCronJob(ctx context.Context, now time.Time) time.Duration {
+	// MIN(next_run) would lose the column's type, and we'd get a string back instead of a time.
+	query := "SELECT next_run FROM cron WHERE next_run IS NOT NULL ORDER BY next_run LIMIT 1"
+	var nextRunTime time.Time
+	if err := s.db.QueryRowContext(ctx, query).Scan(&nextRunTime); err != nil {
+		return 30 * time.Minute
+	}
+
+	// Return the amount of time we have to wait, not less than a second.
+	duration := nextRunTime.Sub(now)
+	if duration < time.Second {
+		duration = time.Second
+	}
+	return duration
+}
+
+func (s *sqliteStore) LoadPendingCronJobs(ctx context.Context, now time.Time) ([]*CronJob, error) {
+	query := "SELECT id, job_name, schedule, enabled, next_run FROM cron WHERE next_run < ?"
+	return s.loadCronJobs(ctx, query, now.UTC())
+}
+
+func (s *sqliteStore) DeleteCronJob(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM cron WHERE id = ?", id)
+	return err
+}
+
+func (s *sqliteStore) SaveCronJob(ctx context.Context, job *CronJob) error {
+	if job.ID == 0 {
+		query := "INSERT INTO cron (job_name, schedule, enabled, next_run) VALUES (?, ?, ?, ?)"
+		_, err := s.db.ExecContext(ctx, query, job.Name, job.Schedule, job.Enabled, utc(job.NextRun))
+		return err
+	} else {
+		query := "UPDATE cron SET job_name=?, schedule=?, enabled=?, next_run=? WHERE id=?"
+		_, err := s.db.ExecContext(ctx, query, job.Name, job.Schedule, job.Enabled, utc(job.NextRun), job.ID)
+		return err
+	}
+}
@@ -4,32 +4,45 @@ package store
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
+	"strings"
 
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
-var (
+// pgStore is the Backend that keeps everything in PostgreSQL.
+type pgStore struct {
 	pool *pgxpool.Pool
-)
+}
+
+// Setup connects to the database, and upgrades the schema to the latest version if necessary.
+// Returns the Backend to use for everything else.
+func Setup() (Backend, error) {
+	b, err := Connect()
+	if err != nil {
+		return nil, err
+	}
+	if err := MigrateSchema(context.Background(), b, MigrateOptions{Target: -1}); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
 
-func Setup() error {
+// Connect connects to the database given by the DATABASE_URL environment variable, without
+// touching the schema. This is normally a PostgreSQL URL, but it can also be "sqlite://" followed by
+// the path of a SQLite database (e.g. sqlite:///var/lib/podcreep.db), which is created if it doesn't
+// exist yet. Returns the Backend for whichever database it is.
+func Connect() (Backend, error) {
 	var ctx = context.Background()
-	var err error
 
 	dburl := os.Getenv("DATABASE_URL")
-	pool, err = pgxpool.Connect(ctx, dburl)
-	if err != nil {
-		return fmt.Errorf("unable to connect to database: %s %w", dburl, err)
+	if strings.HasPrefix(dburl, "sqlite://") {
+		return connectSQLite(strings.TrimPrefix(dburl, "sqlite://"))
 	}
 
-	// Check what version of the datastore we have, and upgrade it if nessecary.
-	version := GetCurrentSchemaVersion(ctx)
-	log.Printf("Got schema version %d", version)
-	if err := UpgradeSchema(ctx, version); err != nil {
-		return err
+	pool, err := pgxpool.Connect(ctx, dburl)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database: %s %w", dburl, err)
 	}
-
-	return nil
+	return &pgStore{pool: pool}, nil
 }
@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+	"unicode/utf8"
+)
+
+// TranscriptSegment is a single timestamped piece of an episode's transcript.
+type TranscriptSegment struct {
+	StartSecs float64  `json:"startSecs"`
+	EndSecs   *float64 `json:"endSecs"`
+	Speaker   string   `json:"speaker,omitempty"`
+	Body      string   `json:"body"`
+}
+
+// TranscriptHit is a single segment of a transcript that matched a search.
+type TranscriptHit struct {
+	StartSecs float64  `json:"startSecs"`
+	EndSecs   *float64 `json:"endSecs"`
+
+	// Snippet is the matching part of the segment as HTML: the text is escaped, and the matching
+	// words are wrapped in <b> tags.
+	Snippet string `json:"snippet"`
+}
+
+const (
+	// headlineStartSel and headlineStopSel are what we ask ts_headline to put around the matching
+	// words. It can't escape the rest of the text for us, so we use characters that won't be
+	// touched when we do it ourselves, and replace them with the real tags afterwards.
+	headlineStartSel = "\ue000"
+	headlineStopSel  = "\ue001"
+)
+
+// TranscriptSearchResult is an episode that matched a transcript search, along with all of the
+// places in the episode that matched.
+type TranscriptSearchResult struct {
+	Episode *Episode         `json:"episode"`
+	Hits    []*TranscriptHit `json:"hits"`
+}
+
+func (s *pgStore) LoadTranscriptSource(ctx context.Context, episodeID int64) (string, error) {
+	row := s.pool.QueryRow(ctx, "SELECT transcript_source FROM episodes WHERE id=$1", episodeID)
+	var source string
+	if err := row.Scan(&source); err != nil {
+		return "", fmt.Errorf("error scanning row: %w", err)
+	}
+	return source, nil
+}
+
+func (s *pgStore) SaveTranscript(ctx context.Context, episodeID int64, source string, segments []*TranscriptSegment) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM transcript_segments WHERE episode_id=$1", episodeID); err != nil {
+		return err
+	}
+
+	for i, seg := range segments {
+		sql := `INSERT INTO transcript_segments
+			(episode_id, segment_index, start_secs, end_secs, speaker, body)
+			VALUES ($1, $2, $3, $4, $5, $6)`
+		if _, err := tx.Exec(ctx, sql, episodeID, i, seg.StartSecs, seg.EndSecs, seg.Speaker, seg.Body); err != nil {
+			return fmt.Errorf("error saving transcript segment: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(ctx, "UPDATE episodes SET transcript_source=$1 WHERE id=$2", source, episodeID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (s *pgStore) SearchTranscripts(ctx context.Context, query string, limit int) ([]*TranscriptSearchResult, error) {
+	sql := `SELECT ` + episodeColumns + `, NULL, NULL, NULL, ts.start_secs, ts.end_secs,
+			ts_headline('english', ts.body, q, $3)
+		FROM transcript_segments ts
+		CROSS JOIN websearch_to_tsquery('english', $1) q
+		INNER JOIN episodes e ON e.id = ts.episode_id
+		WHERE ts.body_tsv @@ q
+		ORDER BY e.pub_date DESC, e.id, ts.start_secs
+		LIMIT $2`
+	options := "StartSel=" + headlineStartSel + ", StopSel=" + headlineStopSel + ", MinWords=10, MaxWords=30"
+	rows, _ := s.pool.Query(ctx, sql, query, limit, options)
+	defer rows.Close()
+
+	var results []*TranscriptSearchResult
+	for rows.Next() {
+		var hit TranscriptHit
+		ep, err := populateEpisode(rows, &hit.StartSecs, &hit.EndSecs, &hit.Snippet)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		hit.Snippet = headlineHTML(hit.Snippet)
+
+		// The rows are ordered by episode, so all of the hits for an episode are together.
+		if len(results) == 0 || results[len(results)-1].Episode.ID != ep.ID {
+			results = append(results, &TranscriptSearchResult{Episode: ep})
+		}
+		result := results[len(results)-1]
+		result.Hits = append(result.Hits, &hit)
+	}
+
+	return results, nil
+}
+
+// parseSearchQuery splits a web search style query into the (lower case) words and phrases we
+// want, and the ones we don't.
+func parseSearchQuery(query string) (include, exclude []string) {
+	query = strings.ToLower(query)
+	for len(query) > 0 {
+		query = strings.TrimLeft(query, " \t\n")
+		if query == "" {
+			break
+		}
+
+		excluded := false
+		if query[0] == '-' {
+			excluded = true
+			query = query[1:]
+		}
+
+		var term string
+		if strings.HasPrefix(query, `"`) {
+			end := strings.Index(query[1:], `"`)
+			if end < 0 {
+				term, query = query[1:], ""
+			} else {
+				term, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexAny(query, " \t\n")
+			if end < 0 {
+				term, query = query, ""
+			} else {
+				term, query = query[:end], query[end:]
+			}
+		}
+
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		if excluded {
+			exclude = append(exclude, term)
+		} else {
+			include = append(include, term)
+		}
+	}
+	return include, exclude
+}
+
+func containsAll(str string, terms []string) bool {
+	for _, term := range terms {
+		if !strings.Contains(str, term) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(str string, terms []string) bool {
+	for _, term := range terms {
+		if strings.Contains(str, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// headlineHTML turns the output of ts_headline into a snippet: the text is escaped, and then the
+// markers around the matching words are replaced with <b> tags.
+func headlineHTML(headline string) string {
+	headline = html.EscapeString(headline)
+	headline = strings.ReplaceAll(headline, headlineStartSel, "<b>")
+	return strings.ReplaceAll(headline, headlineStopSel, "</b>")
+}
+
+// foldPrefixLen returns the length in bytes of the prefix of str that matches term, ignoring case,
+// or -1 if str doesn't start with term. Changing case can change how many bytes a character takes,
+// so the length isn't necessarily the same as len(term).
+func foldPrefixLen(str, term string) int {
+	n := 0
+	for _, tr := range term {
+		if n >= len(str) {
+			return -1
+		}
+		sr, size := utf8.DecodeRuneInString(str[n:])
+		if sr != tr && !strings.EqualFold(string(sr), string(tr)) {
+			return -1
+		}
+		n += size
+	}
+	return n
+}
+
+// highlight turns str into a snippet: the text is escaped, and every occurrence of the given terms
+// (ignoring case) is wrapped in <b> tags.
+func highlight(str string, terms []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(str); {
+		matched := -1
+		for _, term := range terms {
+			if n := foldPrefixLen(str[i:], term); n > matched {
+				matched = n
+			}
+		}
+		if matched <= 0 {
+			_, size := utf8.DecodeRuneInString(str[i:])
+			sb.WriteString(html.EscapeString(str[i : i+size]))
+			i += size
+			continue
+		}
+		sb.WriteString("<b>")
+		sb.WriteString(html.EscapeString(str[i : i+matched]))
+		sb.WriteString("</b>")
+		i += matched
+	}
+	return sb.String()
+}
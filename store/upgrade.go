@@ -2,16 +2,213 @@ package store
 
 import (
 	"context"
+	"embed"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
-	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// migrationLockID is the key of the advisory lock we hold while migrating the schema, so that two
+// servers starting at the same time don't both try to do it.
+const migrationLockID = 0x706f64637265 // "podcre"
+
+var (
+	// schemaFS holds our schema scripts: the PostgreSQL ones in schema, and the SQLite ones in
+	// schema/sqlite. schema-NNN.sql upgrades the schema to version NNN, and the optional
+	// schema-NNN.down.sql takes it back down to version NNN-1.
+	//go:embed schema/*.sql schema/sqlite/*.sql
+	schemaFS embed.FS
+
+	schemaFileRegex = regexp.MustCompile(`^schema-([0-9]{3})(\.down)?\.sql$`)
 )
 
-// GetCurrentSchemaVersion gets the current version of the database schema. A completely fresh
-// database will have version of 0.
-func GetCurrentSchemaVersion(ctx context.Context) int {
-	row := pool.QueryRow(ctx, "SELECT version FROM schema_version")
+// Migration is a single version of the schema.
+type Migration struct {
+	Version int
+
+	// Up is the script that upgrades the schema to this version, and Down is the script that takes
+	// it back to the previous version. Down is empty if the migration can't be reversed.
+	Up   string
+	Down string
+}
+
+// MigrateOptions controls what MigrateSchema does.
+type MigrateOptions struct {
+	// Target is the version to migrate to. If it's less than zero, we migrate up to the latest
+	// version.
+	Target int
+
+	// DryRun means we just log the scripts we would run, without actually running them.
+	DryRun bool
+}
+
+// loadMigrations loads all of the embedded migrations in the given directory, in version order.
+func loadMigrations(dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(schemaFS, dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing schema scripts: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := schemaFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected schema script: %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := fs.ReadFile(schemaFS, dir+"/"+entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error reading schema script %s: %w", entry.Name(), err)
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+		if match[2] != "" {
+			m.Down = string(script)
+		} else {
+			m.Up = string(script)
+		}
+	}
+
+	var migrations []*Migration
+	for _, m := range byVersion {
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 || m.Up == "" {
+			return nil, fmt.Errorf("missing schema script for version %d", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// schemaBackend is implemented by the backends that keep their data in a database with a schema
+// that needs migrating.
+type schemaBackend interface {
+	// migrations returns all of the backend's migrations, in version order.
+	migrations() ([]*Migration, error)
+
+	// schemaVersion returns the current version of the schema, 0 for a completely fresh database.
+	schemaVersion(ctx context.Context) int
+
+	// migrateSchema locks the schema, so nobody else can migrate it at the same time, and runs the
+	// steps returned by plan (which is given the current version). Each step runs in its own
+	// transaction, along with the update of the version number.
+	migrateSchema(ctx context.Context, plan func(current int) ([]*migrationStep, error), dryRun bool) error
+}
+
+// migrationStep is a single script that we run to migrate the schema from one version to the next.
+type migrationStep struct {
+	// version is the version of the migration the script belongs to, and newVersion is the version
+	// the schema is at after we've run it.
+	version    int
+	script     string
+	newVersion int
+}
+
+// asSchemaBackend returns the given backend as a schemaBackend, or an error if it doesn't have a
+// schema (like the in-memory backend).
+func asSchemaBackend(b Backend) (schemaBackend, error) {
+	sb, ok := b.(schemaBackend)
+	if !ok {
+		return nil, fmt.Errorf("the backend doesn't have a schema")
+	}
+	return sb, nil
+}
+
+// GetCurrentSchemaVersion gets the current version of the given backend's database schema. A
+// completely fresh database will have version of 0.
+func GetCurrentSchemaVersion(ctx context.Context, b Backend) int {
+	sb, err := asSchemaBackend(b)
+	if err != nil {
+		return 0
+	}
+	return sb.schemaVersion(ctx)
+}
+
+// MigrateSchema migrates the given backend's database schema up (or down) to the version in the
+// given options.
+// Each migration runs in its own transaction, along with the update of the version number, so a
+// failed migration leaves us at the version before it. The schema is locked the whole time, so if
+// another server is migrating the schema at the same time, we wait for it to finish and then carry
+// on from wherever it got to.
+func MigrateSchema(ctx context.Context, b Backend, opts MigrateOptions) error {
+	sb, err := asSchemaBackend(b)
+	if err != nil {
+		return err
+	}
+	migrations, err := sb.migrations()
+	if err != nil {
+		return err
+	}
+	target := opts.Target
+	if target < 0 {
+		target = len(migrations)
+	}
+	if target > len(migrations) {
+		return fmt.Errorf("can't migrate to version %d, latest version is %d", target, len(migrations))
+	}
+
+	return sb.migrateSchema(ctx, func(current int) ([]*migrationStep, error) {
+		return planMigrations(migrations, current, target)
+	}, opts.DryRun)
+}
+
+// planMigrations returns the scripts we need to run to get from the current version of the schema
+// to the target version.
+func planMigrations(migrations []*Migration, current, target int) ([]*migrationStep, error) {
+	log.Printf("Got schema version %d", current)
+	if current > len(migrations) {
+		return nil, fmt.Errorf("schema version %d is newer than the latest we know about (%d)", current, len(migrations))
+	}
+
+	// Check that we can go all the way down before we start, so that we don't stop half way.
+	for v := current; v > target; v-- {
+		if migrations[v-1].Down == "" {
+			return nil, fmt.Errorf("can't migrate down from version %d, it has no down script", v)
+		}
+	}
+
+	var steps []*migrationStep
+	for v := current; v < target; v++ {
+		m := migrations[v]
+		steps = append(steps, &migrationStep{version: m.Version, script: m.Up, newVersion: m.Version})
+	}
+	for v := current; v > target; v-- {
+		m := migrations[v-1]
+		steps = append(steps, &migrationStep{version: m.Version, script: m.Down, newVersion: m.Version - 1})
+	}
+	return steps, nil
+}
+
+func (s *pgStore) migrations() ([]*Migration, error) {
+	return loadMigrations("schema")
+}
+
+func (s *pgStore) schemaVersion(ctx context.Context) int {
+	return pgSchemaVersion(ctx, s.pool)
+}
+
+func pgSchemaVersion(ctx context.Context, q interface {
+	QueryRow(context.Context, string, ...interface{}) pgx.Row
+}) int {
+	row := q.QueryRow(ctx, "SELECT version FROM schema_version")
 	var version int
 	if err := row.Scan(&version); err != nil {
 		// The error could be anything, but we'll assume it's just that the table doesn't exist. That
@@ -21,50 +218,92 @@ func GetCurrentSchemaVersion(ctx context.Context) int {
 	return version
 }
 
-// UpgradeSchema upgrades the current database schema to the latest version, starting from the
-// given current version.
-func UpgradeSchema(ctx context.Context, currentVersion int) error {
-	for {
-		script, err := loadSchemaUpgradeScript(ctx, currentVersion+1)
-		if err != nil {
-			if os.IsNotExist(err) {
-				log.Printf("Schema up-to-date at version %d", currentVersion)
+func (s *pgStore) migrateSchema(ctx context.Context, plan func(current int) ([]*migrationStep, error), dryRun bool) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection: %w", err)
+	}
+	defer conn.Release()
 
-				_, err := pool.Exec(ctx, "UPDATE schema_version SET version=$1", currentVersion)
-				return err
-			}
-			return fmt.Errorf("error loading schema script: %w", err)
+	// We hold an advisory lock while migrating, so that two servers starting at the same time don't
+	// both try to do it.
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID); err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
 		}
+	}()
 
-		_, err = pool.Exec(ctx, script)
-		if err != nil {
-			return fmt.Errorf("error executing command: %w", err)
+	steps, err := plan(pgSchemaVersion(ctx, conn))
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if dryRun {
+			logDryRun(step)
+			continue
+		}
+		if err := runPgMigration(ctx, conn, step); err != nil {
+			return err
 		}
-
-		currentVersion += 1
 	}
+
+	log.Printf("Schema up-to-date at version %d", pgSchemaVersion(ctx, conn))
+	return nil
 }
 
-// loadSchemaUpgradeScript loads the script to upgrade us to the given version. If the script does
-// not exist, returns an error that you can check for with os.IsNotExist -- any other errors are
-// real.
-func loadSchemaUpgradeScript(ctx context.Context, version int) (string, error) {
-	filename := fmt.Sprintf("store/schema/schema-%03d.sql", version)
-	f, err := os.Open(filename)
+func logDryRun(step *migrationStep) {
+	log.Printf("Would run script for version %d (to version %d):\n%s", step.version, step.newVersion, step.script)
+}
+
+// runPgMigration runs the given step, and sets the schema version to its newVersion, in a single
+// transaction.
+func runPgMigration(ctx context.Context, conn *pgxpool.Conn, step *migrationStep) error {
+	log.Printf("Running script for version %d (to version %d)", step.version, step.newVersion)
+	tx, err := conn.Begin(ctx)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			// We'll return the error anyway, but if it's something other than a not exists error, then
-			// it's probably bad...
-			log.Printf("Got something other than NotExist: %s", err.Error())
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, step.script); err != nil {
+		return fmt.Errorf("error running script for version %d: %w", step.version, err)
+	}
+	if step.newVersion > 0 {
+		// Version 1 creates the schema_version table, so going down to 0 drops it.
+		if _, err := tx.Exec(ctx, "UPDATE schema_version SET version=$1", step.newVersion); err != nil {
+			return fmt.Errorf("error updating schema version: %w", err)
 		}
-		return "", err
 	}
+	return tx.Commit(ctx)
+}
 
-	bytes, err := io.ReadAll(f)
+// PrintSchemaStatus writes the current schema version, and the state of each migration, to the
+// given writer.
+func PrintSchemaStatus(ctx context.Context, b Backend, w io.Writer) error {
+	sb, err := asSchemaBackend(b)
 	if err != nil {
-		return "", err
+		return err
+	}
+	migrations, err := sb.migrations()
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Loaded upgrade script %s", filename)
-	return string(bytes), nil
+	current := sb.schemaVersion(ctx)
+	fmt.Fprintf(w, "Current schema version: %d (latest: %d)\n", current, len(migrations))
+	for _, m := range migrations {
+		state := "pending"
+		if m.Version <= current {
+			state = "applied"
+		}
+		reversible := ""
+		if m.Down != "" {
+			reversible = ", reversible"
+		}
+		fmt.Fprintf(w, "  %03d: %s%s\n", m.Version, state, reversible)
+	}
+	return nil
 }
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WebSubSubscription is our subscription to a WebSub hub, which pushes updates to a podcast's feed
+// to us.
+type WebSubSubscription struct {
+	PodcastID int64
+
+	// HubURL is the URL of the hub, and TopicURL is the URL of the feed we subscribed to.
+	HubURL   string
+	TopicURL string
+
+	// Secret is the secret we gave the hub, which it uses to sign the content it sends us.
+	Secret string
+
+	// Verified is true once the hub has checked with us that we really asked for the subscription.
+	Verified bool
+
+	// RequestedAt is the time we last sent a subscription request to the hub.
+	RequestedAt time.Time
+
+	// LeaseExpiresAt is the time the subscription expires, unless we renew it. Null until the
+	// subscription is verified.
+	LeaseExpiresAt *time.Time
+}
+
+// IsActive returns true if the hub has verified the subscription and it hasn't expired yet.
+func (s *WebSubSubscription) IsActive(now time.Time) bool {
+	return s.Verified && s.LeaseExpiresAt != nil && s.LeaseExpiresAt.After(now)
+}
+
+func (s *pgStore) SaveWebSubSubscription(ctx context.Context, sub *WebSubSubscription) error {
+	sql := `INSERT INTO websub_subscriptions
+		  (podcast_id, hub_url, topic_url, secret, verified, requested_at, lease_expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (podcast_id) DO UPDATE SET
+		  hub_url=$2, topic_url=$3, secret=$4, verified=$5, requested_at=$6, lease_expires_at=$7`
+	_, err := s.pool.Exec(ctx, sql, sub.PodcastID, sub.HubURL, sub.TopicURL, sub.Secret, sub.Verified, sub.RequestedAt, sub.LeaseExpiresAt)
+	if err != nil {
+		return fmt.Errorf("error saving websub subscription: %w", err)
+	}
+	return nil
+}
+
+func (s *pgStore) LoadWebSubSubscription(ctx context.Context, podcastID int64) (*WebSubSubscription, error) {
+	subs, err := s.loadWebSubSubscriptions(ctx, "WHERE podcast_id=$1", podcastID)
+	if err != nil || len(subs) == 0 {
+		return nil, err
+	}
+	return subs[0], nil
+}
+
+func (s *pgStore) LoadWebSubSubscriptions(ctx context.Context) ([]*WebSubSubscription, error) {
+	return s.loadWebSubSubscriptions(ctx, "")
+}
+
+func (s *pgStore) loadWebSubSubscriptions(ctx context.Context, where string, args ...interface{}) ([]*WebSubSubscription, error) {
+	sql := `SELECT podcast_id, hub_url, topic_url, secret, verified, requested_at, lease_expires_at
+		FROM websub_subscriptions ` + where
+	rows, _ := s.pool.Query(ctx, sql, args...)
+	defer rows.Close()
+
+	var subs []*WebSubSubscription
+	for rows.Next() {
+		var sub WebSubSubscription
+		if err := rows.Scan(&sub.PodcastID, &sub.HubURL, &sub.TopicURL, &sub.Secret, &sub.Verified, &sub.RequestedAt, &sub.LeaseExpiresAt); err != nil {
+			return nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		subs = append(subs, &sub)
+	}
+	return subs, rows.Err()
+}
+
+func (s *pgStore) DeleteWebSubSubscription(ctx context.Context, podcastID int64) error {
+	_, err := s.pool.Exec(ctx, "DELETE FROM websub_subscriptions WHERE podcast_id=$1", podcastID)
+	return err
+}
@@ -0,0 +1,194 @@
+package websub
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/podcreep/server/fetch"
+	"github.com/podcreep/server/util"
+)
+
+// Hub is a minimal, in-memory WebSub hub. It's only meant as a stand-in for testing: point a test
+// feed's <atom:link rel="hub"> at it, and then publish to it with:
+//
+//	curl -d hub.mode=publish -d hub.url=<feed URL> <server>/websub/hub
+//
+// and it will fetch the feed and send it to everyone subscribed.
+type Hub struct {
+	mu   sync.Mutex
+	subs map[string]map[string]*hubSubscription
+}
+
+// hubSubscription is a single subscriber to a topic.
+type hubSubscription struct {
+	secret  string
+	expires time.Time
+}
+
+// NewHub creates a new, empty Hub.
+func NewHub() *Hub {
+	return &Hub{subs: make(map[string]map[string]*hubSubscription)}
+}
+
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch mode := r.PostForm.Get("hub.mode"); mode {
+	case "subscribe", "unsubscribe":
+		callback := r.PostForm.Get("hub.callback")
+		topic := r.PostForm.Get("hub.topic")
+		if callback == "" || topic == "" {
+			http.Error(w, "hub.callback and hub.topic are required", http.StatusBadRequest)
+			return
+		}
+		lease, err := strconv.Atoi(r.PostForm.Get("hub.lease_seconds"))
+		if err != nil || lease <= 0 {
+			lease = leaseSeconds
+		}
+
+		// Verification happens after we've responded, as the spec requires.
+		go h.verify(mode, callback, topic, r.PostForm.Get("hub.secret"), lease)
+		w.WriteHeader(http.StatusAccepted)
+	case "publish":
+		topic := r.PostForm.Get("hub.url")
+		if topic == "" {
+			topic = r.PostForm.Get("hub.topic")
+		}
+		if topic == "" {
+			http.Error(w, "hub.url is required", http.StatusBadRequest)
+			return
+		}
+
+		go h.publish(topic)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, fmt.Sprintf("unknown hub.mode: %s", mode), http.StatusBadRequest)
+	}
+}
+
+// verify checks with the subscriber that it really wants the given (un)subscription, and if so,
+// saves it.
+func (h *Hub) verify(mode, callback, topic, secret string, lease int) {
+	challenge, err := util.CreateCookie()
+	if err != nil {
+		log.Printf("hub: error creating challenge: %v", err)
+		return
+	}
+
+	u, err := url.Parse(callback)
+	if err != nil {
+		log.Printf("hub: invalid callback %s: %v", callback, err)
+		return
+	}
+	q := u.Query()
+	q.Set("hub.mode", mode)
+	q.Set("hub.topic", topic)
+	q.Set("hub.challenge", challenge)
+	q.Set("hub.lease_seconds", strconv.Itoa(lease))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		log.Printf("hub: error creating request: %v", err)
+		return
+	}
+	resp, err := fetch.Default.Do(req)
+	if err != nil {
+		log.Printf("hub: error verifying %s: %v", callback, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || string(body) != challenge {
+		log.Printf("hub: %s did not confirm %s to %s", callback, mode, topic)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if mode == "unsubscribe" {
+		delete(h.subs[topic], callback)
+		return
+	}
+	if h.subs[topic] == nil {
+		h.subs[topic] = make(map[string]*hubSubscription)
+	}
+	h.subs[topic][callback] = &hubSubscription{
+		secret:  secret,
+		expires: time.Now().Add(time.Duration(lease) * time.Second),
+	}
+	log.Printf("hub: %s subscribed to %s", callback, topic)
+}
+
+// publish fetches the given topic and sends it to everyone who is subscribed to it.
+func (h *Hub) publish(topic string) {
+	req, err := http.NewRequest("GET", topic, nil)
+	if err != nil {
+		log.Printf("hub: error creating request: %v", err)
+		return
+	}
+	resp, err := fetch.Default.Do(req)
+	if err != nil {
+		log.Printf("hub: error fetching %s: %v", topic, err)
+		return
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("hub: error reading %s: %v", topic, err)
+		return
+	}
+	contentType := resp.Header.Get("Content-Type")
+
+	h.mu.Lock()
+	subs := make(map[string]*hubSubscription)
+	for callback, sub := range h.subs[topic] {
+		if sub.expires.After(time.Now()) {
+			subs[callback] = sub
+		}
+	}
+	h.mu.Unlock()
+
+	for callback, sub := range subs {
+		req, err := http.NewRequest("POST", callback, bytes.NewReader(content))
+		if err != nil {
+			log.Printf("hub: error creating request: %v", err)
+			continue
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Link", fmt.Sprintf("<%s>; rel=\"self\"", topic))
+		if sub.secret != "" {
+			mac := hmac.New(sha256.New, []byte(sub.secret))
+			mac.Write(content)
+			req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+
+		resp, err := fetch.Default.Do(req)
+		if err != nil {
+			log.Printf("hub: error sending %s to %s: %v", topic, callback, err)
+			continue
+		}
+		resp.Body.Close()
+		log.Printf("hub: sent %s to %s, status %d", topic, callback, resp.StatusCode)
+
+		if resp.StatusCode == http.StatusGone {
+			h.mu.Lock()
+			delete(h.subs[topic], callback)
+			h.mu.Unlock()
+		}
+	}
+}
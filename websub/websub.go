@@ -0,0 +1,350 @@
+// Package websub implements the subscriber side of WebSub (https://www.w3.org/TR/websub/), so that
+// podcasts whose feeds advertise a hub get pushed to us as soon as they're updated, rather than
+// waiting for us to poll them.
+package websub
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/podcreep/server/fetch"
+	"github.com/podcreep/server/rss"
+	"github.com/podcreep/server/store"
+	"github.com/podcreep/server/util"
+)
+
+const (
+	// leaseSeconds is how long we ask the hub to keep our subscriptions for.
+	leaseSeconds = 10 * 24 * 60 * 60
+
+	// renewBefore is how long before a subscription expires that we'll renew it.
+	renewBefore = 24 * time.Hour
+
+	// retryAfter is how long we'll wait for a hub to verify a subscription before asking again.
+	retryAfter = time.Hour
+
+	// maxContentSize is the largest feed we'll accept from a hub.
+	maxContentSize = 20 * 1024 * 1024
+)
+
+var (
+	// publicURL is the URL the server can be reached at from the outside world, which we need to
+	// give hubs a callback URL. If it's empty, we don't subscribe to anything.
+	publicURL string
+)
+
+// server handles the requests that hubs send us.
+type server struct {
+	db store.Backend
+}
+
+// Setup is called from server.go and sets up our routes. The PUBLIC_URL environment variable must
+// be set to the server's public URL (e.g. "https://podcreep.com") for subscriptions to work. If
+// WEBSUB_LOCAL_HUB is set, we also run a stand-in hub at /websub/hub, for testing.
+func Setup(r *mux.Router, db store.Backend) error {
+	publicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicURL == "" {
+		log.Printf("PUBLIC_URL is not set, WebSub subscriptions are disabled.")
+	}
+
+	if os.Getenv("WEBSUB_LOCAL_HUB") != "" {
+		log.Printf("Running local WebSub hub at /websub/hub")
+		r.Handle("/websub/hub", NewHub()).Methods("POST")
+	}
+
+	s := &server{db: db}
+	r.HandleFunc("/websub/{id:[0-9]+}", s.handleVerifyGet).Methods("GET")
+	r.HandleFunc("/websub/{id:[0-9]+}", s.handleContentPost).Methods("POST")
+	return nil
+}
+
+// callbackURL returns the URL hubs should use to talk to us about the given podcast.
+func callbackURL(podcastID int64) string {
+	return fmt.Sprintf("%s/websub/%d", publicURL, podcastID)
+}
+
+// topicURL returns the URL we subscribe to for the given podcast: the feed's own idea of its URL if
+// it has one, otherwise the URL we fetch it from.
+func topicURL(p *store.Podcast) string {
+	if p.SelfURL != "" {
+		return p.SelfURL
+	}
+	return p.FeedURL
+}
+
+// RenewSubscriptions is run as a cron job. It subscribes to the hubs of any podcasts we don't have
+// a subscription for yet, and renews any subscriptions that are about to expire.
+func RenewSubscriptions(ctx context.Context, db store.PodcastStore) error {
+	if publicURL == "" {
+		log.Printf("PUBLIC_URL is not set, not subscribing to anything.")
+		return nil
+	}
+
+	podcasts, _, err := db.LoadPodcasts(ctx, store.Page{})
+	if err != nil {
+		return err
+	}
+	subs, err := db.LoadWebSubSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	subsByPodcast := make(map[int64]*store.WebSubSubscription)
+	for _, sub := range subs {
+		subsByPodcast[sub.PodcastID] = sub
+	}
+
+	now := time.Now()
+	for _, p := range podcasts {
+		sub := subsByPodcast[p.ID]
+		if p.HubURL == "" {
+			if sub != nil {
+				// The feed doesn't use a hub any more. We just forget about the subscription, the hub
+				// will stop sending to us when it expires (or when we reply with 410 Gone).
+				log.Printf("Podcast %d no longer has a hub, dropping subscription to %s", p.ID, sub.HubURL)
+				if err := db.DeleteWebSubSubscription(ctx, p.ID); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if sub != nil && (sub.HubURL != p.HubURL || sub.TopicURL != topicURL(p)) {
+			// The hub or topic has changed, so we need a whole new subscription.
+			sub = nil
+		}
+		if sub != nil {
+			if sub.IsActive(now.Add(renewBefore)) {
+				continue
+			}
+			if sub.RequestedAt.After(now.Add(-retryAfter)) {
+				// We've asked recently, and are still waiting for the hub to verify it.
+				continue
+			}
+		}
+
+		if err := subscribe(ctx, db, p, sub); err != nil {
+			// Don't let one bad hub stop us from subscribing to the others.
+			log.Printf("Error subscribing to %s for podcast %d: %v", p.HubURL, p.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// subscribe sends a subscription request for the given podcast to its hub. If existing is not nil,
+// this is a renewal of that subscription, which stays active (with the same secret) until the hub
+// verifies the renewal.
+func subscribe(ctx context.Context, db store.PodcastStore, p *store.Podcast, existing *store.WebSubSubscription) error {
+	sub := existing
+	if sub == nil {
+		secret, err := util.CreateCookie()
+		if err != nil {
+			return fmt.Errorf("error creating secret: %w", err)
+		}
+		sub = &store.WebSubSubscription{
+			PodcastID: p.ID,
+			HubURL:    p.HubURL,
+			TopicURL:  topicURL(p),
+			Secret:    secret,
+		}
+	}
+	sub.RequestedAt = time.Now()
+
+	// We have to save the subscription before we send the request, as the hub is allowed to verify
+	// it before it responds to us.
+	if err := db.SaveWebSubSubscription(ctx, sub); err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	form.Set("hub.callback", callbackURL(p.ID))
+	form.Set("hub.mode", "subscribe")
+	form.Set("hub.topic", sub.TopicURL)
+	form.Set("hub.secret", sub.Secret)
+	form.Set("hub.lease_seconds", strconv.Itoa(leaseSeconds))
+
+	log.Printf("Subscribing to %s at %s", sub.TopicURL, sub.HubURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", sub.HubURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := fetch.Default.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending subscription request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("hub rejected subscription: status=%d %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// handleVerifyGet handles the hub checking that we really did ask for a subscription (or that it
+// has denied our request).
+func (s *server) handleVerifyGet(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	podcastID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.URL.Query()
+	mode := q.Get("hub.mode")
+	topic := q.Get("hub.topic")
+
+	sub, err := s.db.LoadWebSubSubscription(ctx, podcastID)
+	if err != nil {
+		log.Printf("Error loading websub subscription: %v", err)
+		http.Error(w, "error loading subscription", http.StatusInternalServerError)
+		return
+	}
+	if sub == nil || sub.TopicURL != topic {
+		log.Printf("Hub asked to verify %s for podcast %d, but we don't want that", topic, podcastID)
+		http.NotFound(w, r)
+		return
+	}
+
+	switch mode {
+	case "subscribe":
+		lease, err := strconv.Atoi(q.Get("hub.lease_seconds"))
+		if err != nil || lease <= 0 {
+			lease = leaseSeconds
+		}
+		expires := time.Now().Add(time.Duration(lease) * time.Second)
+		sub.Verified = true
+		sub.LeaseExpiresAt = &expires
+		if err := s.db.SaveWebSubSubscription(ctx, sub); err != nil {
+			log.Printf("Error saving websub subscription: %v", err)
+			http.Error(w, "error saving subscription", http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Hub verified subscription to %s for podcast %d, expires %v", topic, podcastID, expires)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(q.Get("hub.challenge")))
+	case "denied":
+		// We'll ask again next time the cron job runs, in case it was something temporary.
+		log.Printf("Hub denied subscription to %s for podcast %d: %s", topic, podcastID, q.Get("hub.reason"))
+		w.WriteHeader(http.StatusOK)
+	default:
+		// We never unsubscribe, so anything else is not something we asked for.
+		http.NotFound(w, r)
+	}
+}
+
+// handleContentPost handles the hub sending us the new content of a feed we're subscribed to.
+func (s *server) handleContentPost(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	podcastID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub, err := s.db.LoadWebSubSubscription(ctx, podcastID)
+	if err != nil {
+		log.Printf("Error loading websub subscription: %v", err)
+		http.Error(w, "error loading subscription", http.StatusInternalServerError)
+		return
+	}
+	if sub == nil {
+		// Tell the hub we're not interested any more.
+		http.Error(w, "not subscribed", http.StatusGone)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxContentSize+1))
+	if err != nil {
+		http.Error(w, "error reading body", http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxContentSize {
+		http.Error(w, "too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// The spec says we have to accept content with a bad signature, but ignore it.
+	if !checkSignature(r.Header.Get("X-Hub-Signature"), sub.Secret, body) {
+		log.Printf("Ignoring content for podcast %d with invalid signature", podcastID)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	p, err := s.db.LoadPodcast(ctx, podcastID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	numUpdated, err := rss.IngestFeed(ctx, s.db, p, bytes.NewReader(body), r.Header.Get("Content-Type"))
+	if err != nil {
+		log.Printf("Error ingesting content for podcast %d: %v", podcastID, err)
+		http.Error(w, "error ingesting content", http.StatusInternalServerError)
+		return
+	}
+	log.Printf(" - updated %d episodes", numUpdated)
+
+	// We're as up-to-date as if we'd just fetched it, so the cron job doesn't need to poll it.
+	p.LastFetchTime = time.Now()
+	p.LastAttemptTime = p.LastFetchTime
+	if _, err := s.db.SavePodcast(ctx, p); err != nil {
+		log.Printf("Error saving podcast %d: %v", podcastID, err)
+		http.Error(w, "error saving podcast", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// checkSignature checks the given X-Hub-Signature header (e.g. "sha256=abcd...") against the HMAC
+// of the given body.
+func checkSignature(header, secret string, body []byte) bool {
+	parts := strings.SplitN(header, "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	method, sig := parts[0], parts[1]
+
+	var h func() hash.Hash
+	switch method {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}